@@ -3,12 +3,15 @@ package main
 import (
 	"context"
 	"flag"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/crd"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/exporter"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/health"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/tracing"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"gopkg.in/yaml.v2"
-	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -16,24 +19,21 @@ import (
 )
 
 var (
-	conf = flag.String("conf", "config.yaml", "The config path file")
+	conf           = flag.String("conf", "config.yaml", "The config path file")
+	metricsAddress = flag.String("metrics-address", ":2112", "The address to serve the metrics on, empty to disable")
+	healthAddress  = flag.String("health-address", ":8080", "The address to serve /healthz and /readyz on, empty to disable")
 )
 
+// leaderElectionHealthTimeout is how long the leader can fail to renew the lease before it is reported as unhealthy
+const leaderElectionHealthTimeout = 20 * time.Second
+
 func main() {
 	flag.Parse()
-	b, err := ioutil.ReadFile(*conf)
-
+	b, loaded, err := readConfig(*conf)
 	if err != nil {
 		log.Fatal().Err(err).Msg("cannot read config file")
 	}
-
-	b = []byte(os.ExpandEnv(string(b)))
-
-	var cfg exporter.Config
-	err = yaml.Unmarshal(b, &cfg)
-	if err != nil {
-		log.Fatal().Err(err).Msg("cannot parse config to YAML")
-	}
+	cfg := *loaded
 
 	log.Logger = log.With().Caller().Logger().Level(zerolog.DebugLevel)
 
@@ -57,6 +57,15 @@ func main() {
 		log.Fatal().Str("log_format", cfg.LogFormat).Msg("Unknown log format")
 	}
 
+	if err := cfg.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+
+	shutdownTracing, err := tracing.Setup(cfg.Tracing)
+	if err != nil {
+		log.Fatal().Err(err).Msg("cannot set up tracing")
+	}
+
 	if cfg.ThrottlePeriod == 0 {
 		cfg.ThrottlePeriod = 5
 	}
@@ -66,28 +75,139 @@ func main() {
 		log.Fatal().Err(err).Msg("cannot get kubeconfig")
 	}
 
-	engine := exporter.NewEngine(&cfg, &exporter.ChannelBasedReceiverRegistry{})
-	w := kube.NewEventWatcher(kubeconfig, cfg.Namespace, cfg.ThrottlePeriod, engine.OnEvent)
+	if *metricsAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			log.Info().Str("address", *metricsAddress).Msg("Serving metrics")
+			if err := http.ListenAndServe(*metricsAddress, mux); err != nil {
+				log.Fatal().Err(err).Msg("cannot serve metrics")
+			}
+		}()
+	}
+
+	checker := &health.Checker{}
+	tracker := health.NewReceiverTracker(cfg.Health)
+	checker.AddReadinessSet(tracker.Check)
+
+	if *healthAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/healthz", checker.LivenessHandler())
+			mux.Handle("/readyz", checker.ReadinessHandler())
+			log.Info().Str("address", *healthAddress).Msg("Serving health checks")
+			if err := http.ListenAndServe(*healthAddress, mux); err != nil {
+				log.Fatal().Err(err).Msg("cannot serve health checks")
+			}
+		}()
+	}
+
+	engine := exporter.NewEngine(&cfg, &exporter.ChannelBasedReceiverRegistry{Tracker: tracker})
+	checker.AddReadinessSet(engine.CheckCircuits)
 
 	ctx, cancel := context.WithCancel(context.Background())
-	leaderLost := make(chan bool)
+	if cfg.CustomResources.Enabled {
+		// The route and the receivers come from the custom resources, they are applied before the events are watched
+		ctrl, err := crd.NewController(cfg.CustomResources, kubeconfig, engine)
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot create custom resource controller")
+		}
+		if err := ctrl.Start(ctx); err != nil {
+			log.Fatal().Err(err).Msg("cannot watch custom resources")
+		}
+	} else {
+		reloader := &configReloader{path: *conf, engine: engine, current: &cfg, content: b}
+		if err := reloader.run(ctx); err != nil {
+			log.Fatal().Err(err).Msg("cannot watch config file")
+		}
+	}
+	w := kube.NewEventWatcher(kubeconfig, cfg.Namespace, cfg.ThrottlePeriod, engine.OnEvent)
+	checker.AddLiveness("eventWatch", w.CheckWatch)
+	checker.AddReadiness("eventInformer", w.CheckSynced)
+
+	var dw *kube.DeletionWatcher
+	if len(cfg.WatchDeletions) > 0 {
+		dw, err = kube.NewDeletionWatcher(kubeconfig, cfg.Namespace, cfg.WatchDeletions, engine.OnEvent)
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot create deletion watcher")
+		}
+		checker.AddReadiness("deletionInformers", dw.CheckSynced)
+	}
+
+	// startWatchers replays the cached events which happened at or after since, a zero since replays all of them
+	startWatchers := func(since time.Time) {
+		w.Start(since)
+		if dw != nil {
+			dw.Start()
+		}
+	}
+
+	stopWatchers := func() {
+		w.Stop()
+		if dw != nil {
+			dw.Stop()
+		}
+	}
+
+	// coordinationDone is closed when the replica leaves the leader election or the shard group
+	coordinationDone := make(chan struct{})
 	if cfg.LeaderElection.Enabled {
-		l, err := kube.NewLeaderElector(cfg.LeaderElection.LeaderElectionID, kubeconfig,
-			func(_ context.Context) {
-				log.Info().Msg("leader election got")
-				w.Start()
+		l, err := kube.NewLeaderElector(cfg.LeaderElection, kubeconfig,
+			func(ctx context.Context, since time.Time) {
+				log.Info().Time("since", since).Msg("leader election got")
+				// The previous leader exported the events until it stopped renewing the lock
+				startWatchers(since)
+				// The context is cancelled as soon as the leadership is lost
+				<-ctx.Done()
+				stopWatchers()
 			},
 			func() {
-				log.Error().Msg("leader election lost")
-				leaderLost <- true
+				log.Warn().Msg("leader election lost, continuing as standby")
+				stopWatchers()
 			},
 		)
 		if err != nil {
 			log.Fatal().Err(err).Msg("create leaderelector failed")
 		}
-		go l.Run(ctx)
+		checker.AddLiveness("leaderElection", func() error {
+			return l.Check(leaderElectionHealthTimeout)
+		})
+
+		// Standby replicas keep the informers running, so that they can take over with warm caches
+		w.Run()
+		if dw != nil {
+			dw.Run()
+		}
+
+		go func() {
+			defer close(coordinationDone)
+			kube.RunLeaderElection(ctx, l)
+		}()
+	} else if cfg.Sharding.Enabled {
+		sharder, err := kube.NewSharder(cfg.Sharding, kubeconfig)
+		if err != nil {
+			log.Fatal().Err(err).Msg("create sharder failed")
+		}
+
+		checker.AddReadiness("sharding", sharder.Check)
+		w.SetFilter(sharder.Owns)
+		if dw != nil {
+			dw.SetFilter(sharder.Owns)
+		}
+		// Pick up the recent events of the keys taken over from another replica since it stopped exporting them
+		sharder.OnRebalance(w.Replay)
+
+		if err := sharder.Start(ctx); err != nil {
+			log.Fatal().Err(err).Msg("cannot join the shard group")
+		}
+		go func() {
+			<-sharder.Done()
+			close(coordinationDone)
+		}()
+		startWatchers(time.Time{})
 	} else {
-		w.Start()
+		close(coordinationDone)
+		startWatchers(time.Time{})
 	}
 
 	c := make(chan os.Signal, 1)
@@ -95,19 +215,23 @@ func main() {
 
 	gracefulExit := func() {
 		defer close(c)
-		defer close(leaderLost)
+		stopWatchers()
+		// Cancelling releases the leadership or the shard, wait for it so that another replica can take over immediately
 		cancel()
-		w.Stop()
+		<-coordinationDone
+		w.Close()
+		if dw != nil {
+			dw.Close()
+		}
 		engine.Stop()
+		// The spans of the last sends are flushed after the sinks are closed
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error().Err(err).Msg("Cannot flush the traces")
+		}
 		log.Info().Msg("Exiting")
 	}
 
-	select {
-	case sig := <-c:
-		log.Info().Str("signal", sig.String()).Msg("Received signal to exit")
-		gracefulExit()
-	case <-leaderLost:
-		log.Warn().Msg("Leader election lost")
-		gracefulExit()
-	}
+	sig := <-c
+	log.Info().Str("signal", sig.String()).Msg("Received signal to exit")
+	gracefulExit()
 }
@@ -2,124 +2,402 @@ package batch
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+)
+
+const (
+	defaultConcurrency    = 1
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+	// defaultBufferBatches is the number of full batches the writer holds by default
+	defaultBufferBatches = 10
 )
 
+// ErrStopped is the error of the items that are submitted after the writer is stopped
+var ErrStopped = errors.New("batch writer is stopped")
+
+// Status tells what the writer does with an item after the handler
+type Status int
+
+const (
+	// Succeeded items are done
+	Succeeded Status = iota
+	// Retryable items are sent again after a backoff, until the max retries
+	Retryable
+	// Permanent items are dropped right away since sending them again cannot help
+	Permanent
+	// RateLimited items are sent again after the longest of the backoff and their RetryAfter
+	RateLimited
+)
+
+// Result is the outcome of an item in a batch
+type Result struct {
+	Status     Status
+	Err        error
+	RetryAfter time.Duration
+}
+
+// Success is the result of an item that is sent
+func Success() Result {
+	return Result{Status: Succeeded}
+}
+
+// Retry is the result of an item that failed and can be sent again
+func Retry(err error) Result {
+	return Result{Status: Retryable, Err: err}
+}
+
+// Fail is the result of an item that failed for good
+func Fail(err error) Result {
+	return Result{Status: Permanent, Err: err}
+}
 
-// Writer allows to buffer some items and call the Handler function either when the buffer is full or the timeout is
-// reached. There will also be support for concurrency for high volume. The handler function is supposed to return an
-// array of booleans to indicate whether the transfer was successful or not. It can be replaced with status codes in
-// the future to differentiate I/O errors, rate limiting, authorization issues.
+// Throttle is the result of an item that the downstream asked to send later, retryAfter is zero when it did not tell
+func Throttle(err error, retryAfter time.Duration) Result {
+	return Result{Status: RateLimited, Err: err, RetryAfter: retryAfter}
+}
+
+// Writer allows to buffer some items and call the Handler function either when the buffer has a full batch or the
+// interval is reached. Up to Concurrency batches are handled at the same time. The handler returns a result for each
+// item: the failed items are sent again in a later batch with an exponential backoff, and the items that are given up
+// are passed to OnDrop. Submit blocks while the writer holds BufferSize items, including the ones waiting for a retry.
+// The items are sent in order only when Concurrency is 1.
 type Writer struct {
-	cfg      WriterConfig
-	Handler  Callback
-	buffer   []bufferItem
-	len      int
-	done     chan bool
-	stopDone chan bool
+	cfg     WriterConfig
+	Handler Callback
+
 	items    chan interface{}
+	results  chan handled
+	done     chan struct{}
+	stopDone chan struct{}
+
+	// The fields below are owned by the loop goroutine
+	held     int
+	inFlight int
+	pending  []*bufferItem
+	bytes    int
+	waiting  []*bufferItem
+	timer    *time.Timer
+	armed    bool
+	armedAt  time.Time
 }
 
 type bufferItem struct {
 	v       interface{}
+	size    int
 	attempt int
+	readyAt time.Time
+}
+
+// handled is a batch and the results of its items
+type handled struct {
+	items   []*bufferItem
+	results []Result
 }
 
-type Callback func(ctx context.Context, items []interface{}) []bool
+// Callback handles a batch, it returns the result of each item in the same order. The context is done when the
+// Timeout of the writer passes.
+type Callback func(ctx context.Context, items []interface{}) []Result
+
+// DropCallback is called for each item that the writer gives up, err is its last error
+type DropCallback func(item interface{}, err error)
 
 type WriterConfig struct {
-	BatchSize  int
+	// Name labels the retry and drop metrics of the writer
+	Name string
+	// BatchSize is the max number of items in a batch
+	BatchSize int
+	// MaxBytes is the max size of a batch as measured by Size, it is not limited when either is not set. A single item
+	// bigger than MaxBytes is sent in its own batch.
+	MaxBytes int
+	Size     func(item interface{}) int
+	// MaxRetries is the number of times an item is sent again before it is dropped
 	MaxRetries int
-	Interval   time.Duration
-	Timeout    time.Duration
+	// Interval is the max time between the batches when the items do not fill one
+	Interval time.Duration
+	// Timeout limits each call of the handler, it is not limited when not set
+	Timeout time.Duration
+	// Concurrency is the max number of batches handled at the same time, 1 by default
+	Concurrency int
+	// InitialBackoff is the wait before the first retry of an item, it doubles with each retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// BufferSize is the max number of items held by the writer, 10 batches by default
+	BufferSize int
+	// OnDrop is called for each dropped item
+	OnDrop DropCallback
 }
 
 func NewWriter(cfg WriterConfig, cb Callback) *Writer {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaultInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = cfg.InitialBackoff
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultBufferBatches * cfg.BatchSize
+	}
+	// A buffer smaller than a batch would never fill one
+	if cfg.BufferSize < cfg.BatchSize {
+		cfg.BufferSize = cfg.BatchSize
+	}
+
 	return &Writer{
 		cfg:     cfg,
 		Handler: cb,
-		buffer:  make([]bufferItem, cfg.BatchSize),
 	}
 }
 
-// Indicates the start to accept the
+// Start starts accepting the items
 func (w *Writer) Start() {
-	w.done = make(chan bool)
 	w.items = make(chan interface{})
-	w.stopDone = make(chan bool)
+	w.results = make(chan handled, w.cfg.Concurrency)
+	w.done = make(chan struct{})
+	w.stopDone = make(chan struct{})
+	w.timer = time.NewTimer(time.Hour)
+	w.timer.Stop()
+
+	go w.loop()
+}
+
+func (w *Writer) loop() {
 	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	defer w.timer.Stop()
+	defer close(w.stopDone)
 
-	go func() {
-		shouldGoOn := true
-		for shouldGoOn {
-			select {
-			case item := <-w.items:
-				if w.len >= w.cfg.BatchSize {
-					w.processBuffer(context.Background())
-					w.len = 0
-				}
+	done := w.done
+	stopping := false
+	for {
+		// Only the full batches are sent before the interval, and every item when the writer stops
+		w.dispatch(stopping)
+		if stopping && w.held == 0 {
+			return
+		}
+		w.arm()
+
+		// New items are not accepted while the buffer is full, the retries and the results still make progress
+		items := w.items
+		if stopping || w.held >= w.cfg.BufferSize {
+			items = nil
+		}
 
-				w.buffer[w.len] = bufferItem{v: item, attempt: 0}
-				w.len++
-			case <-w.done:
-				w.processBuffer(context.Background())
-				shouldGoOn = false
-				w.stopDone <- true
-				ticker.Stop()
-			case <-ticker.C:
-				w.processBuffer(context.Background())
+		select {
+		case v := <-items:
+			w.add(&bufferItem{v: v, size: w.size(v)})
+			w.held++
+		case h := <-w.results:
+			w.inFlight--
+			w.handle(h, stopping)
+		case <-w.timer.C:
+			w.armed = false
+			w.promote(time.Now())
+		case <-ticker.C:
+			w.dispatch(true)
+		case <-done:
+			stopping = true
+			done = nil
+			// The items waiting for a retry do not wait for their backoff anymore
+			for _, item := range w.waiting {
+				item.readyAt = time.Time{}
 			}
+			w.promote(time.Now())
 		}
-	}()
+	}
 }
 
-func (w *Writer) processBuffer(ctx context.Context) {
-	if w.len == 0 {
-		return
+func (w *Writer) size(v interface{}) int {
+	if w.cfg.Size == nil {
+		return 0
 	}
+	return w.cfg.Size(v)
+}
+
+func (w *Writer) add(item *bufferItem) {
+	w.pending = append(w.pending, item)
+	w.bytes += item.size
+}
 
-	// Need to copy the underlying item to another slice
-	slice := make([]interface{}, w.len)
-	for i := 0; i < w.len; i++ {
-		slice[i] = w.buffer[i].v
+// full reports whether the pending items make a full batch
+func (w *Writer) full() bool {
+	if len(w.pending) >= w.cfg.BatchSize {
+		return true
 	}
+	return w.cfg.MaxBytes > 0 && w.cfg.Size != nil && w.bytes >= w.cfg.MaxBytes
+}
 
-	// Call the actual method
-	responses := w.Handler(ctx, slice)
+// dispatch sends the full batches while there is room for them, or all pending items when all is set
+func (w *Writer) dispatch(all bool) {
+	for len(w.pending) > 0 && w.inFlight < w.cfg.Concurrency && (all || w.full()) {
+		w.send(w.take())
+	}
+}
 
-	// Overwriting buffer here. Since the newItemsCount will always be equal to or smaller than buffer, it's safe to
-	// overwrite the existing items whilst traversing the items.
-	var newItemsCount int
-	for idx, success := range responses {
-		if !success {
-			item := w.buffer[idx]
-			if item.attempt >= w.cfg.MaxRetries {
-				// It's dropped, sorry you asked for it
-				continue
-			}
+// take removes the next batch from the pending items
+func (w *Writer) take() []*bufferItem {
+	n, bytes := 0, 0
+	for n < len(w.pending) && n < w.cfg.BatchSize {
+		size := w.pending[n].size
+		if n > 0 && w.cfg.MaxBytes > 0 && bytes+size > w.cfg.MaxBytes {
+			break
+		}
+		bytes += size
+		n++
+	}
 
-			w.buffer[newItemsCount] = bufferItem{
-				v:       item.v,
-				attempt: item.attempt + 1,
-			}
+	batch := make([]*bufferItem, n)
+	copy(batch, w.pending)
+	// The slots are cleared so that the items can be garbage collected
+	for i := 0; i < n; i++ {
+		w.pending[i] = nil
+	}
+	w.pending = w.pending[n:]
+	w.bytes -= bytes
+	return batch
+}
+
+func (w *Writer) send(batch []*bufferItem) {
+	w.inFlight++
+	values := make([]interface{}, len(batch))
+	for i, item := range batch {
+		values[i] = item.v
+	}
+
+	go func() {
+		ctx := context.Background()
+		if w.cfg.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, w.cfg.Timeout)
+			defer cancel()
+		}
+
+		w.results <- handled{items: batch, results: w.Handler(ctx, values)}
+	}()
+}
+
+// handle retries or drops the failed items of a batch
+func (w *Writer) handle(h handled, stopping bool) {
+	now := time.Now()
+	for i, item := range h.items {
+		res := Retry(fmt.Errorf("no result for the item in a batch of %d", len(h.items)))
+		if i < len(h.results) {
+			res = h.results[i]
+		}
 
-			newItemsCount++
+		switch res.Status {
+		case Succeeded:
+			w.held--
+			continue
+		case Permanent:
+			w.drop(item, res.Err)
+			continue
 		}
+		if item.attempt >= w.cfg.MaxRetries {
+			w.drop(item, res.Err)
+			continue
+		}
+
+		metrics.BatchRetries.WithLabelValues(w.cfg.Name).Inc()
+		item.attempt++
+		wait := w.backoff(item.attempt)
+		if res.Status == RateLimited && res.RetryAfter > wait {
+			wait = res.RetryAfter
+		}
+		if stopping {
+			wait = 0
+		}
+		item.readyAt = now.Add(wait)
+		w.waiting = append(w.waiting, item)
 	}
+	w.promote(now)
+}
 
-	w.len = newItemsCount
-	// TODO(makin) an edge case, if all items fail, and the buffer is full, new item cannot be added to buffer.
+func (w *Writer) drop(item *bufferItem, err error) {
+	w.held--
+	metrics.BatchDropped.WithLabelValues(w.cfg.Name).Inc()
+	if w.cfg.OnDrop != nil {
+		w.cfg.OnDrop(item.v, err)
+	}
+}
+
+// backoff is the wait before the given retry
+func (w *Writer) backoff(retry int) time.Duration {
+	wait := float64(w.cfg.InitialBackoff) * math.Pow(2, float64(retry-1))
+	return time.Duration(math.Min(wait, float64(w.cfg.MaxBackoff)))
 }
 
-// Used to signal writer to stop processing items and exit.
+// promote moves the items whose backoff is over at now to the pending items, in the order they failed
+func (w *Writer) promote(now time.Time) {
+	waiting := w.waiting[:0]
+	for _, item := range w.waiting {
+		if item.readyAt.After(now) {
+			waiting = append(waiting, item)
+			continue
+		}
+		w.add(item)
+	}
+	for i := len(waiting); i < len(w.waiting); i++ {
+		w.waiting[i] = nil
+	}
+	w.waiting = waiting
+}
+
+// arm sets the timer to the end of the earliest backoff
+func (w *Writer) arm() {
+	if len(w.waiting) == 0 {
+		return
+	}
+	next := w.waiting[0].readyAt
+	for _, item := range w.waiting[1:] {
+		if item.readyAt.Before(next) {
+			next = item.readyAt
+		}
+	}
+	if w.armed && !next.Before(w.armedAt) {
+		return
+	}
+
+	if w.armed && !w.timer.Stop() {
+		<-w.timer.C
+	}
+	w.timer.Reset(time.Until(next))
+	w.armed = true
+	w.armedAt = next
+}
+
+// Stop sends the held items and waits for them. The backoff of the failed items is skipped, they are still sent again
+// up to the max retries.
 func (w *Writer) Stop() {
-	w.done <- true
+	close(w.done)
 	<-w.stopDone
 }
 
-// Submit pushes the items to the income buffer and they are placed onto the actual buffer from there.
+// Submit pushes the items to the writer, it blocks while the buffer of the writer is full. The items submitted after
+// Stop are dropped with ErrStopped.
 func (w *Writer) Submit(items ...interface{}) {
-	for _, item := range items {
-		w.items <- item
+	for i, item := range items {
+		select {
+		case w.items <- item:
+		case <-w.stopDone:
+			for _, item := range items[i:] {
+				metrics.BatchDropped.WithLabelValues(w.cfg.Name).Inc()
+				if w.cfg.OnDrop != nil {
+					w.cfg.OnDrop(item, ErrStopped)
+				}
+			}
+			return
+		}
 	}
 }
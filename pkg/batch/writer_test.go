@@ -2,7 +2,10 @@ package batch
 
 import (
 	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
 	"testing"
 	"time"
 )
@@ -14,20 +17,25 @@ func TestSimpleWriter(t *testing.T) {
 		Interval:   time.Second * 2,
 	}
 
+	var mu sync.Mutex
 	var allItems []interface{}
-	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []bool {
-		resp := make([]bool, len(items))
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		resp := make([]Result, len(items))
 		for idx := range resp {
-			resp[idx] = true
+			resp[idx] = Success()
 		}
 
+		mu.Lock()
 		allItems = items
+		mu.Unlock()
 		return resp
 	})
 
 	w.Start()
 	w.Submit(1, 2, 3, 4, 5, 6, 7)
+	mu.Lock()
 	assert.Len(t, allItems, 0)
+	mu.Unlock()
 	w.Stop()
 
 	assert.Len(t, allItems, 7)
@@ -51,10 +59,10 @@ func TestLargerThanBatchSize(t *testing.T) {
 	}
 
 	allItems := make([][]interface{}, 0)
-	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []bool {
-		resp := make([]bool, len(items))
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		resp := make([]Result, len(items))
 		for idx := range resp {
-			resp[idx] = true
+			resp[idx] = Success()
 		}
 
 		allItems = append(allItems, items)
@@ -78,28 +86,37 @@ func TestSimpleInterval(t *testing.T) {
 		Interval:   time.Millisecond * 20,
 	}
 
+	// The batches are written by the writer while the test reads them
+	var mu sync.Mutex
 	allItems := make([][]interface{}, 0)
-	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []bool {
-		resp := make([]bool, len(items))
+	batches := func() [][]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]interface{}(nil), allItems...)
+	}
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		resp := make([]Result, len(items))
 		for idx := range resp {
-			resp[idx] = true
+			resp[idx] = Success()
 		}
 
+		mu.Lock()
 		allItems = append(allItems, items)
+		mu.Unlock()
 		return resp
 	})
 
 	w.Start()
 	w.Submit(1, 2)
 	time.Sleep(time.Millisecond * 5)
-	assert.Len(t, allItems, 0)
+	assert.Len(t, batches(), 0)
 
 	time.Sleep(time.Millisecond * 50)
-	assert.Len(t, allItems, 1)
-	assert.Equal(t, allItems[0], []interface{}{1, 2})
+	assert.Len(t, batches(), 1)
+	assert.Equal(t, batches()[0], []interface{}{1, 2})
 
 	w.Stop()
-	assert.Len(t, allItems, 1)
+	assert.Len(t, batches(), 1)
 }
 
 func TestIntervalComplex(t *testing.T) {
@@ -109,14 +126,23 @@ func TestIntervalComplex(t *testing.T) {
 		Interval:   time.Millisecond * 20,
 	}
 
+	// The batches are written by the writer while the test reads them
+	var mu sync.Mutex
 	allItems := make([][]interface{}, 0)
-	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []bool {
-		resp := make([]bool, len(items))
+	batches := func() [][]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]interface{}(nil), allItems...)
+	}
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		resp := make([]Result, len(items))
 		for idx := range resp {
-			resp[idx] = true
+			resp[idx] = Success()
 		}
 
+		mu.Lock()
 		allItems = append(allItems, items)
+		mu.Unlock()
 		return resp
 	})
 
@@ -124,14 +150,14 @@ func TestIntervalComplex(t *testing.T) {
 	w.Submit(1, 2)
 	time.Sleep(time.Millisecond * 5)
 	w.Submit(3, 4)
-	assert.Len(t, allItems, 0)
+	assert.Len(t, batches(), 0)
 
 	time.Sleep(time.Millisecond * 50)
-	assert.Len(t, allItems, 1)
-	assert.Equal(t, allItems[0], []interface{}{1, 2, 3, 4})
+	assert.Len(t, batches(), 1)
+	assert.Equal(t, batches()[0], []interface{}{1, 2, 3, 4})
 
 	w.Stop()
-	assert.Len(t, allItems, 1)
+	assert.Len(t, batches(), 1)
 }
 
 func TestIntervalComplexAfterFlush(t *testing.T) {
@@ -141,14 +167,23 @@ func TestIntervalComplexAfterFlush(t *testing.T) {
 		Interval:   time.Millisecond * 20,
 	}
 
+	// The batches are written by the writer while the test reads them
+	var mu sync.Mutex
 	allItems := make([][]interface{}, 0)
-	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []bool {
-		resp := make([]bool, len(items))
+	batches := func() [][]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]interface{}(nil), allItems...)
+	}
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		resp := make([]Result, len(items))
 		for idx := range resp {
-			resp[idx] = true
+			resp[idx] = Success()
 		}
 
+		mu.Lock()
 		allItems = append(allItems, items)
+		mu.Unlock()
 		return resp
 	})
 
@@ -156,46 +191,257 @@ func TestIntervalComplexAfterFlush(t *testing.T) {
 	w.Submit(1, 2)
 	time.Sleep(time.Millisecond * 5)
 	w.Submit(3, 4)
-	assert.Len(t, allItems, 0)
+	assert.Len(t, batches(), 0)
 
 	time.Sleep(time.Millisecond * 50)
-	assert.Len(t, allItems, 1)
-	assert.Equal(t, allItems[0], []interface{}{1, 2, 3, 4})
+	assert.Len(t, batches(), 1)
+	assert.Equal(t, batches()[0], []interface{}{1, 2, 3, 4})
 
 	w.Submit(5, 6, 7)
 	w.Stop()
 
-	assert.Len(t, allItems, 2)
-	assert.Equal(t, allItems[1], []interface{}{5, 6, 7})
+	assert.Len(t, batches(), 2)
+	assert.Equal(t, batches()[1], []interface{}{5, 6, 7})
 }
 
 func TestRetry(t *testing.T) {
 	cfg := WriterConfig{
-		BatchSize:  5,
-		MaxRetries: 3,
-		Interval:   time.Millisecond * 10,
+		BatchSize:      5,
+		MaxRetries:     3,
+		Interval:       time.Millisecond * 10,
+		InitialBackoff: time.Millisecond,
 	}
 
+	// The batches are written by the writer while the test reads them
+	var mu sync.Mutex
 	allItems := make([][]interface{}, 0)
-	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []bool {
-		resp := make([]bool, len(items))
+	batches := func() [][]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]interface{}(nil), allItems...)
+	}
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		resp := make([]Result, len(items))
 		for idx := range resp {
-			resp[idx] = items[idx] != 2
+			resp[idx] = Success()
+			if items[idx] == 2 {
+				resp[idx] = Retry(errors.New("failed"))
+			}
 		}
 
+		mu.Lock()
 		allItems = append(allItems, items)
+		mu.Unlock()
 		return resp
 	})
 
 	w.Start()
 	w.Submit(1, 2, 3)
-	assert.Len(t, allItems, 0)
+	assert.Len(t, batches(), 0)
 
 	time.Sleep(time.Millisecond * 200)
-	assert.Len(t, allItems, 4)
+	assert.Len(t, batches(), 4)
 
-	assert.Equal(t, allItems[0], []interface{}{1, 2, 3})
-	assert.Equal(t, allItems[1], []interface{}{2})
-	assert.Equal(t, allItems[2], []interface{}{2})
-	assert.Equal(t, allItems[3], []interface{}{2})
+	assert.Equal(t, batches()[0], []interface{}{1, 2, 3})
+	assert.Equal(t, batches()[1], []interface{}{2})
+	assert.Equal(t, batches()[2], []interface{}{2})
+	assert.Equal(t, batches()[3], []interface{}{2})
+}
+
+func TestFullBufferOfFailingItems(t *testing.T) {
+	cfg := WriterConfig{
+		BatchSize:      2,
+		BufferSize:     2,
+		MaxRetries:     1,
+		Interval:       time.Hour,
+		InitialBackoff: time.Millisecond,
+	}
+
+	var mu sync.Mutex
+	var dropped []interface{}
+	cfg.OnDrop = func(item interface{}, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dropped = append(dropped, item)
+	}
+	var sent []interface{}
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		resp := make([]Result, len(items))
+		for idx := range resp {
+			resp[idx] = Retry(errors.New("unavailable"))
+			if items[idx].(int) > 2 {
+				resp[idx] = Success()
+				sent = append(sent, items[idx])
+			}
+		}
+		return resp
+	})
+
+	// The first items fill the buffer and keep failing, the next ones are accepted once they are dropped
+	w.Start()
+	submitted := make(chan struct{})
+	go func() {
+		w.Submit(1, 2, 3, 4)
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+	case <-time.After(time.Second):
+		t.Fatal("submit is blocked by the failing items")
+	}
+	w.Stop()
+
+	assert.Equal(t, []interface{}{1, 2}, dropped)
+	assert.Equal(t, []interface{}{3, 4}, sent)
+}
+
+func TestResultStatuses(t *testing.T) {
+	cfg := WriterConfig{
+		BatchSize:      3,
+		MaxRetries:     3,
+		Interval:       time.Hour,
+		InitialBackoff: time.Millisecond,
+	}
+
+	dropped := make(map[interface{}]error)
+	cfg.OnDrop = func(item interface{}, err error) {
+		dropped[item] = err
+	}
+	rejected := errors.New("rejected")
+	attempts := make(map[interface{}][]time.Time)
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		resp := make([]Result, len(items))
+		for idx, item := range items {
+			attempts[item] = append(attempts[item], time.Now())
+			switch {
+			case item == "permanent":
+				resp[idx] = Fail(rejected)
+			case item == "throttled" && len(attempts[item]) == 1:
+				resp[idx] = Throttle(errors.New("slow down"), 50*time.Millisecond)
+			default:
+				resp[idx] = Success()
+			}
+		}
+		return resp
+	})
+
+	w.Start()
+	w.Submit("ok", "permanent", "throttled")
+	time.Sleep(100 * time.Millisecond)
+	w.Stop()
+
+	assert.Len(t, attempts["ok"], 1)
+	assert.Len(t, attempts["permanent"], 1)
+	assert.Equal(t, map[interface{}]error{"permanent": rejected}, dropped)
+	require.Len(t, attempts["throttled"], 2)
+	assert.GreaterOrEqual(t, int64(attempts["throttled"][1].Sub(attempts["throttled"][0])), int64(50*time.Millisecond))
+}
+
+func TestBackoff(t *testing.T) {
+	w := NewWriter(WriterConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}, nil)
+	var waits []time.Duration
+	for retry := 1; retry <= 5; retry++ {
+		waits = append(waits, w.backoff(retry))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, waits)
+}
+
+func TestMaxBytes(t *testing.T) {
+	cfg := WriterConfig{
+		BatchSize: 10,
+		MaxBytes:  6,
+		Size:      func(item interface{}) int { return len(item.(string)) },
+		Interval:  time.Hour,
+	}
+
+	var batches [][]interface{}
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		batches = append(batches, items)
+		return make([]Result, len(items))
+	})
+
+	w.Start()
+	w.Submit("aaa", "bbb", "cccc", "dd", "eeeeeeee", "f")
+	w.Stop()
+
+	assert.Equal(t, [][]interface{}{{"aaa", "bbb"}, {"cccc", "dd"}, {"eeeeeeee"}, {"f"}}, batches)
+}
+
+func TestConcurrency(t *testing.T) {
+	cfg := WriterConfig{
+		BatchSize:   1,
+		Interval:    time.Hour,
+		Concurrency: 3,
+	}
+
+	var mu sync.Mutex
+	var running, maxRunning int
+	release := make(chan struct{})
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return make([]Result, len(items))
+	})
+
+	w.Start()
+	w.Submit(1, 2, 3, 4, 5)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return running == 3
+	}, time.Second, time.Millisecond)
+	close(release)
+	w.Stop()
+
+	assert.Equal(t, 3, maxRunning)
+}
+
+func TestTimeout(t *testing.T) {
+	cfg := WriterConfig{
+		BatchSize: 1,
+		Interval:  time.Hour,
+		Timeout:   10 * time.Millisecond,
+	}
+
+	var err error
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		<-ctx.Done()
+		err = ctx.Err()
+		return make([]Result, len(items))
+	})
+
+	w.Start()
+	w.Submit(1)
+	w.Stop()
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestSubmitAfterStop(t *testing.T) {
+	var dropped []error
+	cfg := WriterConfig{
+		BatchSize: 1,
+		Interval:  time.Hour,
+		OnDrop: func(item interface{}, err error) {
+			dropped = append(dropped, err)
+		},
+	}
+	w := NewWriter(cfg, func(ctx context.Context, items []interface{}) []Result {
+		return make([]Result, len(items))
+	})
+
+	w.Start()
+	w.Stop()
+	w.Submit(1, 2)
+
+	assert.Equal(t, []error{ErrStopped, ErrStopped}, dropped)
 }
@@ -0,0 +1,378 @@
+package crd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/exporter"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// SecretGetter returns the value of a key of a Secret
+type SecretGetter func(namespace, name, key string) (string, error)
+
+// ObjectKey identifies a resource whose status is reported
+type ObjectKey struct {
+	Resource  string
+	Namespace string
+	Name      string
+}
+
+func keyOf(resource string, obj *unstructured.Unstructured) ObjectKey {
+	return ObjectKey{Resource: resource, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+}
+
+// receiverSpec is the spec of an EventReceiver, the name of the receiver is taken from the resource
+type receiverSpec struct {
+	SecretRefs           []SecretRef `yaml:"secretRefs"`
+	sinks.ReceiverConfig `yaml:",inline"`
+}
+
+// configSpec is the spec of an EventExporterConfig
+type configSpec struct {
+	Route exporter.Route `yaml:"route"`
+	// SharedReceivers allows the EventRoutes of other namespaces to use a receiver
+	SharedReceivers []SharedReceiver `yaml:"sharedReceivers"`
+}
+
+// SharedReceiver allows the EventRoutes in the namespaces matching any of the regular expressions to use the receiver
+type SharedReceiver struct {
+	Receiver   string   `yaml:"receiver"`
+	Namespaces []string `yaml:"namespaces"`
+}
+
+// allows tells whether the receiver is shared with the namespace
+func (s *SharedReceiver) allows(namespace string) bool {
+	for _, pattern := range s.Namespaces {
+		// The patterns are validated with the config, they must match the whole name
+		if matched, _ := regexp.MatchString("^(?:"+pattern+")$", namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *configSpec) validate(receivers map[string]bool) error {
+	for _, s := range c.SharedReceivers {
+		if s.Receiver == "" {
+			return fmt.Errorf("shared receiver must have a name")
+		}
+		for _, pattern := range s.Namespaces {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("invalid namespace pattern of shared receiver %q: %w", s.Receiver, err)
+			}
+		}
+	}
+	return c.Route.Validate(receivers)
+}
+
+// Result is the outcome of compiling the resources. Errors has an entry for each resource, nil if the resource is
+// valid. The invalid receivers and routes are left out of the config, the config is nil if the EventExporterConfig is
+// invalid.
+type Result struct {
+	Config *exporter.Config
+	Errors map[ObjectKey]error
+}
+
+// ReceiverName is the name of the receiver compiled from an EventReceiver, the rules of the routes refer to it
+func ReceiverName(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// Compile builds the route and the receivers from the resources. The EventExporterConfig is optional, its route is the
+// root route and each EventRoute is added to it as an isolated sub-route of its namespace, ordered by the namespace and
+// the name. A receiver in the rules of an EventRoute without a namespace refers to an EventReceiver in the namespace of
+// the route.
+func Compile(config *unstructured.Unstructured, receivers, routes []*unstructured.Unstructured, secrets SecretGetter) *Result {
+	res := &Result{Errors: make(map[ObjectKey]error)}
+	names := make(map[string]bool, len(receivers))
+	cfg := &exporter.Config{}
+
+	keys := make(map[string]ObjectKey, len(receivers))
+	sortObjects(receivers)
+	for _, obj := range receivers {
+		rc, err := compileReceiver(obj, secrets)
+		res.Errors[keyOf(ReceiverResource.Resource, obj)] = err
+		if err != nil {
+			continue
+		}
+		names[rc.Name] = true
+		keys[rc.Name] = keyOf(ReceiverResource.Resource, obj)
+		cfg.Receivers = append(cfg.Receivers, *rc)
+	}
+	cfg.Receivers = pruneLinks(cfg.Receivers, names, func(name string, err error) {
+		res.Errors[keys[name]] = err
+	})
+
+	var spec configSpec
+	var configErr error
+	if config != nil {
+		configErr = decodeSpec(config.Object, &spec)
+		if configErr == nil {
+			configErr = spec.validate(names)
+		}
+		res.Errors[keyOf(ConfigResource.Resource, config)] = configErr
+		if configErr != nil {
+			// The routes are still compiled to report their status, without sharing any receivers
+			spec = configSpec{}
+		}
+	}
+
+	var valid []exporter.Route
+	sortObjects(routes)
+	for _, obj := range routes {
+		route, err := compileRoute(obj, names, spec.SharedReceivers)
+		res.Errors[keyOf(RouteResource.Resource, obj)] = err
+		if err != nil {
+			continue
+		}
+		valid = append(valid, *route)
+	}
+
+	if configErr != nil {
+		return res
+	}
+
+	cfg.Route = spec.Route
+	cfg.Route.Routes = append(cfg.Route.Routes, valid...)
+	res.Config = cfg
+	return res
+}
+
+func compileReceiver(obj *unstructured.Unstructured, secrets SecretGetter) (*sinks.ReceiverConfig, error) {
+	spec, _, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+
+	// The references are read first, so that the values are expanded in the decoded spec rather than in its YAML
+	var refs receiverSpec
+	if err := decodeSpec(obj.Object, &refs); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(refs.SecretRefs))
+	for _, ref := range refs.SecretRefs {
+		if ref.Variable == "" || ref.SecretKeyRef.Name == "" || ref.SecretKeyRef.Key == "" {
+			return nil, fmt.Errorf("secretRefs must have a variable, a secret name and a key")
+		}
+		v, err := secrets(obj.GetNamespace(), ref.SecretKeyRef.Name, ref.SecretKeyRef.Key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read secret %q: %w", ref.SecretKeyRef.Name, err)
+		}
+		values[ref.Variable] = v
+	}
+
+	expanded := expandStrings(spec, func(name string) string {
+		if v, ok := values[name]; ok {
+			return v
+		}
+		// Only the referenced variables are expanded, the rest stays as it is
+		return "${" + name + "}"
+	})
+
+	var rs receiverSpec
+	if err := decodeSpec(map[string]interface{}{"spec": expanded}, &rs); err != nil {
+		return nil, err
+	}
+
+	rc := rs.ReceiverConfig
+	rc.Name = ReceiverName(obj.GetNamespace(), obj.GetName())
+	if rc.DeadLetter, err = qualifyLink(obj.GetNamespace(), "dead letter", rc.DeadLetter); err != nil {
+		return nil, err
+	}
+	if rc.CircuitBreaker.Fallback, err = qualifyLink(obj.GetNamespace(), "fallback", rc.CircuitBreaker.Fallback); err != nil {
+		return nil, err
+	}
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
+	if err := checkLocal(&rc); err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+// checkLocal rejects the receivers that write to the filesystem or the memory of the exporter. An EventReceiver is
+// created by the tenants of its namespace, which must not be able to overwrite the files of the exporter or to fill its
+// disk.
+func checkLocal(rc *sinks.ReceiverConfig) error {
+	switch {
+	case rc.File != nil:
+		return fmt.Errorf("file sink cannot be used by an EventReceiver")
+	case rc.Stdout != nil:
+		return fmt.Errorf("stdout sink cannot be used by an EventReceiver")
+	case rc.Pipe != nil:
+		return fmt.Errorf("pipe sink cannot be used by an EventReceiver")
+	case rc.InMemory != nil:
+		return fmt.Errorf("inMemory sink cannot be used by an EventReceiver")
+	case rc.Queue.Persistence.Enabled():
+		return fmt.Errorf("queue.persistence cannot be used by an EventReceiver")
+	}
+	return nil
+}
+
+// qualifyLink adds the namespace to the dead letter or the fallback of an EventReceiver, which must be in the same
+// namespace
+func qualifyLink(namespace, kind, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	if !strings.Contains(name, "/") {
+		name = ReceiverName(namespace, name)
+	}
+	if !strings.HasPrefix(name, namespace+"/") {
+		return "", fmt.Errorf("%s %q must be in namespace %q", kind, name, namespace)
+	}
+	return name, nil
+}
+
+// pruneLinks leaves out the receivers whose dead letter or fallback is not valid, until all the remaining ones are valid
+func pruneLinks(receivers []sinks.ReceiverConfig, names map[string]bool, fail func(string, error)) []sinks.ReceiverConfig {
+	for {
+		errs := exporter.CheckReceiverLinks(receivers)
+
+		var kept []sinks.ReceiverConfig
+		for _, rc := range receivers {
+			if err := errs[rc.Name]; err != nil {
+				fail(rc.Name, err)
+				delete(names, rc.Name)
+				continue
+			}
+			kept = append(kept, rc)
+		}
+
+		if len(kept) == len(receivers) {
+			return kept
+		}
+		receivers = kept
+	}
+}
+
+// compileRoute builds the sub-tree of an EventRoute. The route only sees the events in its namespace and it can only use
+// the receivers in its namespace or the ones shared with it.
+func compileRoute(obj *unstructured.Unstructured, receivers map[string]bool, shared []SharedReceiver) (*exporter.Route, error) {
+	var route exporter.Route
+	if err := decodeSpec(obj.Object, &route); err != nil {
+		return nil, err
+	}
+
+	namespace := obj.GetNamespace()
+	qualifyReceivers(&route, namespace)
+
+	allowed := make(map[string]bool)
+	for name := range receivers {
+		if strings.HasPrefix(name, namespace+"/") {
+			allowed[name] = true
+		}
+	}
+	for i := range shared {
+		if receivers[shared[i].Receiver] && shared[i].allows(namespace) {
+			allowed[shared[i].Receiver] = true
+		}
+	}
+
+	if err := checkReceivers(&route, receivers, allowed, namespace); err != nil {
+		return nil, err
+	}
+	if err := route.Validate(allowed); err != nil {
+		return nil, err
+	}
+
+	tenant := TenantRoute(namespace, route)
+	return &tenant, nil
+}
+
+// TenantRoute wraps the route of a namespace, so that it only gets the events in the namespace. The drop rules of the
+// route only apply to its own sub-tree.
+func TenantRoute(namespace string, route exporter.Route) exporter.Route {
+	return exporter.Route{
+		Match:  []exporter.Rule{{Namespace: "^" + regexp.QuoteMeta(namespace) + "$"}},
+		Routes: []exporter.Route{route},
+	}
+}
+
+// checkReceivers tells apart the existing receivers that the namespace is not allowed to use from the unknown ones
+func checkReceivers(route *exporter.Route, receivers, allowed map[string]bool, namespace string) error {
+	for _, rule := range route.Match {
+		if rule.Receiver != "" && receivers[rule.Receiver] && !allowed[rule.Receiver] {
+			return fmt.Errorf("receiver %q is not allowed in namespace %q", rule.Receiver, namespace)
+		}
+	}
+	for i := range route.Routes {
+		if err := checkReceivers(&route.Routes[i], receivers, allowed, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// qualifyReceivers prefixes the receivers of the rules that are given without a namespace with the namespace
+func qualifyReceivers(route *exporter.Route, namespace string) {
+	for i := range route.Match {
+		if r := route.Match[i].Receiver; r != "" && !strings.Contains(r, "/") {
+			route.Match[i].Receiver = ReceiverName(namespace, r)
+		}
+	}
+	for i := range route.Routes {
+		qualifyReceivers(&route.Routes[i], namespace)
+	}
+}
+
+// decodeSpec decodes the spec of an object with the YAML keys of the config file. Unknown fields are rejected, so that a
+// typo is reported in the status instead of being ignored.
+func decodeSpec(obj map[string]interface{}, out interface{}) error {
+	spec, _, err := unstructured.NestedFieldNoCopy(obj, "spec")
+	if err != nil {
+		return err
+	}
+	if spec == nil {
+		return nil
+	}
+
+	b, err := yaml.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(b, out); err != nil {
+		return fmt.Errorf("invalid spec: %w", err)
+	}
+	return nil
+}
+
+// expandStrings returns a copy of the value with the variables in all strings expanded
+func expandStrings(v interface{}, mapping func(string) string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = expandStrings(e, mapping)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, e := range t {
+			s[i] = expandStrings(e, mapping)
+		}
+		return s
+	case string:
+		return os.Expand(t, mapping)
+	default:
+		return runtime.DeepCopyJSONValue(v)
+	}
+}
+
+func sortObjects(objs []*unstructured.Unstructured) {
+	sort.Slice(objs, func(i, j int) bool {
+		if objs[i].GetNamespace() != objs[j].GetNamespace() {
+			return objs[i].GetNamespace() < objs[j].GetNamespace()
+		}
+		return objs[i].GetName() < objs[j].GetName()
+	})
+}
@@ -0,0 +1,312 @@
+package crd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func object(kind, namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(Group + "/" + Version)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func noSecrets(namespace, name, key string) (string, error) {
+	return "", errors.New("not found")
+}
+
+func TestCompile_ReceiversAndRoutes(t *testing.T) {
+	config := object("EventExporterConfig", "", "default", map[string]interface{}{
+		"route": map[string]interface{}{
+			"drop": []interface{}{map[string]interface{}{"namespace": "kube-system"}},
+			"match": []interface{}{
+				map[string]interface{}{"receiver": "monitoring/dump"},
+			},
+		},
+	})
+	receivers := []*unstructured.Unstructured{
+		object("EventReceiver", "team-b", "slack", map[string]interface{}{
+			"webhook": map[string]interface{}{"endpoint": "https://example.com/b"},
+		}),
+		object("EventReceiver", "monitoring", "dump", map[string]interface{}{
+			"webhook": map[string]interface{}{"endpoint": "https://example.com"},
+		}),
+	}
+	routes := []*unstructured.Unstructured{
+		object("EventRoute", "team-b", "warnings", map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{"type": "Warning", "receiver": "slack"}},
+		}),
+	}
+
+	res := Compile(config, receivers, routes, noSecrets)
+	require.NotNil(t, res.Config)
+	for k, err := range res.Errors {
+		assert.NoError(t, err, k)
+	}
+
+	require.Len(t, res.Config.Receivers, 2)
+	assert.Equal(t, "monitoring/dump", res.Config.Receivers[0].Name)
+	assert.Equal(t, "team-b/slack", res.Config.Receivers[1].Name)
+	assert.Equal(t, "https://example.com/b", res.Config.Receivers[1].Webhook.Endpoint)
+
+	assert.Len(t, res.Config.Route.Drop, 1)
+	require.Len(t, res.Config.Route.Routes, 1)
+	assert.Equal(t, "team-b/slack", res.Config.Route.Routes[0].Routes[0].Match[0].Receiver)
+	assert.NoError(t, res.Config.Validate())
+}
+
+func TestCompile_SecretRefs(t *testing.T) {
+	receiver := object("EventReceiver", "team-a", "opsgenie", map[string]interface{}{
+		"secretRefs": []interface{}{
+			map[string]interface{}{
+				"variable":     "API_KEY",
+				"secretKeyRef": map[string]interface{}{"name": "opsgenie", "key": "apiKey"},
+			},
+		},
+		"opsgenie": map[string]interface{}{
+			"apiKey":  "${API_KEY}",
+			"message": "${HOME} {{ .Message }}",
+		},
+	})
+
+	var namespace string
+	res := Compile(nil, []*unstructured.Unstructured{receiver}, nil, func(ns, name, key string) (string, error) {
+		namespace = ns
+		assert.Equal(t, "opsgenie", name)
+		assert.Equal(t, "apiKey", key)
+		return "s3cr3t: \"quoted\"", nil
+	})
+
+	require.NotNil(t, res.Config)
+	assert.Equal(t, "team-a", namespace)
+	require.Len(t, res.Config.Receivers, 1)
+	assert.Equal(t, "s3cr3t: \"quoted\"", res.Config.Receivers[0].Opsgenie.ApiKey)
+	// Only the referenced variables are expanded, not the environment of the exporter
+	assert.Equal(t, "${HOME} {{ .Message }}", res.Config.Receivers[0].Opsgenie.Message)
+
+	// The original object is not modified
+	spec, _, _ := unstructured.NestedMap(receiver.Object, "spec", "opsgenie")
+	assert.Equal(t, "${API_KEY}", spec["apiKey"])
+}
+
+func TestCompile_InvalidResources(t *testing.T) {
+	receivers := []*unstructured.Unstructured{
+		object("EventReceiver", "team-a", "missing-secret", map[string]interface{}{
+			"secretRefs": []interface{}{
+				map[string]interface{}{
+					"variable":     "TOKEN",
+					"secretKeyRef": map[string]interface{}{"name": "token", "key": "token"},
+				},
+			},
+			"webhook": map[string]interface{}{"endpoint": "https://example.com"},
+		}),
+		object("EventReceiver", "team-a", "two-sinks", map[string]interface{}{
+			"webhook": map[string]interface{}{"endpoint": "https://example.com"},
+			"kafka":   map[string]interface{}{"topic": "events"},
+		}),
+		object("EventReceiver", "team-a", "typo", map[string]interface{}{
+			"stdot": map[string]interface{}{},
+		}),
+		object("EventReceiver", "team-a", "valid", map[string]interface{}{
+			"webhook": map[string]interface{}{"endpoint": "https://example.com"},
+		}),
+	}
+	routes := []*unstructured.Unstructured{
+		object("EventRoute", "team-a", "unknown-receiver", map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{"receiver": "two-sinks"}},
+		}),
+		object("EventRoute", "team-a", "bad-regexp", map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{"reason": "(", "receiver": "valid"}},
+		}),
+		object("EventRoute", "team-a", "valid", map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{"receiver": "valid"}},
+		}),
+	}
+
+	res := Compile(nil, receivers, routes, noSecrets)
+	require.NotNil(t, res.Config)
+
+	for _, name := range []string{"missing-secret", "two-sinks", "typo"} {
+		assert.Error(t, res.Errors[ObjectKey{Resource: "eventreceivers", Namespace: "team-a", Name: name}], name)
+	}
+	for _, name := range []string{"unknown-receiver", "bad-regexp"} {
+		assert.Error(t, res.Errors[ObjectKey{Resource: "eventroutes", Namespace: "team-a", Name: name}], name)
+	}
+	assert.NoError(t, res.Errors[ObjectKey{Resource: "eventreceivers", Namespace: "team-a", Name: "valid"}])
+	assert.NoError(t, res.Errors[ObjectKey{Resource: "eventroutes", Namespace: "team-a", Name: "valid"}])
+
+	// The invalid resources are left out, the valid ones are applied
+	require.Len(t, res.Config.Receivers, 1)
+	assert.Equal(t, "team-a/valid", res.Config.Receivers[0].Name)
+	assert.Len(t, res.Config.Route.Routes, 1)
+}
+
+func TestCompile_InvalidConfig(t *testing.T) {
+	config := object("EventExporterConfig", "", "default", map[string]interface{}{
+		"route": map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{"receiver": "unknown/receiver"}},
+		},
+	})
+
+	res := Compile(config, nil, nil, noSecrets)
+	assert.Nil(t, res.Config)
+	assert.Error(t, res.Errors[ObjectKey{Resource: "eventexporterconfigs", Name: "default"}])
+}
+
+type recordingRegistry struct {
+	events map[string][]string
+}
+
+func (r *recordingRegistry) SendEvent(name string, event *kube.EnhancedEvent) {
+	if r.events == nil {
+		r.events = make(map[string][]string)
+	}
+	r.events[name] = append(r.events[name], event.Namespace)
+}
+
+func (r *recordingRegistry) Register(string, sinks.Sink) {}
+
+func (r *recordingRegistry) Unregister(string) {}
+
+func (r *recordingRegistry) Close() {}
+
+func TestCompile_TenantIsolation(t *testing.T) {
+	config := object("EventExporterConfig", "", "default", map[string]interface{}{
+		"sharedReceivers": []interface{}{
+			map[string]interface{}{"receiver": "monitoring/shared", "namespaces": []interface{}{"team-.*"}},
+		},
+	})
+	receivers := []*unstructured.Unstructured{
+		object("EventReceiver", "monitoring", "shared", map[string]interface{}{"webhook": map[string]interface{}{"endpoint": "https://example.com"}}),
+		object("EventReceiver", "monitoring", "private", map[string]interface{}{"webhook": map[string]interface{}{"endpoint": "https://example.com"}}),
+		object("EventReceiver", "team-a", "slack", map[string]interface{}{"webhook": map[string]interface{}{"endpoint": "https://example.com"}}),
+		object("EventReceiver", "team-b", "slack", map[string]interface{}{"webhook": map[string]interface{}{"endpoint": "https://example.com"}}),
+	}
+	routes := []*unstructured.Unstructured{
+		// Drops everything, but only for its own namespace
+		object("EventRoute", "team-a", "all", map[string]interface{}{
+			"drop":  []interface{}{map[string]interface{}{"reason": "Noisy"}},
+			"match": []interface{}{map[string]interface{}{"namespace": ".*", "receiver": "slack"}},
+		}),
+		object("EventRoute", "team-b", "shared", map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{"receiver": "monitoring/shared"}},
+		}),
+		object("EventRoute", "team-b", "other-tenant", map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{"receiver": "team-a/slack"}},
+		}),
+		object("EventRoute", "team-b", "private", map[string]interface{}{
+			"routes": []interface{}{
+				map[string]interface{}{
+					"match": []interface{}{map[string]interface{}{"receiver": "monitoring/private"}},
+				},
+			},
+		}),
+		object("EventRoute", "staging", "shared", map[string]interface{}{
+			"match": []interface{}{map[string]interface{}{"receiver": "monitoring/shared"}},
+		}),
+	}
+
+	res := Compile(config, receivers, routes, noSecrets)
+	require.NotNil(t, res.Config)
+	assert.NoError(t, res.Errors[ObjectKey{Resource: "eventroutes", Namespace: "team-a", Name: "all"}])
+	assert.NoError(t, res.Errors[ObjectKey{Resource: "eventroutes", Namespace: "team-b", Name: "shared"}])
+	for _, key := range []ObjectKey{
+		{Resource: "eventroutes", Namespace: "team-b", Name: "other-tenant"},
+		{Resource: "eventroutes", Namespace: "team-b", Name: "private"},
+		{Resource: "eventroutes", Namespace: "staging", Name: "shared"},
+	} {
+		assert.Error(t, res.Errors[key], key.Name)
+	}
+
+	registry := &recordingRegistry{}
+	for _, ns := range []string{"team-a", "team-b", "team-a-prod", "kube-system"} {
+		ev := &kube.EnhancedEvent{}
+		ev.Namespace = ns
+		res.Config.Route.ProcessEvent(ev, registry)
+	}
+	noisy := &kube.EnhancedEvent{}
+	noisy.Namespace = "team-b"
+	noisy.Reason = "Noisy"
+	res.Config.Route.ProcessEvent(noisy, registry)
+
+	assert.Equal(t, []string{"team-a"}, registry.events["team-a/slack"])
+	assert.Equal(t, []string{"team-b", "team-b"}, registry.events["monitoring/shared"])
+	assert.Len(t, registry.events, 2)
+}
+
+func TestCompile_DeadLetters(t *testing.T) {
+	receivers := []*unstructured.Unstructured{
+		object("EventReceiver", "team-a", "webhook", map[string]interface{}{
+			"webhook":    map[string]interface{}{"endpoint": "https://example.com"},
+			"deadLetter": "file",
+		}),
+		object("EventReceiver", "team-a", "file", map[string]interface{}{
+			"webhook": map[string]interface{}{"endpoint": "https://example.com/dead-letters"},
+		}),
+		// The dead letter of the dead letter is missing, so both are left out
+		object("EventReceiver", "team-a", "slack", map[string]interface{}{
+			"stdout":     map[string]interface{}{},
+			"deadLetter": "broken",
+		}),
+		object("EventReceiver", "team-a", "broken", map[string]interface{}{
+			"stdout":     map[string]interface{}{},
+			"deadLetter": "missing",
+		}),
+		object("EventReceiver", "team-a", "other-namespace", map[string]interface{}{
+			"stdout":     map[string]interface{}{},
+			"deadLetter": "team-b/file",
+		}),
+		object("EventReceiver", "team-a", "other-fallback", map[string]interface{}{
+			"stdout":         map[string]interface{}{},
+			"circuitBreaker": map[string]interface{}{"failureThreshold": int64(3), "fallback": "team-b/file"},
+		}),
+	}
+
+	res := Compile(nil, receivers, nil, noSecrets)
+	require.NotNil(t, res.Config)
+	require.Len(t, res.Config.Receivers, 2)
+	assert.Equal(t, "team-a/file", res.Config.Receivers[0].Name)
+	assert.Equal(t, "team-a/file", res.Config.Receivers[1].DeadLetter)
+	for _, name := range []string{"slack", "broken", "other-namespace", "other-fallback"} {
+		assert.Error(t, res.Errors[ObjectKey{Resource: "eventreceivers", Namespace: "team-a", Name: name}], name)
+	}
+	assert.NoError(t, res.Config.Validate())
+}
+
+func TestCompile_LocalReceivers(t *testing.T) {
+	receivers := []*unstructured.Unstructured{
+		object("EventReceiver", "team-a", "file", map[string]interface{}{
+			"file": map[string]interface{}{"path": "/etc/passwd"},
+		}),
+		object("EventReceiver", "team-a", "stdout", map[string]interface{}{
+			"stdout": map[string]interface{}{},
+		}),
+		object("EventReceiver", "team-a", "pipe", map[string]interface{}{
+			"pipe": map[string]interface{}{"path": "/dev/stdout"},
+		}),
+		object("EventReceiver", "team-a", "persistent", map[string]interface{}{
+			"webhook": map[string]interface{}{"endpoint": "https://example.com"},
+			"queue":   map[string]interface{}{"persistence": map[string]interface{}{"directory": "/var/lib/exporter"}},
+		}),
+		object("EventReceiver", "team-a", "webhook", map[string]interface{}{
+			"webhook": map[string]interface{}{"endpoint": "https://example.com"},
+		}),
+	}
+
+	res := Compile(nil, receivers, nil, noSecrets)
+	require.NotNil(t, res.Config)
+	for _, name := range []string{"file", "stdout", "pipe", "persistent"} {
+		assert.Error(t, res.Errors[ObjectKey{Resource: "eventreceivers", Namespace: "team-a", Name: name}], name)
+	}
+	require.Len(t, res.Config.Receivers, 1)
+	assert.Equal(t, "team-a/webhook", res.Config.Receivers[0].Name)
+}
@@ -0,0 +1,278 @@
+package crd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/exporter"
+	"github.com/rs/zerolog/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// resyncPeriod compiles the resources periodically, so that the rotated secrets are picked up
+	resyncPeriod = 5 * time.Minute
+	// syncKey is the only key of the queue, any change compiles all the resources
+	syncKey = "sync"
+	// defaultConfigName is the name of the EventExporterConfig when it is not configured
+	defaultConfigName = "default"
+
+	reasonReloadFailed = "ReloadFailed"
+)
+
+// Reloader applies a compiled config, it is implemented by exporter.Engine
+type Reloader interface {
+	Reload(config *exporter.Config) error
+}
+
+// Controller watches the custom resources, compiles them and applies the result to the engine. The outcome is written
+// to the status of each resource.
+type Controller struct {
+	configName string
+	engine     Reloader
+	client     dynamic.Interface
+	kube       kubernetes.Interface
+	factory    dynamicinformer.DynamicSharedInformerFactory
+	queue      workqueue.RateLimitingInterface
+	applied    *exporter.Config
+}
+
+func NewController(cfg exporter.CustomResourcesConfig, config *rest.Config, engine Reloader) (*Controller, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ConfigName == "" {
+		cfg.ConfigName = defaultConfigName
+	}
+
+	c := &Controller{
+		configName: cfg.ConfigName,
+		engine:     engine,
+		client:     client,
+		kube:       kube,
+		factory:    dynamicinformer.NewDynamicSharedInformerFactory(client, resyncPeriod),
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "eventexporter"),
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.queue.Add(syncKey) },
+		UpdateFunc: func(interface{}, interface{}) { c.queue.Add(syncKey) },
+		DeleteFunc: func(interface{}) { c.queue.Add(syncKey) },
+	}
+	for _, gvr := range []schema.GroupVersionResource{ConfigResource, ReceiverResource, RouteResource} {
+		c.factory.ForResource(gvr).Informer().AddEventHandler(handler)
+	}
+	return c, nil
+}
+
+// Start applies the resources once and keeps applying the changes until the context is cancelled. It fails if the
+// CRDs are not installed or the resources cannot be listed.
+func (c *Controller) Start(ctx context.Context) error {
+	for _, gvr := range []schema.GroupVersionResource{ConfigResource, ReceiverResource, RouteResource} {
+		_, err := c.client.Resource(gvr).List(ctx, metav1.ListOptions{Limit: 1})
+		if err != nil {
+			return fmt.Errorf("cannot list %s: %w", gvr.Resource, err)
+		}
+	}
+
+	c.factory.Start(ctx.Done())
+	for gvr, synced := range c.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("cannot sync %s", gvr.Resource)
+		}
+	}
+
+	if err := c.sync(ctx); err != nil {
+		log.Error().Err(err).Msg("Cannot apply the custom resources, retrying")
+		c.queue.AddRateLimited(syncKey)
+	}
+
+	go func() {
+		<-ctx.Done()
+		c.queue.ShutDown()
+	}()
+	go func() {
+		for c.processNext(ctx) {
+		}
+	}()
+	return nil
+}
+
+func (c *Controller) processNext(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.sync(ctx); err != nil {
+		log.Error().Err(err).Msg("Cannot apply the custom resources, retrying")
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) sync(ctx context.Context) error {
+	config, err := c.getConfig()
+	if err != nil {
+		return err
+	}
+	receivers, err := c.list(ReceiverResource)
+	if err != nil {
+		return err
+	}
+	routes, err := c.list(RouteResource)
+	if err != nil {
+		return err
+	}
+
+	res := Compile(config, receivers, routes, func(namespace, name, key string) (string, error) {
+		secret, err := c.kube.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		v, ok := secret.Data[key]
+		if !ok {
+			return "", fmt.Errorf("secret has no key %q", key)
+		}
+		return string(v), nil
+	})
+
+	var reloadErr error
+	if res.Config != nil && !reflect.DeepEqual(res.Config, c.applied) {
+		reloadErr = c.engine.Reload(res.Config)
+		if reloadErr == nil {
+			c.applied = res.Config
+			log.Info().Int("receivers", len(res.Config.Receivers)).Msg("Custom resources applied")
+		}
+	}
+
+	var statusErrs []error
+	update := func(gvr schema.GroupVersionResource, obj *unstructured.Unstructured, reason string, err error) {
+		if err := c.updateStatus(ctx, gvr, obj, reason, err); err != nil {
+			statusErrs = append(statusErrs, err)
+		}
+	}
+	if config != nil {
+		reason, err := reasonInvalid, res.Errors[keyOf(ConfigResource.Resource, config)]
+		if err == nil && reloadErr != nil {
+			reason, err = reasonReloadFailed, reloadErr
+		}
+		update(ConfigResource, config, reason, err)
+	}
+	for _, obj := range receivers {
+		update(ReceiverResource, obj, reasonInvalid, res.Errors[keyOf(ReceiverResource.Resource, obj)])
+	}
+	for _, obj := range routes {
+		update(RouteResource, obj, reasonInvalid, res.Errors[keyOf(RouteResource.Resource, obj)])
+	}
+
+	if reloadErr != nil {
+		return reloadErr
+	}
+	if len(statusErrs) > 0 {
+		return fmt.Errorf("cannot update the status of %d resources: %w", len(statusErrs), statusErrs[0])
+	}
+	return nil
+}
+
+func (c *Controller) getConfig() (*unstructured.Unstructured, error) {
+	obj, err := c.factory.ForResource(ConfigResource).Lister().Get(c.configName)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*unstructured.Unstructured), nil
+}
+
+func (c *Controller) list(gvr schema.GroupVersionResource) ([]*unstructured.Unstructured, error) {
+	objs, err := c.factory.ForResource(gvr).Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, errors.New("unexpected object in the cache")
+		}
+		res = append(res, u)
+	}
+	return res, nil
+}
+
+type status struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// updateStatus sets the Ready condition of the object, the object is not updated if the condition is not changed
+func (c *Controller) updateStatus(ctx context.Context, gvr schema.GroupVersionResource, obj *unstructured.Unstructured,
+	reason string, err error) error {
+	cond := metav1.Condition{
+		Type:               ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonValid,
+		ObservedGeneration: obj.GetGeneration(),
+	}
+	if err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reason
+		cond.Message = err.Error()
+	}
+
+	var st status
+	if raw, ok, _ := unstructured.NestedMap(obj.Object, "status"); ok {
+		// A malformed status is overwritten
+		_ = runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &st)
+	}
+
+	current := meta.FindStatusCondition(st.Conditions, ConditionReady)
+	if current != nil && current.Status == cond.Status && current.Reason == cond.Reason &&
+		current.Message == cond.Message && current.ObservedGeneration == cond.ObservedGeneration &&
+		st.ObservedGeneration == obj.GetGeneration() {
+		return nil
+	}
+
+	meta.SetStatusCondition(&st.Conditions, cond)
+	st.ObservedGeneration = obj.GetGeneration()
+	raw, convErr := runtime.DefaultUnstructuredConverter.ToUnstructured(&st)
+	if convErr != nil {
+		return convErr
+	}
+
+	updated := obj.DeepCopy()
+	if err := unstructured.SetNestedMap(updated.Object, raw, "status"); err != nil {
+		return err
+	}
+	_, updateErr := c.client.Resource(gvr).Namespace(obj.GetNamespace()).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(updateErr) {
+		return nil
+	}
+	return updateErr
+}
@@ -0,0 +1,38 @@
+package crd
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group   = "eventexporter.opsgenie.com"
+	Version = "v1alpha1"
+
+	// ConditionReady is the condition reported in the status of the resources, it is false with the validation error
+	// as the message when the resource cannot be used
+	ConditionReady = "Ready"
+
+	reasonValid   = "Valid"
+	reasonInvalid = "Invalid"
+)
+
+var (
+	// ConfigResource is the cluster scoped EventExporterConfig, it holds the root route
+	ConfigResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "eventexporterconfigs"}
+	// ReceiverResource is the namespaced EventReceiver, its spec is a receiver config without the name
+	ReceiverResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "eventreceivers"}
+	// RouteResource is the namespaced EventRoute, its spec is a route which is added under the root route
+	RouteResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "eventroutes"}
+)
+
+// SecretRef makes the value of a Secret key available to the receiver spec as ${Variable}
+type SecretRef struct {
+	Variable     string            `yaml:"variable"`
+	SecretKeyRef SecretKeySelector `yaml:"secretKeyRef"`
+}
+
+// SecretKeySelector selects a key of a Secret in the namespace of the receiver
+type SecretKeySelector struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
@@ -0,0 +1,63 @@
+package exporter
+
+import (
+	"context"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+)
+
+// sinkWrapper is implemented by the sinks that add a behaviour to another sink, like the retries
+type sinkWrapper interface {
+	Unwrap() sinks.Sink
+}
+
+// asBatchSink returns the sink as a BatchSink when the innermost sink can send batches and the wrappers pass them on
+func asBatchSink(s sinks.Sink) (sinks.BatchSink, bool) {
+	outer, ok := s.(sinks.BatchSink)
+	if !ok {
+		return nil, false
+	}
+	for {
+		w, ok := s.(sinkWrapper)
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	_, ok = s.(sinks.BatchSink)
+	return outer, ok
+}
+
+// sinkLinker is implemented by the wrappers that pass events to other receivers, like the dead letter
+type sinkLinker interface {
+	linkedReceiver() string
+}
+
+// sinkLinks returns the receivers that the wrappers of the sink pass events to
+func sinkLinks(s sinks.Sink) []string {
+	var links []string
+	for s != nil {
+		if l, ok := s.(sinkLinker); ok && l.linkedReceiver() != "" {
+			links = append(links, l.linkedReceiver())
+		}
+		w, ok := s.(sinkWrapper)
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	return links
+}
+
+// sendBatch sends the events in a batch when the sink can send batches, or one by one otherwise
+func sendBatch(ctx context.Context, s sinks.Sink, evs []*kube.EnhancedEvent) []error {
+	if bs, ok := asBatchSink(s); ok && len(evs) > 1 {
+		return bs.SendBatch(ctx, evs)
+	}
+	errs := make([]error, len(evs))
+	for i, ev := range evs {
+		errs[i] = s.Send(ctx, ev)
+	}
+	return errs
+}
@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchRecorder records the messages of each batch, it fails the events whose message is in failures until the
+// failures are used up
+type batchRecorder struct {
+	mu       sync.Mutex
+	batches  [][]string
+	failures map[string]error
+}
+
+func (b *batchRecorder) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return b.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+func (b *batchRecorder) SendBatch(_ context.Context, evs []*kube.EnhancedEvent) []error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var batch []string
+	errs := make([]error, len(evs))
+	for i, ev := range evs {
+		batch = append(batch, ev.Message)
+		if err, ok := b.failures[ev.Message]; ok {
+			errs[i] = err
+			delete(b.failures, ev.Message)
+		}
+	}
+	b.batches = append(b.batches, batch)
+	return errs
+}
+
+func (b *batchRecorder) Close() {}
+
+func TestChannelBasedReceiverRegistryBatchSize(t *testing.T) {
+	sink := &batchRecorder{}
+	reg := &ChannelBasedReceiverRegistry{}
+	reg.RegisterWithQueue("batched", sink, sinks.QueueConfig{
+		Capacity: 10,
+		Batch:    sinks.BatchConfig{Size: 3, FlushInterval: time.Hour},
+	})
+
+	for i := 0; i < 6; i++ {
+		ev := messageEvent(fmt.Sprint(i)).ev
+		reg.SendEvent("batched", &ev)
+	}
+	reg.Close()
+
+	// Each batch is full, the flush interval is never reached
+	assert.Equal(t, [][]string{{"0", "1", "2"}, {"3", "4", "5"}}, sink.batches)
+}
+
+func TestChannelBasedReceiverRegistryBatchMaxBytes(t *testing.T) {
+	sink := &batchRecorder{}
+	ev := messageEvent("0").ev
+	size := len(ev.ToJSON())
+
+	reg := &ChannelBasedReceiverRegistry{}
+	reg.RegisterWithQueue("batched", sink, sinks.QueueConfig{
+		Capacity: 10,
+		Batch:    sinks.BatchConfig{Size: 10, MaxBytes: 2 * size, FlushInterval: time.Hour},
+	})
+	for i := 0; i < 5; i++ {
+		ev := messageEvent(fmt.Sprint(i)).ev
+		reg.SendEvent("batched", &ev)
+	}
+	reg.Close()
+
+	assert.Equal(t, [][]string{{"0", "1"}, {"2", "3"}, {"4"}}, sink.batches)
+}
+
+func TestChannelBasedReceiverRegistryBatchFlushInterval(t *testing.T) {
+	sink := &batchRecorder{}
+	reg := &ChannelBasedReceiverRegistry{}
+	reg.RegisterWithQueue("batched", sink, sinks.QueueConfig{
+		Capacity: 10,
+		Batch:    sinks.BatchConfig{Size: 100, FlushInterval: 10 * time.Millisecond},
+	})
+	defer reg.Close()
+
+	ev := messageEvent("0").ev
+	reg.SendEvent("batched", &ev)
+
+	// The batch is sent when the flush interval passes, before it is full
+	require.Eventually(t, func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		return len(sink.batches) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, [][]string{{"0"}}, sink.batches)
+}
+
+func TestRetrySinkBatchRetriesFailedEvents(t *testing.T) {
+	unavailable := errors.New("503 service unavailable")
+	rejected := sinks.Permanent(errors.New("400 bad request"))
+	sink := &batchRecorder{failures: map[string]error{"1": unavailable, "2": rejected}}
+	r, waits := newTestRetrySink(sink, sinks.RetryConfig{MaxAttempts: 3, InitialInterval: time.Second})
+
+	var evs []*kube.EnhancedEvent
+	for i := 0; i < 3; i++ {
+		ev := messageEvent(fmt.Sprint(i)).ev
+		evs = append(evs, &ev)
+	}
+	errs := r.SendBatch(context.Background(), evs)
+
+	// Only the event that failed with a retryable error is sent again
+	assert.Equal(t, [][]string{{"0", "1", "2"}, {"1"}}, sink.batches)
+	assert.Equal(t, []time.Duration{time.Second}, *waits)
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.True(t, sinks.IsPermanent(errs[2]))
+}
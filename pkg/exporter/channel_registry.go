@@ -2,79 +2,380 @@ package exporter
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/health"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/tracing"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/wal"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// ChannelBasedReceiverRegistry creates two channels for each receiver. One is for receiving events and other one is
-// for breaking out of the infinite loop. Each message is passed to receivers
-// This might not be the best way to implement such feature. A ring buffer can be better
-// and we might need a mechanism to drop the vents
-// On closing, the registry sends a signal on all exit channels, and then waits for all to complete.
+// ChannelBasedReceiverRegistry keeps a bounded queue for each receiver, the events in it are sent by the workers of
+// the receiver. The queue is split into one ring buffer per worker and the events of an involved object always go to
+// the same worker, so they are sent in order. When a queue is full, the overflow policy of the receiver either blocks
+// the sender or drops an event.
+// On unregistering or closing, the queued events are sent before the sink is closed. On closing, a receiver is closed
+// after the receivers that pass events to it, i.e. the dead letters and the fallbacks are closed last. The receivers can
+// be registered again after closing.
+// With persistence, the queued events are also written to a write-ahead log of the receiver. They are acknowledged in
+// the log once the sink is done with them, and the ones that are not acknowledged are queued again on registering.
 type ChannelBasedReceiverRegistry struct {
-	ch     map[string]chan kube.EnhancedEvent
-	exitCh map[string]chan interface{}
-	wg     *sync.WaitGroup
+	// Tracker keeps the recent send results of the receivers for the health checks, it is optional
+	Tracker *health.ReceiverTracker
+
+	receivers map[string]*channelReceiver
+	mu        sync.RWMutex
+}
+
+type channelReceiver struct {
+	name string
+	// links are the receivers that this receiver passes events to
+	links []string
+	lanes []*ringQueue
+	wal   *wal.Log
+	// pending counts the events being added to the queues, so that they are added before the queues are closed
+	pending sync.WaitGroup
+	workers sync.WaitGroup
+	sink    sinks.Sink
 }
 
 func (r *ChannelBasedReceiverRegistry) SendEvent(name string, event *kube.EnhancedEvent) {
-	ch := r.ch[name]
-	if ch == nil {
-		log.Error().Str("name", name).Msg("There is no channel")
+	r.mu.RLock()
+	rcv := r.receivers[name]
+	if rcv != nil {
+		rcv.pending.Add(1)
+	}
+	r.mu.RUnlock()
+
+	if rcv == nil {
+		log.Error().Str("name", name).Msg("There is no queue")
+		return
+	}
+	defer rcv.pending.Done()
+
+	item := queuedEvent{ev: *event}
+	if rcv.wal != nil {
+		seq, err := rcv.wal.Append(event.ToJSON())
+		if err != nil {
+			log.Error().Err(err).Str("sink", name).Msg("Cannot write the event to the queue log")
+		} else {
+			item.seq, item.persisted = seq, true
+			metrics.ReceiverWALBytes.WithLabelValues(name).Set(float64(rcv.wal.Size()))
+		}
 	}
+	// The lock is not held while the queue blocks, the workers may need it to pass events to the other receivers
+	rcv.push(item)
+}
 
-	go func() {
-		ch <- *event
-	}()
+func (rcv *channelReceiver) push(item queuedEvent) {
+	if rcv.lanes[laneOf(&item.ev, len(rcv.lanes))].push(item) {
+		metrics.ReceiverQueueDepth.WithLabelValues(rcv.name).Inc()
+	}
 }
 
+func (rcv *channelReceiver) drop(item queuedEvent, queued bool) {
+	if queued {
+		metrics.ReceiverQueueDepth.WithLabelValues(rcv.name).Dec()
+	}
+	metrics.ReceiverQueueDropped.WithLabelValues(rcv.name).Inc()
+	log.Warn().Str("sink", rcv.name).Str("event", item.ev.Message).Msg("Dropped an event from the queue")
+	rcv.ack(item)
+}
+
+// ack tells the log that the event does not need to be sent again
+func (rcv *channelReceiver) ack(item queuedEvent) {
+	if !item.persisted {
+		return
+	}
+	rcv.wal.Ack(item.seq)
+	metrics.ReceiverWALBytes.WithLabelValues(rcv.name).Set(float64(rcv.wal.Size()))
+}
+
+// Register registers the receiver with the default queue config
 func (r *ChannelBasedReceiverRegistry) Register(name string, receiver sinks.Sink) {
-	if r.ch == nil {
-		r.ch = make(map[string]chan kube.EnhancedEvent)
-		r.exitCh = make(map[string]chan interface{})
-	}
-
-	ch := make(chan kube.EnhancedEvent)
-	exitCh := make(chan interface{})
-
-	r.ch[name] = ch
-	r.exitCh[name] = exitCh
-
-	if r.wg == nil {
-		r.wg = &sync.WaitGroup{}
-	}
-	r.wg.Add(1)
-
-	go func() {
-	Loop:
-		for {
-			select {
-			case ev := <-ch:
-				log.Debug().Str("sink", name).Str("event", ev.Message).Msg("sending event to sink")
-				err := receiver.Send(context.Background(), &ev)
-				if err != nil {
-					log.Debug().Err(err).Str("sink", name).Str("event", ev.Message).Msg("Cannot send event")
-				}
-			case <-exitCh:
-				log.Info().Str("sink", name).Msg("Closing the sink")
-				break Loop
-			}
+	r.RegisterWithQueue(name, receiver, sinks.QueueConfig{})
+}
+
+// RegisterWithQueue registers the receiver with its own queue config
+func (r *ChannelBasedReceiverRegistry) RegisterWithQueue(name string, receiver sinks.Sink, cfg sinks.QueueConfig) {
+	cfg.SetDefaults()
+
+	rcv := &channelReceiver{name: name, links: sinkLinks(receiver), sink: receiver}
+	batchSink, batching := asBatchSink(receiver)
+	// Each worker gets at least one slot
+	capacity := (cfg.Capacity + cfg.Workers - 1) / cfg.Workers
+	for i := 0; i < cfg.Workers; i++ {
+		lane := newRingQueue(capacity, cfg.Overflow, rcv.drop)
+		rcv.lanes = append(rcv.lanes, lane)
+		rcv.workers.Add(1)
+		if batching {
+			go r.batchWorker(rcv, lane, batchSink, cfg.Batch)
+		} else {
+			go r.worker(rcv, lane)
+		}
+	}
+
+	if cfg.Persistence.Enabled() {
+		// The events of the log are queued before the receiver gets new ones, without holding the lock since the queue
+		// may block until the workers send them
+		rcv.wal = openLog(name, cfg.Persistence)
+		if rcv.wal != nil {
+			rcv.replay()
 		}
-		receiver.Close()
-		log.Info().Str("sink", name).Msg("Closed")
-		r.wg.Done()
-	}()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.receivers == nil {
+		r.receivers = make(map[string]*channelReceiver)
+	}
+	r.receivers[name] = rcv
+}
+
+func openLog(name string, cfg sinks.PersistenceConfig) *wal.Log {
+	l, err := wal.Open(filepath.Join(cfg.Directory, url.PathEscape(name)), wal.Options{
+		MaxBytes: cfg.MaxBytes,
+		MaxAge:   cfg.MaxAge,
+		OnDrop: func(n int) {
+			metrics.ReceiverWALDropped.WithLabelValues(name).Add(float64(n))
+			log.Warn().Str("sink", name).Int("events", n).Msg("Dropped events from the queue log because of its limits")
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("sink", name).Msg("Cannot open the queue log, the queued events are kept in memory")
+		return nil
+	}
+	return l
+}
+
+func (rcv *channelReceiver) replay() {
+	var replayed int
+	err := rcv.wal.Replay(func(seq uint64, payload []byte) {
+		item := queuedEvent{seq: seq, persisted: true}
+		if err := json.Unmarshal(payload, &item.ev); err != nil {
+			log.Error().Err(err).Str("sink", rcv.name).Msg("Cannot decode an event of the queue log")
+			rcv.wal.Ack(seq)
+			return
+		}
+		replayed++
+		rcv.push(item)
+	})
+	if err != nil {
+		log.Error().Err(err).Str("sink", rcv.name).Msg("Cannot replay the queue log")
+	}
+	if replayed > 0 {
+		log.Info().Str("sink", rcv.name).Int("events", replayed).Msg("Replayed the events of the queue log")
+		metrics.ReceiverWALReplayed.WithLabelValues(rcv.name).Add(float64(replayed))
+	}
+}
+
+func (r *ChannelBasedReceiverRegistry) worker(rcv *channelReceiver, lane *ringQueue) {
+	defer rcv.workers.Done()
+	for {
+		item, ok := lane.pop()
+		if !ok {
+			return
+		}
+		metrics.ReceiverQueueDepth.WithLabelValues(rcv.name).Dec()
+		// The failed events are acknowledged as well, they are handled by the retries and the dead letter
+		r.send(rcv.name, rcv.sink, &item.ev)
+		rcv.ack(item)
+	}
+}
+
+func (r *ChannelBasedReceiverRegistry) batchWorker(rcv *channelReceiver, lane *ringQueue, sink sinks.BatchSink, cfg sinks.BatchConfig) {
+	defer rcv.workers.Done()
+	for {
+		items, ok := lane.popBatch(cfg, eventSize)
+		if !ok {
+			return
+		}
+		metrics.ReceiverQueueDepth.WithLabelValues(rcv.name).Sub(float64(len(items)))
+		evs := make([]*kube.EnhancedEvent, len(items))
+		for i := range items {
+			evs[i] = &items[i].ev
+		}
+		r.sendBatch(rcv.name, sink, evs)
+		for _, item := range items {
+			rcv.ack(item)
+		}
+	}
 }
 
-// Close signals closing to all sinks and waits for them to complete.
+// eventSize is the size of the event in a batch, which is roughly its JSON
+func eventSize(item *queuedEvent) int {
+	return len(item.ev.ToJSON())
+}
+
+func (r *ChannelBasedReceiverRegistry) send(name string, receiver sinks.Sink, ev *kube.EnhancedEvent) {
+	log.Debug().Str("sink", name).Str("event", ev.Message).Msg("sending event to sink")
+	ctx, span := tracing.Tracer().Start(ev.Context(), "Sink.Send", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(append(ev.TraceAttributes(), attribute.String("receiver", name))...))
+	start := time.Now()
+	err := receiver.Send(ctx, ev)
+	metrics.ReceiverLatency.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	r.record(name, ev, err)
+}
+
+// record counts the result of sending the event, it reports whether the event failed
+func (r *ChannelBasedReceiverRegistry) record(name string, ev *kube.EnhancedEvent, err error) bool {
+	// The events passed to the fallback are not sent, the receiver is not healthy while its circuit is open
+	if r.Tracker != nil {
+		r.Tracker.Record(name, err)
+	}
+	switch {
+	case err == errDiverted:
+		metrics.CircuitDiverted.WithLabelValues(name).Inc()
+		log.Debug().Str("sink", name).Str("event", ev.Message).Msg("Passed event to the fallback")
+		return false
+	case err != nil:
+		metrics.ReceiverFailed.WithLabelValues(name).Inc()
+		log.Error().Err(err).Str("sink", name).Str("event", ev.Message).Msg("Cannot send event")
+		return true
+	default:
+		metrics.ReceiverSent.WithLabelValues(name).Inc()
+		return false
+	}
+}
+
+// sendBatch sends the events in a batch and reports the result of each event. The span of the batch is linked to the
+// traces of its events.
+func (r *ChannelBasedReceiverRegistry) sendBatch(name string, sink sinks.BatchSink, evs []*kube.EnhancedEvent) {
+	log.Debug().Str("sink", name).Int("events", len(evs)).Msg("sending batch to sink")
+	links := make([]trace.Link, len(evs))
+	for i, ev := range evs {
+		links[i] = trace.LinkFromContext(ev.Context())
+	}
+	ctx, span := tracing.Tracer().Start(context.Background(), "Sink.SendBatch", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.String("receiver", name), attribute.Int("batch.size", len(evs))))
+	start := time.Now()
+	errs := sink.SendBatch(ctx, evs)
+	metrics.ReceiverLatency.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	metrics.ReceiverBatchSize.WithLabelValues(name).Observe(float64(len(evs)))
+
+	var failed int
+	for i, err := range errs {
+		if r.record(name, evs[i], err) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d of %d events failed", failed, len(evs)))
+	}
+	span.End()
+}
+
+// Unregister stops sending new events to the receiver, sends the queued ones and closes the sink
+func (r *ChannelBasedReceiverRegistry) Unregister(name string) {
+	r.mu.Lock()
+	rcv := r.receivers[name]
+	delete(r.receivers, name)
+	r.mu.Unlock()
+
+	if rcv != nil {
+		rcv.close(name)
+		r.forget(name)
+	}
+}
+
+func (r *ChannelBasedReceiverRegistry) forget(name string) {
+	metrics.DeleteReceiver(name)
+	if r.Tracker != nil {
+		r.Tracker.Forget(name)
+	}
+}
+
+func (rcv *channelReceiver) close(name string) {
+	rcv.pending.Wait()
+	log.Info().Str("sink", name).Msg("Closing the sink")
+	for _, lane := range rcv.lanes {
+		lane.close()
+	}
+	rcv.workers.Wait()
+	if rcv.wal != nil {
+		if err := rcv.wal.Close(); err != nil {
+			log.Error().Err(err).Str("sink", name).Msg("Cannot close the queue log")
+		}
+	}
+	rcv.sink.Close()
+	log.Info().Str("sink", name).Msg("Closed")
+}
+
+// Close sends the queued events of all sinks and closes them. The receivers are closed in rounds, a receiver is closed
+// once the receivers that pass events to it are closed, so that the events they pass on while draining are not lost.
 // The wait could block indefinitely depending on the sink implementations.
 func (r *ChannelBasedReceiverRegistry) Close() {
-	// Send exit command and wait for exit of all sinks
-	for _, ec := range r.exitCh {
-		ec <- 1
+	r.mu.RLock()
+	remaining := make(map[string]*channelReceiver, len(r.receivers))
+	for name, rcv := range r.receivers {
+		remaining[name] = rcv
+	}
+	r.mu.RUnlock()
+
+	for len(remaining) > 0 {
+		round := closeRound(remaining)
+
+		// The receivers of the round stop getting events, the others still get the ones passed on while draining
+		r.mu.Lock()
+		for name := range round {
+			delete(r.receivers, name)
+			delete(remaining, name)
+		}
+		r.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for name, rcv := range round {
+			wg.Add(1)
+			go func(name string, rcv *channelReceiver) {
+				defer wg.Done()
+				rcv.close(name)
+				r.forget(name)
+			}(name, rcv)
+		}
+		wg.Wait()
+	}
+}
+
+// closeRound returns the receivers that no other remaining receiver passes events to. The links cannot form a loop, but
+// if they do all the receivers are returned.
+func closeRound(remaining map[string]*channelReceiver) map[string]*channelReceiver {
+	linked := make(map[string]bool)
+	for name, rcv := range remaining {
+		for _, link := range rcv.links {
+			if link != name {
+				linked[link] = true
+			}
+		}
+	}
+
+	round := make(map[string]*channelReceiver)
+	for name, rcv := range remaining {
+		if !linked[name] {
+			round[name] = rcv
+		}
+	}
+	if len(round) == 0 {
+		for name, rcv := range remaining {
+			round[name] = rcv
+		}
 	}
-	r.wg.Wait()
+	return round
 }
@@ -0,0 +1,263 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/rs/zerolog/log"
+)
+
+type circuitState int
+
+// The values of the states are also the values of the circuit state metric
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// errDiverted is returned for the events that are passed to the fallback because the circuit is open. They are neither
+// sent nor failed by the receiver, so they are not counted as such and do not go to its dead letter.
+var errDiverted = errors.New("circuit is open, the event is passed to the fallback")
+
+// circuitOpenError is returned for the events that are not sent because the circuit is open
+type circuitOpenError struct {
+	name    string
+	lastErr error
+}
+
+func (c *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit of receiver %q is open, last error: %v", c.name, c.lastErr)
+}
+
+// Attempts is zero since the sink is not called at all
+func (c *circuitOpenError) Attempts() int {
+	return 0
+}
+
+// circuitBreakerSink stops calling a sink that keeps failing. The permanent errors do not count as failures, since
+// they show that the downstream is up.
+type circuitBreakerSink struct {
+	sinks.Sink
+	name     string
+	cfg      sinks.CircuitBreakerConfig
+	registry ReceiverRegistry
+	circuits *circuitSet
+	now      func() time.Time
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	probes   int
+	probing  bool
+	openedAt time.Time
+	lastErr  error
+	reported sync.Once
+}
+
+func newCircuitBreakerSink(sink sinks.Sink, name string, cfg sinks.CircuitBreakerConfig, registry ReceiverRegistry, circuits *circuitSet) *circuitBreakerSink {
+	cfg.SetDefaults()
+	b := &circuitBreakerSink{Sink: sink, name: name, cfg: cfg, registry: registry, circuits: circuits, now: time.Now}
+	circuits.add(b)
+	return b
+}
+
+func (b *circuitBreakerSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return b.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch sends the batch as a single request, it is a failure when none of the events is sent
+func (b *circuitBreakerSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	b.reported.Do(func() {
+		metrics.CircuitState.WithLabelValues(b.name).Set(float64(circuitClosed))
+	})
+
+	probe, err := b.allow()
+	if err != nil {
+		metrics.CircuitRejected.WithLabelValues(b.name).Add(float64(len(evs)))
+		if b.cfg.Fallback != "" {
+			errs := make([]error, len(evs))
+			for i, ev := range evs {
+				b.registry.SendEvent(b.cfg.Fallback, ev)
+				errs[i] = errDiverted
+			}
+			return errs
+		}
+		errs := make([]error, len(evs))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	errs := sendBatch(ctx, b.Sink, evs)
+	b.record(batchResult(errs), probe)
+	return errs
+}
+
+// batchResult is nil when an event of the batch is sent, otherwise it is the first error that is not permanent
+func batchResult(errs []error) error {
+	var first error
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+		if first == nil || (sinks.IsPermanent(first) && !sinks.IsPermanent(err)) {
+			first = err
+		}
+	}
+	return first
+}
+
+func (b *circuitBreakerSink) Unwrap() sinks.Sink {
+	return b.Sink
+}
+
+func (b *circuitBreakerSink) linkedReceiver() string {
+	return b.cfg.Fallback
+}
+
+func (b *circuitBreakerSink) Close() {
+	b.circuits.remove(b)
+	b.Sink.Close()
+}
+
+// allow decides whether the event is sent, it reports whether the event is a probe of the half-open circuit
+func (b *circuitBreakerSink) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.cfg.OpenDuration {
+			return false, &circuitOpenError{name: b.name, lastErr: b.lastErr}
+		}
+		b.transition(circuitHalfOpen)
+		fallthrough
+	case circuitHalfOpen:
+		if b.probing {
+			return false, &circuitOpenError{name: b.name, lastErr: b.lastErr}
+		}
+		b.probing = true
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (b *circuitBreakerSink) record(err error, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failed := err != nil && !sinks.IsPermanent(err)
+	if failed {
+		b.lastErr = err
+	}
+
+	switch b.state {
+	case circuitClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.transition(circuitOpen)
+		}
+	case circuitHalfOpen:
+		// The sends that started before the circuit opened do not decide the probes
+		if !probe {
+			return
+		}
+		b.probing = false
+		if failed {
+			b.transition(circuitOpen)
+			return
+		}
+		b.probes++
+		if b.probes >= b.cfg.HalfOpenProbes {
+			b.transition(circuitClosed)
+		}
+	}
+}
+
+func (b *circuitBreakerSink) transition(to circuitState) {
+	b.state = to
+	b.failures = 0
+	b.probes = 0
+	b.probing = false
+	if to == circuitOpen {
+		b.openedAt = b.now()
+		log.Warn().Err(b.lastErr).Str("sink", b.name).Dur("openDuration", b.cfg.OpenDuration).Msg("Circuit opened")
+	} else {
+		log.Info().Str("sink", b.name).Str("state", to.String()).Msg("Circuit state changed")
+	}
+	metrics.CircuitState.WithLabelValues(b.name).Set(float64(to))
+}
+
+// check returns an error unless the circuit is closed
+func (b *circuitBreakerSink) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		return fmt.Errorf("circuit is open since %s, last error: %v", b.openedAt.Format(time.RFC3339), b.lastErr)
+	case circuitHalfOpen:
+		return fmt.Errorf("circuit is half-open, last error: %v", b.lastErr)
+	default:
+		return nil
+	}
+}
+
+// circuitSet keeps the circuit breakers of the sinks that are not closed yet
+type circuitSet struct {
+	mu       sync.Mutex
+	breakers map[*circuitBreakerSink]bool
+}
+
+func (c *circuitSet) add(b *circuitBreakerSink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.breakers == nil {
+		c.breakers = make(map[*circuitBreakerSink]bool)
+	}
+	c.breakers[b] = true
+}
+
+func (c *circuitSet) remove(b *circuitBreakerSink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.breakers, b)
+}
+
+// Check returns the state of each circuit breaker keyed by "circuit/<receiver>"
+func (c *circuitSet) Check() map[string]error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := make(map[string]error, len(c.breakers))
+	for b := range c.breakers {
+		res["circuit/"+b.name] = b.check()
+	}
+	return res
+}
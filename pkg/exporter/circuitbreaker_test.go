@@ -0,0 +1,150 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCircuitBreaker(sink sinks.Sink, cfg sinks.CircuitBreakerConfig, registry ReceiverRegistry) (*circuitBreakerSink, *time.Time) {
+	now := time.Date(2021, 11, 3, 10, 15, 30, 0, time.UTC)
+	b := newCircuitBreakerSink(sink, "webhook", cfg, registry, &circuitSet{})
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	down := &failingSink{err: errors.New("connection refused")}
+	b, now := newTestCircuitBreaker(down, sinks.CircuitBreakerConfig{
+		FailureThreshold: 3,
+		OpenDuration:     time.Minute,
+		HalfOpenProbes:   2,
+	}, &testReceiverRegistry{})
+	ev := &kube.EnhancedEvent{}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, down.err, b.Send(context.Background(), ev))
+	}
+	assert.Equal(t, circuitOpen, b.state)
+	assert.Error(t, b.circuits.Check()["circuit/webhook"])
+
+	// Fails fast while open
+	err := b.Send(context.Background(), ev)
+	var open *circuitOpenError
+	require.True(t, errors.As(err, &open))
+	var ae attemptsError
+	require.True(t, errors.As(err, &ae))
+	assert.Zero(t, ae.Attempts())
+
+	// A failed probe opens the circuit again
+	*now = now.Add(time.Minute)
+	assert.Equal(t, down.err, b.Send(context.Background(), ev))
+	assert.Equal(t, circuitOpen, b.state)
+
+	// Two successful probes close it
+	*now = now.Add(time.Minute)
+	down.err = nil
+	assert.NoError(t, b.Send(context.Background(), ev))
+	assert.Equal(t, circuitHalfOpen, b.state)
+	assert.NoError(t, b.Send(context.Background(), ev))
+	assert.Equal(t, circuitClosed, b.state)
+	assert.NoError(t, b.circuits.Check()["circuit/webhook"])
+
+	b.Close()
+	assert.Empty(t, b.circuits.Check())
+}
+
+func TestCircuitBreakerIgnoresPermanentErrors(t *testing.T) {
+	sink := &failingSink{err: sinks.Permanent(errors.New("400 bad request"))}
+	b, _ := newTestCircuitBreaker(sink, sinks.CircuitBreakerConfig{FailureThreshold: 1}, &testReceiverRegistry{})
+
+	assert.Error(t, b.Send(context.Background(), &kube.EnhancedEvent{}))
+	assert.Equal(t, circuitClosed, b.state)
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	b, now := newTestCircuitBreaker(&failingSink{err: errors.New("timeout")},
+		sinks.CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Second}, &testReceiverRegistry{})
+	b.record(errors.New("timeout"), false)
+	require.Equal(t, circuitOpen, b.state)
+
+	*now = now.Add(time.Second)
+	probe, err := b.allow()
+	assert.True(t, probe)
+	assert.NoError(t, err)
+
+	// Only one probe is in flight at a time
+	_, err = b.allow()
+	assert.Error(t, err)
+
+	// A send that started before the circuit opened does not close it
+	b.record(nil, false)
+	assert.Equal(t, circuitHalfOpen, b.state)
+	b.record(nil, true)
+	assert.Equal(t, circuitClosed, b.state)
+}
+
+func TestCircuitBreakerFallback(t *testing.T) {
+	reg := &testReceiverRegistry{}
+	b, _ := newTestCircuitBreaker(&failingSink{err: errors.New("503 service unavailable")},
+		sinks.CircuitBreakerConfig{FailureThreshold: 1, Fallback: "file"}, reg)
+	ev := &kube.EnhancedEvent{}
+
+	assert.Error(t, b.Send(context.Background(), ev))
+	assert.Zero(t, reg.count("file"))
+
+	// The diverted events are not sent, but they do not go to the dead letter either
+	d := &deadLetterSink{Sink: b, name: "test", deadLetter: "dlq", registry: reg}
+	assert.Equal(t, errDiverted, d.Send(context.Background(), ev))
+	assert.True(t, reg.isEventRcvd("file", ev))
+	assert.Zero(t, reg.count("dlq"))
+}
+
+func TestRegistryRecordsDiverted(t *testing.T) {
+	r := &ChannelBasedReceiverRegistry{}
+	ev := &kube.EnhancedEvent{}
+	sent := testutil.ToFloat64(metrics.ReceiverSent.WithLabelValues("diverted-test"))
+	failed := testutil.ToFloat64(metrics.ReceiverFailed.WithLabelValues("diverted-test"))
+	diverted := testutil.ToFloat64(metrics.CircuitDiverted.WithLabelValues("diverted-test"))
+
+	assert.False(t, r.record("diverted-test", ev, errDiverted))
+	assert.Equal(t, sent, testutil.ToFloat64(metrics.ReceiverSent.WithLabelValues("diverted-test")))
+	assert.Equal(t, failed, testutil.ToFloat64(metrics.ReceiverFailed.WithLabelValues("diverted-test")))
+	assert.Equal(t, diverted+1, testutil.ToFloat64(metrics.CircuitDiverted.WithLabelValues("diverted-test")))
+
+	assert.False(t, r.record("diverted-test", ev, nil))
+	assert.Equal(t, sent+1, testutil.ToFloat64(metrics.ReceiverSent.WithLabelValues("diverted-test")))
+}
+
+func TestConfigValidateFallbacks(t *testing.T) {
+	cfg := &Config{
+		Receivers: []sinks.ReceiverConfig{
+			{Name: "webhook", Webhook: &sinks.WebhookConfig{}, CircuitBreaker: sinks.CircuitBreakerConfig{
+				FailureThreshold: 5,
+				Fallback:         "file",
+			}},
+			{Name: "file", File: &sinks.FileConfig{}},
+		},
+	}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Receivers[0].CircuitBreaker.Fallback = "unknown"
+	assert.Error(t, cfg.Validate())
+
+	// The dead letter of the fallback brings the failed events back
+	cfg.Receivers[0].CircuitBreaker.Fallback = "file"
+	cfg.Receivers[1].DeadLetter = "webhook"
+	assert.Error(t, cfg.Validate())
+
+	cfg.Receivers[1].DeadLetter = ""
+	cfg.Receivers[0].CircuitBreaker.FailureThreshold = 0
+	assert.Error(t, cfg.Validate())
+}
@@ -1,8 +1,13 @@
 package exporter
 
 import (
+	"errors"
+	"fmt"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/health"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/tracing"
 )
 
 // Config allows configuration
@@ -15,13 +20,69 @@ type Config struct {
 	ThrottlePeriod int64					 `yaml:"throttlePeriod"`
 	Namespace      string                    `yaml:"namespace"`
 	LeaderElection kube.LeaderElectionConfig `yaml:"leaderElection"`
+	Sharding       kube.ShardingConfig       `yaml:"sharding"`
 	Route          Route                     `yaml:"route"`
 	Receivers      []sinks.ReceiverConfig    `yaml:"receivers"`
+	// WatchDeletions lists the resources whose deletions are routed as events with the Deleted reason
+	WatchDeletions []kube.WatchedResource `yaml:"watchDeletions"`
+	// Health sets when a receiver is reported as unhealthy by the readiness endpoint
+	Health health.Config `yaml:"health"`
+	// Tracing exports the traces of the events with OTLP
+	Tracing tracing.Config `yaml:"tracing"`
+	// CustomResources reads the route and the receivers from the custom resources instead of this file
+	CustomResources CustomResourcesConfig `yaml:"customResources"`
+}
+
+// CustomResourcesConfig enables the EventExporterConfig, EventReceiver and EventRoute resources
+type CustomResourcesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ConfigName is the name of the EventExporterConfig to use, defaults to "default"
+	ConfigName string `yaml:"configName"`
 }
 
 func (c *Config) Validate() error {
+	if c.LeaderElection.Enabled && c.Sharding.Enabled {
+		return errors.New("leader election and sharding cannot be enabled together")
+	}
+
+	if c.CustomResources.Enabled && (len(c.Receivers) > 0 || !c.Route.isEmpty()) {
+		return errors.New("route and receivers cannot be given in the config when custom resources are enabled")
+	}
+
 	// No duplicate receivers
-	// Receivers individually
+	receivers := make(map[string]bool, len(c.Receivers))
+	// The metrics of the Prometheus receivers are served together, so their names are unique
+	metrics := make(map[string]string)
+	for i := range c.Receivers {
+		r := &c.Receivers[i]
+		if receivers[r.Name] {
+			return fmt.Errorf("duplicate receiver %q", r.Name)
+		}
+		receivers[r.Name] = true
+
+		// Receivers individually
+		if err := r.Validate(); err != nil {
+			return err
+		}
+
+		if r.Prometheus == nil {
+			continue
+		}
+		for _, m := range r.Prometheus.Metrics {
+			if other, ok := metrics[m.Name]; ok {
+				return fmt.Errorf("metric %q is defined by receivers %q and %q", m.Name, other, r.Name)
+			}
+			metrics[m.Name] = r.Name
+		}
+	}
+
+	links := CheckReceiverLinks(c.Receivers)
+	for _, r := range c.Receivers {
+		if err := links[r.Name]; err != nil {
+			return err
+		}
+	}
+
 	// Routers recursive
-	return nil
+	return c.Route.Validate(receivers)
 }
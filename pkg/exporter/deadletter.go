@@ -0,0 +1,70 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/rs/zerolog/log"
+)
+
+// attemptsError is implemented by the errors that know how many times the send was attempted
+type attemptsError interface {
+	Attempts() int
+}
+
+// deadLetterSink passes the events that the sink fails to send to the dead-letter receiver. The error is still returned,
+// so that the failure is counted for the receiver itself.
+type deadLetterSink struct {
+	sinks.Sink
+	name       string
+	deadLetter string
+	registry   ReceiverRegistry
+}
+
+func (d *deadLetterSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return d.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+func (d *deadLetterSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	start := time.Now()
+	errs := sendBatch(ctx, d.Sink, evs)
+	for i, err := range errs {
+		if err != nil && err != errDiverted {
+			d.send(evs[i], err, start)
+		}
+	}
+	return errs
+}
+
+func (d *deadLetterSink) send(ev *kube.EnhancedEvent, err error, start time.Time) {
+	attempts := 1
+	var ae attemptsError
+	if errors.As(err, &ae) {
+		attempts = ae.Attempts()
+	}
+
+	wrapped := *ev
+	wrapped.DeadLetter = &kube.DeadLetter{
+		Receiver:       d.name,
+		Error:          err.Error(),
+		Attempts:       attempts,
+		FirstAttemptAt: start,
+		FailedAt:       time.Now(),
+	}
+
+	log.Warn().Err(err).Str("sink", d.name).Str("deadLetter", d.deadLetter).Msg("Sending the event to the dead letter")
+	metrics.DeadLetters.WithLabelValues(d.name).Inc()
+	d.registry.SendEvent(d.deadLetter, &wrapped)
+}
+
+func (d *deadLetterSink) Unwrap() sinks.Sink {
+	return d.Sink
+}
+
+func (d *deadLetterSink) linkedReceiver() string {
+	return d.deadLetter
+}
@@ -0,0 +1,126 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingSink struct {
+	err error
+}
+
+func (f *failingSink) Send(context.Context, *kube.EnhancedEvent) error {
+	return f.err
+}
+
+func (f *failingSink) Close() {}
+
+type retriedError struct {
+	attempts int
+}
+
+func (r *retriedError) Error() string {
+	return fmt.Sprintf("failed after %d attempts", r.attempts)
+}
+
+func (r *retriedError) Attempts() int {
+	return r.attempts
+}
+
+func TestDeadLetterSink(t *testing.T) {
+	dead := &sinks.InMemoryConfig{}
+	reg := &SyncRegistry{}
+	dead.Ref = &sinks.InMemory{Config: dead}
+	reg.Register("dead", dead.Ref)
+
+	sink := &deadLetterSink{
+		Sink:       &failingSink{err: fmt.Errorf("send: %w", &retriedError{attempts: 3})},
+		name:       "webhook",
+		deadLetter: "dead",
+		registry:   reg,
+	}
+
+	ev := &kube.EnhancedEvent{}
+	ev.Message = "Back-off restarting failed container"
+	err := sink.Send(context.Background(), ev)
+	assert.Error(t, err)
+	assert.Nil(t, ev.DeadLetter)
+
+	require.Len(t, dead.Ref.Events, 1)
+	got := dead.Ref.Events[0]
+	assert.Equal(t, ev.Message, got.Message)
+	require.NotNil(t, got.DeadLetter)
+	assert.Equal(t, "webhook", got.DeadLetter.Receiver)
+	assert.Equal(t, "send: failed after 3 attempts", got.DeadLetter.Error)
+	assert.Equal(t, 3, got.DeadLetter.Attempts)
+	assert.False(t, got.DeadLetter.FailedAt.Before(got.DeadLetter.FirstAttemptAt))
+
+	sink.Sink = &failingSink{}
+	assert.NoError(t, sink.Send(context.Background(), ev))
+	assert.Len(t, dead.Ref.Events, 1)
+
+	sink.Sink = &failingSink{err: errors.New("connection refused")}
+	assert.Error(t, sink.Send(context.Background(), ev))
+	require.Len(t, dead.Ref.Events, 2)
+	assert.Equal(t, 1, dead.Ref.Events[1].DeadLetter.Attempts)
+}
+
+func TestConfigValidateDeadLetters(t *testing.T) {
+	cfg := &Config{
+		Receivers: []sinks.ReceiverConfig{
+			{Name: "webhook", Webhook: &sinks.WebhookConfig{}, DeadLetter: "file"},
+			{Name: "file", File: &sinks.FileConfig{}, DeadLetter: "stdout"},
+			{Name: "stdout", Stdout: &sinks.StdoutConfig{}},
+		},
+	}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Receivers[2].DeadLetter = "webhook"
+	assert.Error(t, cfg.Validate())
+
+	cfg.Receivers[2].DeadLetter = "stdout"
+	assert.Error(t, cfg.Validate())
+
+	cfg.Receivers[2].DeadLetter = "unknown"
+	assert.Error(t, cfg.Validate())
+}
+
+// slowSink fails each event after a while, so that its queue is still draining when the registry is closed
+type slowSink struct {
+	failingSink
+}
+
+func (s *slowSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	time.Sleep(10 * time.Millisecond)
+	return s.failingSink.Send(ctx, ev)
+}
+
+func TestDeadLetterOnClose(t *testing.T) {
+	reg := &ChannelBasedReceiverRegistry{}
+	dead := &sinks.InMemory{Config: &sinks.InMemoryConfig{}}
+	reg.Register("dead", dead)
+	reg.Register("webhook", &deadLetterSink{
+		Sink:       &slowSink{failingSink{err: errors.New("connection refused")}},
+		name:       "webhook",
+		deadLetter: "dead",
+		registry:   reg,
+	})
+
+	for i := 0; i < 5; i++ {
+		ev := &kube.EnhancedEvent{}
+		ev.Message = fmt.Sprint(i)
+		reg.SendEvent("webhook", ev)
+	}
+
+	// The dead letter is closed after the receiver has drained its queue
+	reg.Close()
+	assert.Len(t, dead.Events, 5)
+}
@@ -1,46 +1,193 @@
 package exporter
 
 import (
+	"fmt"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
 	"github.com/rs/zerolog/log"
 	"reflect"
+	"sync"
 )
 
 // Engine is responsible for initializing the receivers from sinks
 type Engine struct {
 	Route    Route
 	Registry ReceiverRegistry
+
+	receivers []sinks.ReceiverConfig
+	circuits  circuitSet
+	stopped   bool
+	sync.RWMutex
 }
 
 func NewEngine(config *Config, registry ReceiverRegistry) *Engine {
-	for _, v := range config.Receivers {
-		sink, err := v.GetSink()
+	e := &Engine{
+		Route:     config.Route,
+		Registry:  registry,
+		receivers: config.Receivers,
+	}
+	e.registerSinks()
+	return e
+}
+
+func (e *Engine) registerSinks() {
+	for _, v := range e.receivers {
+		sink, err := e.newSink(v)
 		if err != nil {
 			log.Fatal().Err(err).Str("name", v.Name).Msg("Cannot initialize sink")
 		}
 
-		log.Info().
-			Str("name", v.Name).
-			Str("type", reflect.TypeOf(sink).String()).
-			Msg("Registering sink")
+		e.register(v, sink)
+	}
+}
 
-		registry.Register(v.Name, sink)
+// register registers the sink with the queue config of the receiver if the registry has queues
+func (e *Engine) register(cfg sinks.ReceiverConfig, sink sinks.Sink) {
+	log.Info().Str("name", cfg.Name).Msg("Registering sink")
+	if q, ok := e.Registry.(QueuedReceiverRegistry); ok {
+		q.RegisterWithQueue(cfg.Name, sink, cfg.Queue)
+		return
 	}
+	e.Registry.Register(cfg.Name, sink)
+}
+
+// newSink creates the sink of the receiver along with the behaviours that are common to all sinks
+func (e *Engine) newSink(cfg sinks.ReceiverConfig) (sinks.Sink, error) {
+	sink, err := cfg.GetSink()
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Str("name", cfg.Name).Str("type", reflect.TypeOf(sink).String()).Msg("Created sink")
 
-	return &Engine{
-		Route:    config.Route,
-		Registry: registry,
+	if cfg.Retry.Enabled() {
+		sink = newRetrySink(sink, cfg.Name, cfg.Retry)
 	}
+	if cfg.CircuitBreaker.Enabled() {
+		sink = newCircuitBreakerSink(sink, cfg.Name, cfg.CircuitBreaker, e.Registry, &e.circuits)
+	}
+	if cfg.DeadLetter != "" {
+		sink = &deadLetterSink{Sink: sink, name: cfg.Name, deadLetter: cfg.DeadLetter, registry: e.Registry}
+	}
+	return sink, nil
+}
+
+// CheckCircuits returns an error for each receiver whose circuit breaker is open or half-open
+func (e *Engine) CheckCircuits() map[string]error {
+	return e.circuits.Check()
 }
 
 // OnEvent does not care whether event is add or update. Prior filtering should be done in the controller/watcher
 func (e *Engine) OnEvent(event *kube.EnhancedEvent) {
+	e.RLock()
+	defer e.RUnlock()
+
+	if e.stopped {
+		log.Debug().Str("event", event.Message).Msg("Engine is stopped, dropping event")
+		return
+	}
 	e.Route.ProcessEvent(event, e.Registry)
 }
 
-// Stop stops all registered sinks
+// Reload validates the config and applies its route and receivers. The route is swapped atomically and only the
+// receivers that are added, changed or removed are touched; the pending events of the changed and removed receivers are
+// sent before their sinks are closed. If the config is invalid or a sink cannot be created, the current config stays.
+func (e *Engine) Reload(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	e.RLock()
+	current := make(map[string]sinks.ReceiverConfig, len(e.receivers))
+	for _, v := range e.receivers {
+		current[v.Name] = v
+	}
+	stopped := e.stopped
+	e.RUnlock()
+
+	next := make(map[string]bool, len(config.Receivers))
+	var removed []string
+	created := make(map[string]sinks.Sink)
+
+	for _, v := range config.Receivers {
+		next[v.Name] = true
+		old, exists := current[v.Name]
+		if exists && reflect.DeepEqual(old, v) {
+			continue
+		}
+		if exists {
+			removed = append(removed, v.Name)
+		}
+		if stopped {
+			continue
+		}
+
+		sink, err := e.newSink(v)
+		if err != nil {
+			for _, s := range created {
+				s.Close()
+			}
+			return fmt.Errorf("cannot initialize sink %q: %w", v.Name, err)
+		}
+		created[v.Name] = sink
+	}
+
+	for name := range current {
+		if !next[name] {
+			removed = append(removed, name)
+		}
+	}
+
+	// Processing of the events waits until the old receivers are drained, so that no event is routed to a receiver
+	// that is not registered yet
+	e.Lock()
+	defer e.Unlock()
+
+	if e.stopped != stopped {
+		for _, s := range created {
+			s.Close()
+		}
+		return fmt.Errorf("engine is started or stopped during the reload")
+	}
+
+	if !e.stopped {
+		for _, name := range removed {
+			log.Info().Str("name", name).Msg("Unregistering sink")
+			e.Registry.Unregister(name)
+		}
+		for _, v := range config.Receivers {
+			if sink, ok := created[v.Name]; ok {
+				e.register(v, sink)
+			}
+		}
+	}
+
+	e.Route = config.Route
+	e.receivers = config.Receivers
+	return nil
+}
+
+// Start registers the sinks again after the engine is stopped, it is a no-op for a running engine
+func (e *Engine) Start() {
+	e.Lock()
+	defer e.Unlock()
+
+	if !e.stopped {
+		return
+	}
+	e.registerSinks()
+	e.stopped = false
+}
+
+// Stop stops all registered sinks, it is safe to call it more than once
 func (e *Engine) Stop() {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.stopped {
+		return
+	}
 	log.Info().Msg("Closing sinks")
 	e.Registry.Close()
+	e.stopped = true
 	log.Info().Msg("All sinks closed")
 }
@@ -63,3 +63,124 @@ func TestEngineDropSimple(t *testing.T) {
 	assert.NotContains(t, config.Ref.Events, ev)
 	assert.Empty(t, config.Ref.Events)
 }
+
+func TestEngineStopStart(t *testing.T) {
+	config := &sinks.InMemoryConfig{}
+	cfg := &Config{
+		Route: Route{
+			Match: []Rule{{
+				Receiver: "in-mem",
+			}},
+		},
+		Receivers: []sinks.ReceiverConfig{{
+			Name:     "in-mem",
+			InMemory: config,
+		}},
+	}
+
+	e := NewEngine(cfg, &SyncRegistry{})
+	e.Stop()
+	e.Stop()
+
+	ev := &kube.EnhancedEvent{}
+	e.OnEvent(ev)
+	assert.Empty(t, config.Ref.Events)
+
+	e.Start()
+	e.Start()
+	e.OnEvent(ev)
+	assert.Contains(t, config.Ref.Events, ev)
+}
+
+func TestChannelRegistryCloseTwice(t *testing.T) {
+	config := &sinks.InMemoryConfig{}
+	cfg := &Config{
+		Receivers: []sinks.ReceiverConfig{{
+			Name:     "in-mem",
+			InMemory: config,
+		}},
+	}
+
+	e := NewEngine(cfg, &ChannelBasedReceiverRegistry{})
+	e.Stop()
+	e.Start()
+	e.Stop()
+	e.Registry.Close()
+}
+
+func TestEngineReload(t *testing.T) {
+	configA := &sinks.InMemoryConfig{}
+	configB := &sinks.InMemoryConfig{}
+	cfg := &Config{
+		Route: Route{
+			Match: []Rule{{
+				Receiver: "a",
+			}},
+		},
+		Receivers: []sinks.ReceiverConfig{
+			{Name: "a", InMemory: configA},
+			{Name: "b", InMemory: configB},
+		},
+	}
+
+	e := NewEngine(cfg, &SyncRegistry{})
+	sinkA := configA.Ref
+
+	configC := &sinks.InMemoryConfig{}
+	err := e.Reload(&Config{
+		Route: Route{
+			Match: []Rule{{
+				Receiver: "c",
+			}},
+		},
+		Receivers: []sinks.ReceiverConfig{
+			{Name: "a", InMemory: configA},
+			{Name: "c", InMemory: configC},
+		},
+	})
+	assert.NoError(t, err)
+
+	// Unchanged receivers are kept as is
+	assert.Same(t, sinkA, configA.Ref)
+
+	ev := &kube.EnhancedEvent{}
+	e.OnEvent(ev)
+	assert.Empty(t, configA.Ref.Events)
+	assert.Contains(t, configC.Ref.Events, ev)
+
+	// An invalid config does not replace the current one
+	err = e.Reload(&Config{
+		Route: Route{
+			Match: []Rule{{
+				Receiver: "unknown",
+			}},
+		},
+	})
+	assert.Error(t, err)
+
+	e.OnEvent(ev)
+	assert.Len(t, configC.Ref.Events, 2)
+}
+
+func TestEngineReloadRenamedMetric(t *testing.T) {
+	metrics := &sinks.PrometheusConfig{Metrics: []sinks.PrometheusMetricConfig{{Name: "test_engine_events_total"}}}
+	e := NewEngine(&Config{
+		Receivers: []sinks.ReceiverConfig{{Name: "metrics", Prometheus: metrics}},
+	}, &SyncRegistry{})
+	defer e.Stop()
+
+	// The renamed receiver takes over the metric in the same reload
+	err := e.Reload(&Config{
+		Receivers: []sinks.ReceiverConfig{{Name: "events", Prometheus: metrics}},
+	})
+	assert.NoError(t, err)
+
+	// Two receivers cannot define the same metric
+	err = e.Reload(&Config{
+		Receivers: []sinks.ReceiverConfig{
+			{Name: "events", Prometheus: metrics},
+			{Name: "metrics", Prometheus: metrics},
+		},
+	})
+	assert.Error(t, err)
+}
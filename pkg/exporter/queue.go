@@ -0,0 +1,163 @@
+package exporter
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+)
+
+// queuedEvent is an event in the queue of a receiver, seq is its sequence number in the write-ahead log if it has one
+type queuedEvent struct {
+	ev        kube.EnhancedEvent
+	seq       uint64
+	persisted bool
+}
+
+// ringQueue is a bounded FIFO of events, what happens when it is full depends on the overflow policy
+type ringQueue struct {
+	overflow string
+	// onDrop is called for each dropped event, queued tells whether the event was in the queue
+	onDrop func(item queuedEvent, queued bool)
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []queuedEvent
+	head     int
+	size     int
+	closed   bool
+}
+
+func newRingQueue(capacity int, overflow string, onDrop func(queuedEvent, bool)) *ringQueue {
+	q := &ringQueue{overflow: overflow, onDrop: onDrop, items: make([]queuedEvent, capacity)}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds the event to the queue, it reports whether the event is added. When the queue is full, either the new
+// event or the oldest one is dropped depending on the overflow policy.
+func (q *ringQueue) push(item queuedEvent) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.size == len(q.items) && !q.closed {
+		switch q.overflow {
+		case sinks.OverflowDropNewest:
+			q.onDrop(item, false)
+			return false
+		case sinks.OverflowDropOldest:
+			q.onDrop(q.items[q.head], true)
+			q.items[q.head] = queuedEvent{}
+			q.head = (q.head + 1) % len(q.items)
+			q.size--
+		default:
+			q.notFull.Wait()
+		}
+	}
+	if q.closed {
+		q.onDrop(item, false)
+		return false
+	}
+
+	q.items[(q.head+q.size)%len(q.items)] = item
+	q.size++
+	q.notEmpty.Signal()
+	return true
+}
+
+// pop waits for an event, it returns false when the queue is closed and empty
+func (q *ringQueue) pop() (queuedEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.size == 0 {
+		if q.closed {
+			return queuedEvent{}, false
+		}
+		q.notEmpty.Wait()
+	}
+
+	item := q.items[q.head]
+	// The slot is cleared so that the event can be garbage collected
+	q.items[q.head] = queuedEvent{}
+	q.head = (q.head + 1) % len(q.items)
+	q.size--
+	q.notFull.Signal()
+	return item, true
+}
+
+// popBatch waits for an event and then collects the events that arrive within the flush interval, until the batch has
+// the max number of events or the next event would make it bigger than the max bytes. It returns false when the queue
+// is closed and empty.
+func (q *ringQueue) popBatch(cfg sinks.BatchConfig, size func(*queuedEvent) int) ([]queuedEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.size == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.notEmpty.Wait()
+	}
+
+	deadline := time.Now().Add(cfg.FlushInterval)
+	timer := time.AfterFunc(cfg.FlushInterval, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.notEmpty.Broadcast()
+	})
+	defer timer.Stop()
+
+	var batch []queuedEvent
+	var bytes int
+	for len(batch) < cfg.Size {
+		if q.size == 0 {
+			if q.closed || !time.Now().Before(deadline) {
+				break
+			}
+			q.notEmpty.Wait()
+			continue
+		}
+
+		n := size(&q.items[q.head])
+		if len(batch) > 0 && bytes+n > cfg.MaxBytes {
+			break
+		}
+		bytes += n
+		batch = append(batch, q.items[q.head])
+		q.items[q.head] = queuedEvent{}
+		q.head = (q.head + 1) % len(q.items)
+		q.size--
+		q.notFull.Signal()
+	}
+	return batch, true
+}
+
+// close stops accepting events, the events in the queue can still be popped
+func (q *ringQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+// laneOf returns the worker of the event, the events of the same involved object always get the same worker
+func laneOf(ev *kube.EnhancedEvent, lanes int) int {
+	if lanes == 1 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	if ev.InvolvedObject.UID != "" {
+		h.Write([]byte(ev.InvolvedObject.UID))
+	} else {
+		h.Write([]byte(ev.InvolvedObject.Namespace + "/" + ev.InvolvedObject.Kind + "/" + ev.InvolvedObject.Name))
+	}
+	return int(h.Sum32() % uint32(lanes))
+}
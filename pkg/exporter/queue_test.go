@@ -0,0 +1,199 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func messageEvent(message string) queuedEvent {
+	item := queuedEvent{}
+	item.ev.Message = message
+	return item
+}
+
+func popMessages(q *ringQueue) []string {
+	q.close()
+	var messages []string
+	for {
+		item, ok := q.pop()
+		if !ok {
+			return messages
+		}
+		messages = append(messages, item.ev.Message)
+	}
+}
+
+// dropRecorder records the messages of the dropped events
+type dropRecorder []string
+
+func (d *dropRecorder) drop(item queuedEvent, _ bool) {
+	*d = append(*d, item.ev.Message)
+}
+
+func TestRingQueueDropOldest(t *testing.T) {
+	var dropped dropRecorder
+	q := newRingQueue(2, sinks.OverflowDropOldest, dropped.drop)
+	for i := 0; i < 4; i++ {
+		assert.True(t, q.push(messageEvent(fmt.Sprint(i))))
+	}
+	assert.Equal(t, dropRecorder{"0", "1"}, dropped)
+	assert.Equal(t, []string{"2", "3"}, popMessages(q))
+}
+
+func TestRingQueueDropNewest(t *testing.T) {
+	var dropped dropRecorder
+	q := newRingQueue(2, sinks.OverflowDropNewest, dropped.drop)
+	for i, want := range []bool{true, true, false, false} {
+		assert.Equal(t, want, q.push(messageEvent(fmt.Sprint(i))))
+	}
+	assert.Equal(t, dropRecorder{"2", "3"}, dropped)
+	assert.Equal(t, []string{"0", "1"}, popMessages(q))
+}
+
+func TestRingQueueBlock(t *testing.T) {
+	var dropped dropRecorder
+	q := newRingQueue(1, sinks.OverflowBlock, dropped.drop)
+	q.push(messageEvent("0"))
+
+	pushed := make(chan struct{})
+	go func() {
+		q.push(messageEvent("1"))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("push should block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	item, ok := q.pop()
+	require.True(t, ok)
+	assert.Equal(t, "0", item.ev.Message)
+	<-pushed
+	assert.Equal(t, []string{"1"}, popMessages(q))
+	assert.Empty(t, dropped)
+}
+
+// orderSink records the messages of each involved object in the order they are sent
+type orderSink struct {
+	mu   sync.Mutex
+	sent map[types.UID][]string
+}
+
+func (o *orderSink) Send(_ context.Context, ev *kube.EnhancedEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.sent[ev.InvolvedObject.UID] = append(o.sent[ev.InvolvedObject.UID], ev.Message)
+	return nil
+}
+
+func (o *orderSink) Close() {}
+
+func TestChannelBasedReceiverRegistryOrdering(t *testing.T) {
+	sink := &orderSink{sent: make(map[types.UID][]string)}
+	reg := &ChannelBasedReceiverRegistry{}
+	reg.RegisterWithQueue("ordered", sink, sinks.QueueConfig{Capacity: 10, Overflow: sinks.OverflowBlock, Workers: 4})
+
+	want := make(map[types.UID][]string)
+	for i := 0; i < 100; i++ {
+		ev := messageEvent(fmt.Sprint(i)).ev
+		ev.InvolvedObject.UID = types.UID(fmt.Sprintf("pod-%d", i%7))
+		want[ev.InvolvedObject.UID] = append(want[ev.InvolvedObject.UID], ev.Message)
+		reg.SendEvent("ordered", &ev)
+	}
+
+	// The queued events are sent before closing
+	reg.Close()
+	assert.Equal(t, want, sink.sent)
+}
+
+// stuckSink waits until it is released, like a sink whose endpoint does not respond
+type stuckSink struct {
+	release chan struct{}
+}
+
+func (s *stuckSink) Send(ctx context.Context, _ *kube.EnhancedEvent) error {
+	<-s.release
+	return nil
+}
+
+func (s *stuckSink) Close() {}
+
+func TestChannelBasedReceiverRegistryStuckReceiver(t *testing.T) {
+	stuck := &stuckSink{release: make(chan struct{})}
+	mem := &sinks.InMemory{Config: &sinks.InMemoryConfig{}}
+	reg := &ChannelBasedReceiverRegistry{}
+	reg.RegisterWithQueue("stuck", stuck, sinks.QueueConfig{Capacity: 2})
+	reg.RegisterWithQueue("working", mem, sinks.QueueConfig{})
+
+	// The full queue of the stuck receiver drops its events instead of holding up the other receiver
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			ev := messageEvent(fmt.Sprint(i)).ev
+			reg.SendEvent("stuck", &ev)
+			reg.SendEvent("working", &ev)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stuck receiver blocks the routing of the events")
+	}
+
+	close(stuck.release)
+	reg.Close()
+	assert.Len(t, mem.Events, 10)
+}
+
+func TestChannelBasedReceiverRegistryReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The log is left with events that are not sent, as if the process was killed
+	l, err := wal.Open(filepath.Join(dir, "team-a%2Fwebhook"), wal.Options{})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		ev := messageEvent(fmt.Sprint(i)).ev
+		_, err := l.Append(ev.ToJSON())
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	cfg := sinks.QueueConfig{Persistence: sinks.PersistenceConfig{Directory: dir}}
+	mem := &sinks.InMemory{Config: &sinks.InMemoryConfig{}}
+	reg := &ChannelBasedReceiverRegistry{}
+	reg.RegisterWithQueue("team-a/webhook", mem, cfg)
+	ev := messageEvent("3").ev
+	reg.SendEvent("team-a/webhook", &ev)
+	reg.Close()
+
+	var messages []string
+	for _, ev := range mem.Events {
+		messages = append(messages, ev.Message)
+	}
+	assert.Equal(t, []string{"0", "1", "2", "3"}, messages)
+
+	// All the events are acknowledged, nothing is sent again
+	mem = &sinks.InMemory{Config: &sinks.InMemoryConfig{}}
+	reg.RegisterWithQueue("team-a/webhook", mem, cfg)
+	reg.Close()
+	assert.Empty(t, mem.Events)
+}
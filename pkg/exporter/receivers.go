@@ -1,6 +1,8 @@
 package exporter
 
 import (
+	"fmt"
+
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
 )
@@ -9,5 +11,50 @@ import (
 type ReceiverRegistry interface {
 	SendEvent(string, *kube.EnhancedEvent)
 	Register(string, sinks.Sink)
+	// Unregister sends the pending events of the receiver and closes its sink
+	Unregister(string)
 	Close()
 }
+
+// QueuedReceiverRegistry is a ReceiverRegistry that queues the events of each receiver according to its config
+type QueuedReceiverRegistry interface {
+	ReceiverRegistry
+	RegisterWithQueue(string, sinks.Sink, sinks.QueueConfig)
+}
+
+// CheckReceiverLinks checks the dead letters and the fallbacks of the receivers. The linked receivers must exist and the
+// events cannot go around in a loop through them. The errors are keyed by the receiver name.
+func CheckReceiverLinks(receivers []sinks.ReceiverConfig) map[string]error {
+	links := make(map[string][]string, len(receivers))
+	for i := range receivers {
+		links[receivers[i].Name] = receivers[i].Links()
+	}
+
+	errs := make(map[string]error)
+	for _, r := range receivers {
+		if _, ok := links[r.DeadLetter]; r.DeadLetter != "" && !ok {
+			errs[r.Name] = fmt.Errorf("receiver %q has unknown dead letter %q", r.Name, r.DeadLetter)
+		} else if _, ok := links[r.CircuitBreaker.Fallback]; r.CircuitBreaker.Fallback != "" && !ok {
+			errs[r.Name] = fmt.Errorf("receiver %q has unknown fallback %q", r.Name, r.CircuitBreaker.Fallback)
+		} else if reachesLoop(r.Name, links, map[string]bool{}) {
+			errs[r.Name] = fmt.Errorf("dead letters and fallbacks of receiver %q form a loop", r.Name)
+		}
+	}
+	return errs
+}
+
+// reachesLoop reports whether a loop can be reached by following the links from the receiver
+func reachesLoop(name string, links map[string][]string, path map[string]bool) bool {
+	if path[name] {
+		return true
+	}
+	path[name] = true
+	defer delete(path, name)
+
+	for _, next := range links[name] {
+		if reachesLoop(next, links, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/rs/zerolog/log"
+)
+
+// retryError is the last error of a send that is attempted more than once
+type retryError struct {
+	err      error
+	attempts int
+}
+
+func (r *retryError) Error() string {
+	return fmt.Sprintf("giving up after %d attempts: %v", r.attempts, r.err)
+}
+
+func (r *retryError) Unwrap() error {
+	return r.err
+}
+
+func (r *retryError) Attempts() int {
+	return r.attempts
+}
+
+// retrySink sends the event again with an exponential backoff when the sink fails. The retries run in the loop of the
+// receiver, so a failing receiver delays only its own events.
+type retrySink struct {
+	sinks.Sink
+	name string
+	cfg  sinks.RetryConfig
+	// sleep waits for the backoff or until the context is done, it is replaced in the tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newRetrySink(sink sinks.Sink, name string, cfg sinks.RetryConfig) *retrySink {
+	cfg.SetDefaults()
+	return &retrySink{Sink: sink, name: name, cfg: cfg, sleep: sleepContext}
+}
+
+func (r *retrySink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return r.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch sends the failed events of the batch again together, the wait is the longest one asked by the events
+func (r *retrySink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	start := time.Now()
+	errs := make([]error, len(evs))
+	attempts := make([]int, len(evs))
+	pending := make([]int, len(evs))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for attempt := 1; ; attempt++ {
+		batch := make([]*kube.EnhancedEvent, len(pending))
+		for i, idx := range pending {
+			batch[i] = evs[idx]
+		}
+
+		var failed []int
+		wait := r.backoff(attempt)
+		for i, err := range sendBatch(ctx, r.Sink, batch) {
+			idx := pending[i]
+			errs[idx] = err
+			attempts[idx] = attempt
+			if err == nil || sinks.IsPermanent(err) {
+				continue
+			}
+			failed = append(failed, idx)
+			if retryAfter := sinks.RetryAfter(err); retryAfter > wait {
+				wait = retryAfter
+			}
+		}
+
+		if len(failed) == 0 || attempt >= r.cfg.MaxAttempts || ctx.Err() != nil {
+			break
+		}
+		if r.cfg.MaxElapsedTime > 0 && time.Since(start)+wait > r.cfg.MaxElapsedTime {
+			break
+		}
+
+		log.Debug().Err(errs[failed[0]]).Str("sink", r.name).Int("attempt", attempt).Int("events", len(failed)).
+			Dur("wait", wait).Msg("Retrying the events")
+		metrics.ReceiverRetries.WithLabelValues(r.name).Add(float64(len(failed)))
+		if r.sleep(ctx, wait) != nil {
+			break
+		}
+		pending = failed
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			errs[i] = r.giveUp(err, attempts[i])
+		}
+	}
+	return errs
+}
+
+func (r *retrySink) Unwrap() sinks.Sink {
+	return r.Sink
+}
+
+func (r *retrySink) giveUp(err error, attempts int) error {
+	if attempts == 1 {
+		return err
+	}
+	return &retryError{err: err, attempts: attempts}
+}
+
+// backoff is the wait after the given attempt, the interval grows by the multiplier up to the max interval and then
+// it is randomized by the jitter
+func (r *retrySink) backoff(attempt int) time.Duration {
+	interval := float64(r.cfg.InitialInterval) * math.Pow(r.cfg.Multiplier, float64(attempt-1))
+	interval = math.Min(interval, float64(r.cfg.MaxInterval))
+	if r.cfg.Jitter > 0 {
+		delta := r.cfg.Jitter * interval
+		interval = interval - delta + rand.Float64()*2*delta
+	}
+	return time.Duration(interval)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
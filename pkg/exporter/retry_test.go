@@ -0,0 +1,113 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakySink returns the errors in order and succeeds after them
+type flakySink struct {
+	errs  []error
+	calls int
+}
+
+func (f *flakySink) Send(context.Context, *kube.EnhancedEvent) error {
+	f.calls++
+	if f.calls <= len(f.errs) {
+		return f.errs[f.calls-1]
+	}
+	return nil
+}
+
+func (f *flakySink) Close() {}
+
+func newTestRetrySink(sink sinks.Sink, cfg sinks.RetryConfig) (*retrySink, *[]time.Duration) {
+	var waits []time.Duration
+	r := newRetrySink(sink, "test", cfg)
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	return r, &waits
+}
+
+func TestRetrySinkBackoff(t *testing.T) {
+	unavailable := errors.New("503 service unavailable")
+	sink := &flakySink{errs: []error{unavailable, unavailable, unavailable}}
+	r, waits := newTestRetrySink(sink, sinks.RetryConfig{
+		MaxAttempts:     5,
+		InitialInterval: time.Second,
+		MaxInterval:     3 * time.Second,
+	})
+
+	assert.NoError(t, r.Send(context.Background(), &kube.EnhancedEvent{}))
+	assert.Equal(t, 4, sink.calls)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, *waits)
+}
+
+func TestRetrySinkGivesUp(t *testing.T) {
+	unavailable := errors.New("503 service unavailable")
+	sink := &flakySink{errs: []error{unavailable, unavailable, unavailable}}
+	r, _ := newTestRetrySink(sink, sinks.RetryConfig{MaxAttempts: 3})
+
+	err := r.Send(context.Background(), &kube.EnhancedEvent{})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, unavailable))
+	assert.Equal(t, 3, sink.calls)
+
+	var ae attemptsError
+	require.True(t, errors.As(err, &ae))
+	assert.Equal(t, 3, ae.Attempts())
+}
+
+func TestRetrySinkPermanentError(t *testing.T) {
+	sink := &flakySink{errs: []error{sinks.Permanent(errors.New("400 bad request"))}}
+	r, waits := newTestRetrySink(sink, sinks.RetryConfig{MaxAttempts: 3})
+
+	err := r.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.True(t, sinks.IsPermanent(err))
+	assert.Equal(t, 1, sink.calls)
+	assert.Empty(t, *waits)
+}
+
+func TestRetrySinkRateLimited(t *testing.T) {
+	sink := &flakySink{errs: []error{sinks.RateLimited(errors.New("429 too many requests"), 10*time.Second)}}
+	r, waits := newTestRetrySink(sink, sinks.RetryConfig{MaxAttempts: 3})
+
+	assert.NoError(t, r.Send(context.Background(), &kube.EnhancedEvent{}))
+	assert.Equal(t, []time.Duration{10 * time.Second}, *waits)
+
+	// The wait asked by the downstream does not fit into the max elapsed time
+	sink = &flakySink{errs: []error{sinks.RateLimited(errors.New("429 too many requests"), time.Minute)}}
+	r, waits = newTestRetrySink(sink, sinks.RetryConfig{MaxAttempts: 3, MaxElapsedTime: 30 * time.Second})
+
+	assert.Error(t, r.Send(context.Background(), &kube.EnhancedEvent{}))
+	assert.Equal(t, 1, sink.calls)
+	assert.Empty(t, *waits)
+}
+
+func TestRetrySinkJitter(t *testing.T) {
+	r := newRetrySink(&flakySink{}, "test", sinks.RetryConfig{MaxAttempts: 10, Jitter: 0.5})
+	for attempt := 1; attempt < 10; attempt++ {
+		wait := r.backoff(attempt)
+		assert.GreaterOrEqual(t, int64(wait), int64(r.cfg.InitialInterval/2))
+		assert.LessOrEqual(t, int64(wait), int64(r.cfg.MaxInterval*3/2))
+	}
+}
+
+func TestRetrySinkContextDone(t *testing.T) {
+	sink := &flakySink{errs: []error{errors.New("timeout"), errors.New("timeout")}}
+	r := newRetrySink(sink, "test", sinks.RetryConfig{MaxAttempts: 3, InitialInterval: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, r.Send(ctx, &kube.EnhancedEvent{}))
+	assert.Equal(t, 1, sink.calls)
+}
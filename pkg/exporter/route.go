@@ -1,6 +1,15 @@
 package exporter
 
-import "github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
 
 // Route allows using rules to drop events or match events to specific receivers.
 // It also allows using routes recursively for complex route building to fit
@@ -12,9 +21,21 @@ type Route struct {
 }
 
 func (r *Route) ProcessEvent(ev *kube.EnhancedEvent, registry ReceiverRegistry) {
+	_, span := tracing.Tracer().Start(ev.Context(), "Route.ProcessEvent")
+	defer span.End()
+
+	r.processEvent(ev, registry, rootRoutePath, span)
+}
+
+// rootRoutePath identifies the root route in the metrics, the sub-routes are identified by their indexes like "0.1"
+const rootRoutePath = "root"
+
+func (r *Route) processEvent(ev *kube.EnhancedEvent, registry ReceiverRegistry, path string, span trace.Span) {
 	// First determine whether we will drop the event: If any of the drop is matched, we break the loop
 	for _, v := range r.Drop {
 		if v.MatchesEvent(ev) {
+			metrics.RouteDropped.WithLabelValues(path).Inc()
+			span.AddEvent("dropped", trace.WithAttributes(attribute.String("route", path)))
 			return
 		}
 	}
@@ -24,6 +45,9 @@ func (r *Route) ProcessEvent(ev *kube.EnhancedEvent, registry ReceiverRegistry)
 	for _, rule := range r.Match {
 		if rule.MatchesEvent(ev) {
 			if rule.Receiver != "" {
+				metrics.RouteMatched.WithLabelValues(path, rule.Receiver).Inc()
+				span.AddEvent("matched", trace.WithAttributes(
+					attribute.String("route", path), attribute.String("receiver", rule.Receiver)))
 				registry.SendEvent(rule.Receiver, ev)
 				// Send the event down the hole
 			}
@@ -34,8 +58,46 @@ func (r *Route) ProcessEvent(ev *kube.EnhancedEvent, registry ReceiverRegistry)
 
 	// If all matches are satisfied, we can send them down to the rabbit hole
 	if matchesAll {
-		for _, subRoute := range r.Routes {
-			subRoute.ProcessEvent(ev, registry)
+		for i, subRoute := range r.Routes {
+			subRoute.processEvent(ev, registry, subRoutePath(path, i), span)
+		}
+	}
+}
+
+func (r *Route) isEmpty() bool {
+	return len(r.Drop) == 0 && len(r.Match) == 0 && len(r.Routes) == 0
+}
+
+func subRoutePath(path string, i int) string {
+	if path == rootRoutePath {
+		return strconv.Itoa(i)
+	}
+	return path + "." + strconv.Itoa(i)
+}
+
+// Validate checks the rules of the route and its sub-routes recursively, the receivers of the rules must be one of the
+// given receivers.
+func (r *Route) Validate(receivers map[string]bool) error {
+	for i := range r.Drop {
+		if err := r.Drop[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range r.Match {
+		rule := &r.Match[i]
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+		if rule.Receiver != "" && !receivers[rule.Receiver] {
+			return fmt.Errorf("route refers to unknown receiver %q", rule.Receiver)
+		}
+	}
+
+	for i := range r.Routes {
+		if err := r.Routes[i].Validate(receivers); err != nil {
+			return err
 		}
 	}
+	return nil
 }
@@ -2,7 +2,9 @@ package exporter
 
 import (
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -16,6 +18,10 @@ func (t *testReceiverRegistry) Register(string, sinks.Sink) {
 	panic("Why do you call this? It's for counting imaginary events for tests only")
 }
 
+func (t *testReceiverRegistry) Unregister(string) {
+	panic("Why do you call this? It's for counting imaginary events for tests only")
+}
+
 func (t *testReceiverRegistry) SendEvent(name string, event *kube.EnhancedEvent) {
 	if t.rcvd == nil {
 		t.rcvd = make(map[string][]*kube.EnhancedEvent)
@@ -222,4 +228,53 @@ func Test_GHIssue51(t *testing.T) {
 
 	assert.True(t, reg.isEventRcvd("elastic", &ev1))
 	assert.False(t, reg.isEventRcvd("elastic", &ev2))
-}
\ No newline at end of file
+}
+func TestRouteValidate(t *testing.T) {
+	receivers := map[string]bool{"osman": true}
+
+	r := Route{
+		Match: []Rule{{
+			Namespace: "kube-*",
+			Receiver:  "osman",
+		}},
+		Routes: []Route{{
+			Drop: []Rule{{
+				Reason: "Failed(",
+			}},
+		}},
+	}
+	assert.Error(t, r.Validate(receivers))
+
+	r.Routes[0].Drop[0].Reason = "Failed.*"
+	assert.NoError(t, r.Validate(receivers))
+
+	r.Routes[0].Match = []Rule{{Receiver: "any"}}
+	assert.Error(t, r.Validate(receivers))
+}
+
+func TestRouteMetrics(t *testing.T) {
+	reg := testReceiverRegistry{}
+
+	r := Route{
+		Routes: []Route{{
+			Drop: []Rule{{Namespace: "kube-system"}},
+			Routes: []Route{{
+				Match: []Rule{{Receiver: "metrics-test"}},
+			}},
+		}},
+	}
+
+	dropped := testutil.ToFloat64(metrics.RouteDropped.WithLabelValues("0"))
+	matched := testutil.ToFloat64(metrics.RouteMatched.WithLabelValues("0.0", "metrics-test"))
+
+	ev1 := kube.EnhancedEvent{}
+	ev1.Namespace = "kube-system"
+	ev2 := kube.EnhancedEvent{}
+	ev2.Namespace = "default"
+	r.ProcessEvent(&ev1, &reg)
+	r.ProcessEvent(&ev2, &reg)
+	r.ProcessEvent(&ev2, &reg)
+
+	assert.Equal(t, dropped+1, testutil.ToFloat64(metrics.RouteDropped.WithLabelValues("0")))
+	assert.Equal(t, matched+2, testutil.ToFloat64(metrics.RouteMatched.WithLabelValues("0.0", "metrics-test")))
+}
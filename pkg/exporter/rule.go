@@ -1,6 +1,7 @@
 package exporter
 
 import (
+	"fmt"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
 	"regexp"
 )
@@ -29,6 +30,24 @@ type Rule struct {
 	Receiver    string
 }
 
+// Validate checks that all the fields of the rule are valid regular expressions
+func (r *Rule) Validate() error {
+	patterns := []string{r.Message, r.APIVersion, r.Kind, r.Namespace, r.Reason, r.Type, r.Component, r.Host}
+	for _, v := range r.Labels {
+		patterns = append(patterns, v)
+	}
+	for _, v := range r.Annotations {
+		patterns = append(patterns, v)
+	}
+
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid rule pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
 // MatchesEvent compares the rule to an event and returns a boolean value to indicate
 // whether the event is compatible with the rule. All fields are compared as regular expressions
 // so the user must keep that in mind while writing rules.
@@ -1,7 +1,6 @@
 package exporter
 
 import (
-	"context"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/sinks"
 	"github.com/rs/zerolog/log"
@@ -14,7 +13,7 @@ type SyncRegistry struct {
 }
 
 func (s *SyncRegistry) SendEvent(name string, event *kube.EnhancedEvent) {
-	err := s.reg[name].Send(context.Background(), event)
+	err := s.reg[name].Send(event.Context(), event)
 	if err != nil {
 		log.Debug().Err(err).Str("sink", name).Str("event", string(event.UID)).Msg("Cannot send event")
 	}
@@ -28,9 +27,18 @@ func (s *SyncRegistry) Register(name string, sink sinks.Sink) {
 	s.reg[name] = sink
 }
 
+func (s *SyncRegistry) Unregister(name string) {
+	if sink, ok := s.reg[name]; ok {
+		log.Info().Str("sink", name).Msg("Closing sink")
+		sink.Close()
+		delete(s.reg, name)
+	}
+}
+
 func (s *SyncRegistry) Close() {
 	for name, sink := range s.reg {
 		log.Info().Str("sink", name).Msg("Closing sink")
 		sink.Close()
 	}
+	s.reg = nil
 }
@@ -0,0 +1,113 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Check returns an error when the component is unhealthy
+type Check func() error
+
+// CheckSet reports the health of a changing set of components, e.g. the receivers. A nil error means healthy.
+type CheckSet func() map[string]error
+
+// Checker serves the health of the components. The liveness checks tell whether the process is wedged and should be
+// restarted, the readiness checks tell whether it is exporting the events. Readiness includes the liveness checks.
+type Checker struct {
+	mu        sync.RWMutex
+	liveness  []CheckSet
+	readiness []CheckSet
+}
+
+// ComponentStatus is the health of a single component
+type ComponentStatus struct {
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Response is the JSON body of the health endpoints
+type Response struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+const (
+	statusOK     = "ok"
+	statusFailed = "failed"
+)
+
+func single(name string, check Check) CheckSet {
+	return func() map[string]error {
+		return map[string]error{name: check()}
+	}
+}
+
+// AddLiveness adds a check whose failure restarts the process
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness = append(c.liveness, single(name, check))
+}
+
+// AddReadiness adds a check whose failure marks the process as not ready
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.AddReadinessSet(single(name, check))
+}
+
+// AddReadinessSet adds checks for a set of components whose failure marks the process as not ready
+func (c *Checker) AddReadinessSet(checks CheckSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness = append(c.readiness, checks)
+}
+
+// Live runs the liveness checks
+func (c *Checker) Live() Response {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return run(c.liveness)
+}
+
+// Ready runs the liveness and the readiness checks
+func (c *Checker) Ready() Response {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return run(append(append([]CheckSet{}, c.liveness...), c.readiness...))
+}
+
+func run(sets []CheckSet) Response {
+	res := Response{Status: statusOK, Components: make(map[string]ComponentStatus)}
+	for _, set := range sets {
+		for name, err := range set() {
+			status := ComponentStatus{Healthy: err == nil}
+			if err != nil {
+				status.Error = err.Error()
+				res.Status = statusFailed
+			}
+			res.Components[name] = status
+		}
+	}
+	return res
+}
+
+// LivenessHandler serves the liveness checks, it responds with 503 if any of them fails
+func (c *Checker) LivenessHandler() http.Handler {
+	return handler(c.Live)
+}
+
+// ReadinessHandler serves the liveness and the readiness checks, it responds with 503 if any of them fails
+func (c *Checker) ReadinessHandler() http.Handler {
+	return handler(c.Ready)
+}
+
+func handler(fn func() Response) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := fn()
+		w.Header().Set("Content-Type", "application/json")
+		if res.Status != statusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
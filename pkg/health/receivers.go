@@ -0,0 +1,127 @@
+package health
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Config sets when a receiver is considered unhealthy
+type Config struct {
+	// Window is the period over which the success rate of a receiver is calculated
+	Window time.Duration `yaml:"window"`
+	// MinSuccessRate is the ratio of the successful sends below which a receiver is unhealthy
+	MinSuccessRate float64 `yaml:"minSuccessRate"`
+	// MinSamples is the number of sends in the window required to judge a receiver, so that a single failure of a
+	// quiet receiver does not make it unhealthy
+	MinSamples int `yaml:"minSamples"`
+}
+
+const (
+	defaultWindow         = 5 * time.Minute
+	defaultMinSuccessRate = 0.5
+	defaultMinSamples     = 10
+	// buckets is the number of slots the window is divided into, the window slides by one slot at a time
+	buckets = 10
+)
+
+func (c *Config) setDefaults() {
+	if c.Window == 0 {
+		c.Window = defaultWindow
+	}
+	if c.MinSuccessRate == 0 {
+		c.MinSuccessRate = defaultMinSuccessRate
+	}
+	if c.MinSamples == 0 {
+		c.MinSamples = defaultMinSamples
+	}
+}
+
+// ReceiverTracker keeps the recent send results of the receivers
+type ReceiverTracker struct {
+	cfg       Config
+	mu        sync.Mutex
+	receivers map[string]*window
+	now       func() time.Time
+}
+
+type window struct {
+	slots [buckets]slot
+}
+
+type slot struct {
+	index     int64
+	successes int
+	failures  int
+}
+
+func NewReceiverTracker(cfg Config) *ReceiverTracker {
+	cfg.setDefaults()
+	return &ReceiverTracker{
+		cfg:       cfg,
+		receivers: make(map[string]*window),
+		now:       time.Now,
+	}
+}
+
+func (t *ReceiverTracker) slotIndex() int64 {
+	return t.now().UnixNano() / int64(t.cfg.Window/buckets)
+}
+
+// Record adds the result of a send of the receiver
+func (t *ReceiverTracker) Record(name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w := t.receivers[name]
+	if w == nil {
+		w = &window{}
+		t.receivers[name] = w
+	}
+
+	index := t.slotIndex()
+	s := &w.slots[index%buckets]
+	if s.index != index {
+		*s = slot{index: index}
+	}
+	if err != nil {
+		s.failures++
+	} else {
+		s.successes++
+	}
+}
+
+// Forget removes a receiver, e.g. when it is removed from the config
+func (t *ReceiverTracker) Forget(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.receivers, name)
+}
+
+// Check reports the receivers whose success rate in the window is below the threshold as unhealthy
+func (t *ReceiverTracker) Check() map[string]error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index := t.slotIndex()
+	res := make(map[string]error, len(t.receivers))
+	for name, w := range t.receivers {
+		var successes, failures int
+		for _, s := range w.slots {
+			if s.index > index-buckets {
+				successes += s.successes
+				failures += s.failures
+			}
+		}
+
+		var err error
+		total := successes + failures
+		if total >= t.cfg.MinSamples {
+			if rate := float64(successes) / float64(total); rate < t.cfg.MinSuccessRate {
+				err = fmt.Errorf("%d of the last %d sends failed", failures, total)
+			}
+		}
+		res["receiver/"+name] = err
+	}
+	return res
+}
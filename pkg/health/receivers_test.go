@@ -0,0 +1,52 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiverTracker(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tracker := NewReceiverTracker(Config{Window: 10 * time.Second, MinSuccessRate: 0.5, MinSamples: 4})
+	tracker.now = func() time.Time { return now }
+
+	failure := errors.New("connection refused")
+
+	// Not enough samples to judge
+	tracker.Record("slack", failure)
+	tracker.Record("slack", failure)
+	tracker.Record("webhook", nil)
+	assert.NoError(t, tracker.Check()["receiver/slack"])
+	assert.NoError(t, tracker.Check()["receiver/webhook"])
+
+	tracker.Record("slack", failure)
+	tracker.Record("slack", nil)
+	assert.Error(t, tracker.Check()["receiver/slack"])
+
+	// The failures slide out of the window
+	now = now.Add(11 * time.Second)
+	tracker.Record("slack", nil)
+	assert.NoError(t, tracker.Check()["receiver/slack"])
+
+	tracker.Forget("slack")
+	assert.NotContains(t, tracker.Check(), "receiver/slack")
+	assert.Contains(t, tracker.Check(), "receiver/webhook")
+}
+
+func TestChecker(t *testing.T) {
+	c := &Checker{}
+	c.AddLiveness("watch", func() error { return nil })
+	c.AddReadiness("informer", func() error { return errors.New("not synced") })
+
+	live := c.Live()
+	assert.Equal(t, "ok", live.Status)
+	assert.Len(t, live.Components, 1)
+
+	ready := c.Ready()
+	assert.Equal(t, "failed", ready.Status)
+	assert.True(t, ready.Components["watch"].Healthy)
+	assert.Equal(t, "not synced", ready.Components["informer"].Error)
+}
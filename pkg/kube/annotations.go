@@ -2,6 +2,7 @@ package kube
 
 import (
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/dynamic"
@@ -35,17 +36,14 @@ func (a *AnnotationCache) GetAnnotationsWithCache(reference *v1.ObjectReference)
 	uid := reference.UID
 
 	if val, ok := a.cache.Get(uid); ok {
+		metrics.CacheRequests.WithLabelValues("annotations", "hit").Inc()
 		return val.(map[string]string), nil
 	}
+	metrics.CacheRequests.WithLabelValues("annotations", "miss").Inc()
 
 	obj, err := GetObject(reference, a.clientset, a.dynClient)
 	if err == nil {
-		annotations := obj.GetAnnotations()
-		for key := range annotations {
-			if strings.Contains(key, "kubernetes.io/") || strings.Contains(key, "k8s.io/") {
-				delete(annotations, key)
-			}
-		}
+		annotations := filterAnnotations(obj.GetAnnotations())
 		a.cache.Add(uid, annotations)
 		return annotations, nil
 	}
@@ -56,6 +54,23 @@ func (a *AnnotationCache) GetAnnotationsWithCache(reference *v1.ObjectReference)
 		return nil, nil
 	}
 
+	metrics.LookupErrors.WithLabelValues("annotations").Inc()
 	return nil, err
 
 }
+
+// filterAnnotations returns a copy of the annotations without the ones managed by Kubernetes itself, they are mostly
+// noise for the receivers and can be quite large (e.g. last-applied-configuration)
+func filterAnnotations(annotations map[string]string) map[string]string {
+	if annotations == nil {
+		return nil
+	}
+	ret := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		if strings.Contains(key, "kubernetes.io/") || strings.Contains(key, "k8s.io/") {
+			continue
+		}
+		ret[key] = value
+	}
+	return ret
+}
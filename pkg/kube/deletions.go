@@ -0,0 +1,244 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DeletedReason is the reason of the events generated for deleted objects
+	DeletedReason = "Deleted"
+	// exporterComponent is used as the source of the events generated by the exporter itself
+	exporterComponent = "kubernetes-event-exporter"
+)
+
+// WatchedResource identifies a resource whose deletions are exported as events. Group is empty for the core API.
+type WatchedResource struct {
+	Group    string `yaml:"group"`
+	Version  string `yaml:"version"`
+	Resource string `yaml:"resource"`
+}
+
+func (w WatchedResource) gvr() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: w.Group, Version: w.Version, Resource: w.Resource}
+}
+
+// DeletionWatcher watches the configured resources and turns every deletion into an EnhancedEvent so that they can be
+// routed like the regular Kubernetes events.
+type DeletionWatcher struct {
+	factories []dynamicinformer.DynamicSharedInformerFactory
+	informers map[schema.GroupVersionResource]cache.SharedIndexInformer
+	stopper   chan struct{}
+	fn        EventHandler
+	filter    EventFilter
+	// active is set while the deletions are passed to the handler, see EventWatcher
+	active    int32
+	runOnce   sync.Once
+	closeOnce sync.Once
+}
+
+func NewDeletionWatcher(config *rest.Config, namespace string, resources []WatchedResource, fn EventHandler) (*DeletionWatcher, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dynClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher := &DeletionWatcher{
+		stopper:   make(chan struct{}),
+		fn:        fn,
+		informers: make(map[schema.GroupVersionResource]cache.SharedIndexInformer),
+	}
+
+	// Cluster scoped resources cannot be listed within a namespace, so there is a factory for each scope
+	namespaced := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynClient, 0, namespace, nil)
+	clusterScoped := dynamicinformer.NewDynamicSharedInformerFactory(dynClient, 0)
+	watcher.factories = []dynamicinformer.DynamicSharedInformerFactory{namespaced, clusterScoped}
+
+	for _, r := range resources {
+		gvr := r.gvr()
+		isNamespaced, err := isNamespacedResource(clientset, gvr)
+		if err != nil {
+			return nil, err
+		}
+
+		factory := clusterScoped
+		if isNamespaced {
+			factory = namespaced
+		}
+
+		informer := factory.ForResource(gvr).Informer()
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			DeleteFunc: watcher.OnDelete,
+		})
+		watcher.informers[gvr] = informer
+
+		log.Info().Str("resource", gvr.String()).Msg("Watching deletions")
+	}
+
+	return watcher, nil
+}
+
+func isNamespacedResource(clientset *kubernetes.Clientset, gvr schema.GroupVersionResource) (bool, error) {
+	resources, err := clientset.Discovery().ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		return false, fmt.Errorf("cannot discover %s: %w", gvr.GroupVersion().String(), err)
+	}
+
+	for _, r := range resources.APIResources {
+		if r.Name == gvr.Resource {
+			return r.Namespaced, nil
+		}
+	}
+	return false, fmt.Errorf("resource %s is not served by the API server", gvr.String())
+}
+
+func (d *DeletionWatcher) OnDelete(obj interface{}) {
+	if atomic.LoadInt32(&d.active) == 0 {
+		return
+	}
+
+	// The watch might have missed the deletion itself, then the last known state is wrapped
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		log.Error().Msgf("Unexpected object type %T for the deletion", obj)
+		return
+	}
+
+	metrics.EventsReceived.WithLabelValues("deletion").Inc()
+
+	if d.filter != nil && !d.filter(u.GetNamespace(), u.GetUID()) {
+		metrics.EventsDiscarded.WithLabelValues("not_owned").Inc()
+		return
+	}
+
+	ev := NewDeletionEvent(u, time.Now())
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "DeletionWatcher.OnDelete",
+		trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(ev.TraceAttributes()...))
+	defer span.End()
+	ev.SetContext(ctx)
+
+	log.Debug().
+		Str("namespace", u.GetNamespace()).
+		Str("kind", u.GetKind()).
+		Str("name", u.GetName()).
+		Msg("Received deletion")
+
+	d.fn(ev)
+}
+
+// NewDeletionEvent creates an event for the deleted object, the final labels, annotations and the owners of the object
+// are kept in the involved object.
+func NewDeletionEvent(obj *unstructured.Unstructured, now time.Time) *EnhancedEvent {
+	timestamp := metav1.NewTime(now)
+	if deletedAt := obj.GetDeletionTimestamp(); deletedAt != nil {
+		timestamp = *deletedAt
+	}
+
+	ref := corev1.ObjectReference{
+		Kind:            obj.GetKind(),
+		Namespace:       obj.GetNamespace(),
+		Name:            obj.GetName(),
+		UID:             obj.GetUID(),
+		APIVersion:      obj.GetAPIVersion(),
+		ResourceVersion: obj.GetResourceVersion(),
+	}
+
+	ev := &EnhancedEvent{
+		Event: corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              fmt.Sprintf("%s.%x", obj.GetName(), now.UnixNano()),
+				Namespace:         obj.GetNamespace(),
+				UID:               uuid.NewUUID(),
+				CreationTimestamp: metav1.NewTime(now),
+			},
+			InvolvedObject:      ref,
+			Reason:              DeletedReason,
+			Message:             fmt.Sprintf("%s %s was deleted", obj.GetKind(), obj.GetName()),
+			Source:              corev1.EventSource{Component: exporterComponent},
+			FirstTimestamp:      timestamp,
+			LastTimestamp:       timestamp,
+			Count:               1,
+			Type:                corev1.EventTypeNormal,
+			ReportingController: exporterComponent,
+		},
+		InvolvedObject: EnhancedObjectReference{
+			ObjectReference: ref,
+			Labels:          obj.GetLabels(),
+			Annotations:     filterAnnotations(obj.GetAnnotations()),
+			OwnerReferences: obj.GetOwnerReferences(),
+		},
+	}
+
+	return ev
+}
+
+// SetFilter sets the filter for the deletions, it should be called before the watcher is started
+func (d *DeletionWatcher) SetFilter(filter EventFilter) {
+	d.filter = filter
+}
+
+// CheckSynced returns an error until all the informers have listed their resources
+func (d *DeletionWatcher) CheckSynced() error {
+	for gvr, informer := range d.informers {
+		if !informer.HasSynced() {
+			return fmt.Errorf("informer of %s is not synced", gvr.String())
+		}
+	}
+	return nil
+}
+
+// Run starts the informers without passing the deletions to the handler, it is safe to call it more than once
+func (d *DeletionWatcher) Run() {
+	d.runOnce.Do(func() {
+		for _, f := range d.factories {
+			f.Start(d.stopper)
+		}
+	})
+}
+
+// Start passes the deletions to the handler, starting the informers if required. It can be called again after Stop.
+func (d *DeletionWatcher) Start() {
+	d.Run()
+	atomic.StoreInt32(&d.active, 1)
+}
+
+// Stop stops passing the deletions to the handler, the informers keep running until Close is called
+func (d *DeletionWatcher) Stop() {
+	atomic.StoreInt32(&d.active, 0)
+}
+
+// Close stops the watcher and the informers, the watcher cannot be started again
+func (d *DeletionWatcher) Close() {
+	d.Stop()
+	d.closeOnce.Do(func() {
+		close(d.stopper)
+	})
+}
@@ -0,0 +1,63 @@
+package kube
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNewDeletionEvent(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("apps/v1")
+	obj.SetKind("Deployment")
+	obj.SetNamespace("default")
+	obj.SetName("nginx")
+	obj.SetUID("1234")
+	obj.SetLabels(map[string]string{"app": "nginx"})
+	obj.SetAnnotations(map[string]string{
+		"owner":                             "team-a",
+		"deployment.kubernetes.io/revision": "3",
+	})
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "example.com/v1",
+		Kind:       "Application",
+		Name:       "shop",
+		UID:        "5678",
+	}})
+
+	now := time.Date(2021, 11, 5, 10, 0, 0, 0, time.UTC)
+	ev := NewDeletionEvent(obj, now)
+
+	assert.Equal(t, DeletedReason, ev.Reason)
+	assert.Equal(t, corev1.EventTypeNormal, ev.Type)
+	assert.Equal(t, "default", ev.Namespace)
+	assert.NotEmpty(t, ev.UID)
+	assert.Equal(t, now, ev.FirstTimestamp.Time)
+	assert.Equal(t, "Deployment", ev.InvolvedObject.Kind)
+	assert.Equal(t, "nginx", ev.InvolvedObject.Name)
+	assert.EqualValues(t, "1234", ev.InvolvedObject.UID)
+	assert.Equal(t, map[string]string{"app": "nginx"}, ev.InvolvedObject.Labels)
+	assert.Equal(t, map[string]string{"owner": "team-a"}, ev.InvolvedObject.Annotations)
+	assert.Len(t, ev.InvolvedObject.OwnerReferences, 1)
+	assert.Equal(t, "shop", ev.InvolvedObject.OwnerReferences[0].Name)
+
+	// The original object must not be modified by the annotation filtering
+	assert.Len(t, obj.GetAnnotations(), 2)
+}
+
+func TestNewDeletionEvent_UsesDeletionTimestamp(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetKind("Namespace")
+	obj.SetName("test")
+	deletedAt := metav1.NewTime(time.Date(2021, 11, 5, 9, 0, 0, 0, time.UTC))
+	obj.SetDeletionTimestamp(&deletedAt)
+
+	ev := NewDeletionEvent(obj, time.Date(2021, 11, 5, 10, 0, 0, 0, time.UTC))
+
+	assert.True(t, deletedAt.Equal(&ev.LastTimestamp))
+	assert.Empty(t, ev.Namespace)
+}
@@ -1,8 +1,11 @@
 package kube
 
 import (
+	"context"
 	"encoding/json"
+	"go.opentelemetry.io/otel/attribute"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"strings"
 	"time"
 )
@@ -10,6 +13,44 @@ import (
 type EnhancedEvent struct {
 	corev1.Event   `json:",inline"`
 	InvolvedObject EnhancedObjectReference `json:"involvedObject"`
+	// DeadLetter is only set for the events sent to a dead-letter receiver
+	DeadLetter *DeadLetter `json:"deadLetter,omitempty"`
+
+	// ctx carries the trace of the event from the watcher to the sinks, it is not serialized
+	ctx context.Context
+}
+
+// DeadLetter describes why a receiver failed to send an event, so that the event can be inspected and replayed later
+type DeadLetter struct {
+	Receiver       string    `json:"receiver"`
+	Error          string    `json:"error"`
+	Attempts       int       `json:"attempts"`
+	FirstAttemptAt time.Time `json:"firstAttemptAt"`
+	FailedAt       time.Time `json:"failedAt"`
+}
+
+// Context returns the context of the event which carries its trace, it is never nil
+func (e *EnhancedEvent) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// SetContext sets the context of the event
+func (e *EnhancedEvent) SetContext(ctx context.Context) {
+	e.ctx = ctx
+}
+
+// TraceAttributes describes the event on a span
+func (e *EnhancedEvent) TraceAttributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("event.uid", string(e.UID)),
+		attribute.String("event.namespace", e.Namespace),
+		attribute.String("event.reason", e.Reason),
+		attribute.String("event.involved_object.kind", e.InvolvedObject.Kind),
+		attribute.String("event.involved_object.name", e.InvolvedObject.Name),
+	}
 }
 
 // DeDot replaces all dots in the labels and annotations with underscores. This is required for example in the
@@ -40,6 +81,8 @@ type EnhancedObjectReference struct {
 	corev1.ObjectReference `json:",inline"`
 	Labels                 map[string]string `json:"labels,omitempty"`
 	Annotations            map[string]string `json:"annotations,omitempty"`
+	// OwnerReferences is only known for the objects of the deletion events
+	OwnerReferences []metav1.OwnerReference `json:"ownerReferences,omitempty"`
 }
 
 // ToJSON does not return an error because we are %99 confident it is JSON serializable.
@@ -49,6 +92,21 @@ func (e *EnhancedEvent) ToJSON() []byte {
 	return b
 }
 
+// Time returns when the event last happened: the last timestamp, or the event time of the newer events that do not set
+// it, or the first timestamp and the creation time as a last resort
+func (e *EnhancedEvent) Time() time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	case !e.FirstTimestamp.IsZero():
+		return e.FirstTimestamp.Time
+	default:
+		return e.CreationTimestamp.Time
+	}
+}
+
 func (e *EnhancedEvent) GetTimestampMs() int64 {
 	return e.FirstTimestamp.UnixNano() / (int64(time.Millisecond) / int64(time.Nanosecond))
 }
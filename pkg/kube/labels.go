@@ -2,6 +2,7 @@ package kube
 
 import (
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/dynamic"
@@ -34,8 +35,10 @@ func (l *LabelCache) GetLabelsWithCache(reference *v1.ObjectReference) (map[stri
 	uid := reference.UID
 
 	if val, ok := l.cache.Get(uid); ok {
+		metrics.CacheRequests.WithLabelValues("labels", "hit").Inc()
 		return val.(map[string]string), nil
 	}
+	metrics.CacheRequests.WithLabelValues("labels", "miss").Inc()
 
 	obj, err := GetObject(reference, l.clientset, l.dynClient)
 	if err == nil {
@@ -53,5 +56,6 @@ func (l *LabelCache) GetLabelsWithCache(reference *v1.ObjectReference) (map[stri
 	}
 
 	// An non-ignorable error occurred
+	metrics.LookupErrors.WithLabelValues("labels").Inc()
 	return nil, err
 }
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/rs/zerolog/log"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -18,35 +22,74 @@ import (
 type LeaderElectionConfig struct {
 	Enabled          bool   `yaml:"enabled"`
 	LeaderElectionID string `yaml:"leaderElectionID"`
+	// LockType is either "leases" or "configmapsleases". The latter holds both the old ConfigMap lock and the Lease,
+	// it should be used while upgrading from versions that used the ConfigMap lock.
+	LockType string `yaml:"lockType"`
+	// Namespace of the lock, defaults to the namespace the exporter runs in
+	Namespace string `yaml:"namespace"`
+	// Identity must be unique for each replica, defaults to the hostname with a random suffix
+	Identity      string        `yaml:"identity"`
+	LeaseDuration time.Duration `yaml:"leaseDuration"`
+	RenewDeadline time.Duration `yaml:"renewDeadline"`
+	RetryPeriod   time.Duration `yaml:"retryPeriod"`
 }
 
 const (
 	inClusterNamespacePath  = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 	defaultLeaderElectionID = "kubernetes-event-exporter"
 	defaultNamespace        = "default" // this is used for local development
+	defaultLockType         = resourcelock.LeasesResourceLock
 	defaultLeaseDuration    = 15 * time.Second
 	defaultRenewDeadline    = 10 * time.Second
 	defaultRetryPeriod      = 2 * time.Second
 )
 
-// NewResourceLock creates a new config map resource lock for use in a leader
-// election loop
-func newResourceLock(config *rest.Config, leaderElectionID string) (resourcelock.Interface, error) {
-	if leaderElectionID == "" {
-		leaderElectionID = defaultLeaderElectionID
+func (c *LeaderElectionConfig) setDefaults() {
+	if c.LeaderElectionID == "" {
+		c.LeaderElectionID = defaultLeaderElectionID
 	}
+	if c.LockType == "" {
+		c.LockType = defaultLockType
+	}
+	if c.LeaseDuration == 0 {
+		c.LeaseDuration = defaultLeaseDuration
+	}
+	if c.RenewDeadline == 0 {
+		c.RenewDeadline = defaultRenewDeadline
+	}
+	if c.RetryPeriod == 0 {
+		c.RetryPeriod = defaultRetryPeriod
+	}
+}
 
-	leaderElectionNamespace, err := getInClusterNamespace()
-	if err != nil {
-		leaderElectionNamespace = defaultNamespace
+// newResourceLock creates a new lease based resource lock for use in a leader election loop
+func newResourceLock(config *rest.Config, cfg *LeaderElectionConfig) (resourcelock.Interface, error) {
+	switch cfg.LockType {
+	case resourcelock.LeasesResourceLock, resourcelock.ConfigMapsLeasesResourceLock:
+	default:
+		return nil, fmt.Errorf("unsupported leader election lock type %q, use %q or %q", cfg.LockType,
+			resourcelock.LeasesResourceLock, resourcelock.ConfigMapsLeasesResourceLock)
+	}
+
+	leaderElectionNamespace := cfg.Namespace
+	if leaderElectionNamespace == "" {
+		var err error
+		leaderElectionNamespace, err = getInClusterNamespace()
+		if err != nil {
+			log.Warn().Err(err).Str("namespace", defaultNamespace).Msg("Using the default namespace for leader election")
+			leaderElectionNamespace = defaultNamespace
+		}
 	}
 
 	// Leader id, needs to be unique
-	id, err := os.Hostname()
-	if err != nil {
-		return nil, err
+	id := cfg.Identity
+	if id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		id = hostname + "_" + string(uuid.NewUUID())
 	}
-	id = id + "_" + string(uuid.NewUUID())
 
 	// Construct client for leader election
 	client, err := kubernetes.NewForConfig(config)
@@ -54,9 +97,9 @@ func newResourceLock(config *rest.Config, leaderElectionID string) (resourcelock
 		return nil, err
 	}
 
-	return resourcelock.New(resourcelock.ConfigMapsResourceLock,
+	return resourcelock.New(cfg.LockType,
 		leaderElectionNamespace,
-		leaderElectionID,
+		cfg.LeaderElectionID,
 		client.CoreV1(),
 		client.CoordinationV1(),
 		resourcelock.ResourceLockConfig{
@@ -64,12 +107,42 @@ func newResourceLock(config *rest.Config, leaderElectionID string) (resourcelock
 		})
 }
 
+// handoffLock records when the lock was last renewed or released by another replica, or when this replica stopped
+// leading. When this replica takes over, the events which happened before that were exported by the previous leader.
+type handoffLock struct {
+	resourcelock.Interface
+
+	mu      sync.Mutex
+	handoff time.Time
+}
+
+func (l *handoffLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	record, raw, err := l.Interface.Get(ctx)
+	if err == nil && record.HolderIdentity != l.Identity() {
+		l.setHandoff(record.RenewTime.Time)
+	}
+	return record, raw, err
+}
+
+func (l *handoffLock) setHandoff(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handoff = t
+}
+
+// Handoff returns when the previous leader stopped renewing the lock, it is zero if no replica held the lock yet
+func (l *handoffLock) Handoff() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.handoff
+}
+
 func getInClusterNamespace() (string, error) {
 	// Check whether the namespace file exists.
 	// If not, we are not running in cluster so can't guess the namespace.
 	_, err := os.Stat(inClusterNamespacePath)
 	if os.IsNotExist(err) {
-		return "", fmt.Errorf("not running in-cluster, please specify leaderElectionIDspace")
+		return "", fmt.Errorf("not running in-cluster, please specify leaderElection.namespace")
 	} else if err != nil {
 		return "", fmt.Errorf("error checking namespace file: %w", err)
 	}
@@ -79,25 +152,63 @@ func getInClusterNamespace() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("error reading namespace file: %w", err)
 	}
-	return string(namespace), nil
+	return strings.TrimSpace(string(namespace)), nil
 }
 
-// NewLeaderElector return  a leader elector object using client-go
-func NewLeaderElector(leaderElectionID string, config *rest.Config, startFunc func(context.Context), stopFunc func()) (*leaderelection.LeaderElector, error) {
-	resourceLock, err := newResourceLock(config, leaderElectionID)
+// NewLeaderElector return  a leader elector object using client-go. The startFunc gets the last renewal of the previous
+// leader, the events which happened before it were already exported.
+func NewLeaderElector(cfg LeaderElectionConfig, config *rest.Config, startFunc func(ctx context.Context, since time.Time), stopFunc func()) (*leaderelection.LeaderElector, error) {
+	cfg.setDefaults()
+
+	lock, err := newResourceLock(config, &cfg)
 	if err != nil {
 		return &leaderelection.LeaderElector{}, err
 	}
+	resourceLock := &handoffLock{Interface: lock}
+
+	id := resourceLock.Identity()
+	log.Info().
+		Str("identity", id).
+		Str("lock", resourceLock.Describe()).
+		Str("type", cfg.LockType).
+		Msg("Starting leader election")
 
 	l, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
 		Lock:          resourceLock,
-		LeaseDuration: defaultLeaseDuration,
-		RenewDeadline: defaultRenewDeadline,
-		RetryPeriod:   defaultRetryPeriod,
+		LeaseDuration: cfg.LeaseDuration,
+		RenewDeadline: cfg.RenewDeadline,
+		RetryPeriod:   cfg.RetryPeriod,
+		// The lock is released on shutdown so that a standby replica can take over without waiting for the lease
+		ReleaseOnCancel: true,
 		Callbacks: leaderelection.LeaderCallbacks{
-			OnStartedLeading: startFunc,
-			OnStoppedLeading: stopFunc,
+			OnStartedLeading: func(ctx context.Context) {
+				metrics.IsLeader.Set(1)
+				log.Info().Str("identity", id).Msg("Started leading")
+				startFunc(ctx, resourceLock.Handoff())
+			},
+			OnStoppedLeading: func() {
+				metrics.IsLeader.Set(0)
+				log.Warn().Str("identity", id).Msg("Stopped leading")
+				stopFunc()
+				resourceLock.setHandoff(time.Now())
+			},
+			OnNewLeader: func(identity string) {
+				metrics.LeaderTransitions.Inc()
+				log.Info().Str("leader", identity).Bool("self", identity == id).Msg("New leader observed")
+			},
 		},
 	})
 	return l, err
 }
+
+// RunLeaderElection participates in the leader election until the context is cancelled. Losing the leadership does not
+// end it, the replica becomes a standby and tries to acquire the lock again.
+func RunLeaderElection(ctx context.Context, l *leaderelection.LeaderElector) {
+	for {
+		l.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Info().Msg("Waiting to acquire the leadership again")
+	}
+}
@@ -0,0 +1,52 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// recordLock is a lock that returns a fixed record
+type recordLock struct {
+	resourcelock.Interface
+	record resourcelock.LeaderElectionRecord
+}
+
+func (l *recordLock) Get(context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	record := l.record
+	return &record, nil, nil
+}
+
+func (l *recordLock) Identity() string {
+	return "exporter-1"
+}
+
+func TestHandoffLock(t *testing.T) {
+	lock := &recordLock{}
+	l := &handoffLock{Interface: lock}
+	assert.True(t, l.Handoff().IsZero())
+
+	renewed := time.Date(2021, 11, 5, 10, 0, 0, 0, time.UTC)
+	lock.record = resourcelock.LeaderElectionRecord{HolderIdentity: "exporter-0", RenewTime: metav1.NewTime(renewed)}
+	_, _, err := l.Get(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, renewed, l.Handoff())
+
+	// The renewals of this replica are not a handoff
+	lock.record = resourcelock.LeaderElectionRecord{HolderIdentity: "exporter-1", RenewTime: metav1.NewTime(renewed.Add(time.Minute))}
+	_, _, err = l.Get(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, renewed, l.Handoff())
+
+	// A released lock has no holder
+	released := renewed.Add(2 * time.Minute)
+	lock.record = resourcelock.LeaderElectionRecord{RenewTime: metav1.NewTime(released)}
+	_, _, err = l.Get(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, released, l.Handoff())
+}
@@ -0,0 +1,612 @@
+package kube
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/rs/zerolog/log"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// ShardingConfig allows running many active replicas, each exporting the events of its share of the namespaces or
+// the involved objects. The members are discovered from their Leases.
+type ShardingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Key is either "namespace" or "uid" (the UID of the involved object), defaults to namespace
+	Key string `yaml:"key"`
+	// Name of the group of replicas sharing the events, it prefixes the Lease names
+	Name string `yaml:"name"`
+	// Namespace of the Leases, defaults to the namespace the exporter runs in
+	Namespace string `yaml:"namespace"`
+	// Identity must be unique for each replica and a valid Kubernetes name, defaults to the hostname
+	Identity      string        `yaml:"identity"`
+	LeaseDuration time.Duration `yaml:"leaseDuration"`
+	RenewPeriod   time.Duration `yaml:"renewPeriod"`
+}
+
+const (
+	ShardKeyNamespace = "namespace"
+	ShardKeyUID       = "uid"
+
+	shardGroupLabel = "kubernetes-event-exporter/shard-group"
+	// The ring of a replica and the times it switched to it and to the ring before are published in its Lease
+	shardRingAnnotation     = "kubernetes-event-exporter/ring"
+	shardSinceAnnotation    = "kubernetes-event-exporter/since"
+	shardPreviousAnnotation = "kubernetes-event-exporter/previous-since"
+
+	defaultShardName     = "kubernetes-event-exporter"
+	defaultShardDuration = 15 * time.Second
+	defaultShardRenew    = 5 * time.Second
+	// virtualNodes is the number of points each member has on the ring, more points give a more even distribution
+	virtualNodes = 128
+)
+
+func (c *ShardingConfig) setDefaults() {
+	if c.Key == "" {
+		c.Key = ShardKeyNamespace
+	}
+	if c.Name == "" {
+		c.Name = defaultShardName
+	}
+	if c.LeaseDuration == 0 {
+		c.LeaseDuration = defaultShardDuration
+	}
+	if c.RenewPeriod == 0 {
+		c.RenewPeriod = defaultShardRenew
+	}
+}
+
+func (c *ShardingConfig) Validate() error {
+	if c.Key != ShardKeyNamespace && c.Key != ShardKeyUID {
+		return fmt.Errorf("unknown sharding key %q, use %q or %q", c.Key, ShardKeyNamespace, ShardKeyUID)
+	}
+	if c.RenewPeriod >= c.LeaseDuration {
+		return fmt.Errorf("sharding renewPeriod (%s) must be less than leaseDuration (%s)", c.RenewPeriod, c.LeaseDuration)
+	}
+	return nil
+}
+
+// Sharder keeps the Lease of this replica, watches the Leases of the others and decides which events belong to this
+// replica using a consistent hash ring, so that only a small share of the keys move when replicas join or leave.
+//
+// The replicas do not see the members change at the same time, so each one publishes its ring in the annotations of its
+// Lease. A replica keeps exporting the keys it loses until it switches to the new ring, and the replica gaining them
+// waits until the switch is published. The time of the switch is the high-water mark of the keys: the replica gaining
+// them replays only the events which happened since, so that the events are neither lost nor exported twice.
+type Sharder struct {
+	cfg       ShardingConfig
+	client    kubernetes.Interface
+	namespace string
+	identity  string
+
+	mu        sync.RWMutex
+	view      *shardView
+	since     time.Time
+	previous  time.Time
+	lastRenew time.Time
+	// observed are the Leases of the group on the last sync and rings are the rings built from them, by their members
+	observed    map[string]shardLease
+	rings       map[string]*hashRing
+	onRebalance func(gained EventFilter, since time.Time)
+	done        chan struct{}
+}
+
+func NewSharder(cfg ShardingConfig, config *rest.Config) (*Sharder, error) {
+	cfg.setDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace, err = getInClusterNamespace()
+		if err != nil {
+			log.Warn().Err(err).Str("namespace", defaultNamespace).Msg("Using the default namespace for sharding")
+			namespace = defaultNamespace
+		}
+	}
+
+	identity := cfg.Identity
+	if identity == "" {
+		identity, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newSharder(cfg, client, namespace, strings.ToLower(identity)), nil
+}
+
+func newSharder(cfg ShardingConfig, client kubernetes.Interface, namespace, identity string) *Sharder {
+	return &Sharder{
+		cfg:       cfg,
+		client:    client,
+		namespace: namespace,
+		identity:  identity,
+		view:      &shardView{identity: identity, ring: newHashRing(nil)},
+		since:     time.Now(),
+		observed:  make(map[string]shardLease),
+		rings:     make(map[string]*hashRing),
+		done:      make(chan struct{}),
+	}
+}
+
+// OnRebalance sets a function that is called with the keys this replica gained when the members change, and the time
+// the previous owner of the keys stopped exporting them
+func (s *Sharder) OnRebalance(fn func(gained EventFilter, since time.Time)) {
+	s.onRebalance = fn
+}
+
+// Owns is an EventFilter that accepts the events belonging to this replica
+func (s *Sharder) Owns(namespace string, uid types.UID) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// If the Lease could not be renewed for a while the others consider this replica gone and take over its share,
+	// it is better to stop than export the same events twice.
+	if time.Since(s.lastRenew) > s.cfg.LeaseDuration {
+		return false
+	}
+	return s.view.owns(s.key(namespace, uid))
+}
+
+func (s *Sharder) key(namespace string, uid types.UID) string {
+	if s.cfg.Key == ShardKeyUID {
+		return string(uid)
+	}
+	return namespace
+}
+
+// Start joins the group and keeps the Lease renewed until the context is cancelled. The first renewal is done before
+// returning, so that the replica knows its share before the events are watched.
+func (s *Sharder) Start(ctx context.Context) error {
+	if err := s.sync(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.cfg.RenewPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.leave()
+				return
+			case <-ticker.C:
+				if err := s.sync(ctx); err != nil {
+					log.Error().Err(err).Msg("Cannot sync the shard members")
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Check returns an error when the lease of this replica is not renewed in time, the replica does not own any keys then
+func (s *Sharder) Check() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if since := time.Since(s.lastRenew); since > s.cfg.LeaseDuration {
+		return fmt.Errorf("shard lease is not renewed for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+// Done is closed when the replica left the group after the context is cancelled
+func (s *Sharder) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Sharder) leaseName() string {
+	return s.cfg.Name + "-" + s.identity
+}
+
+func (s *Sharder) sync(ctx context.Context) error {
+	if err := s.renew(ctx); err != nil {
+		return err
+	}
+
+	leases, err := s.leases(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var members []string
+	for _, l := range leases {
+		if l.alive {
+			members = append(members, l.identity)
+		}
+	}
+	sort.Strings(members)
+
+	rings := make(map[string]*hashRing)
+	ringOf := func(members []string) *hashRing {
+		key := strings.Join(members, ",")
+		r, ok := rings[key]
+		if !ok {
+			if r, ok = s.rings[key]; !ok {
+				r = newHashRing(members)
+			}
+			rings[key] = r
+		}
+		return r
+	}
+
+	s.mu.Lock()
+	previous := s.view
+	changed := !equalMembers(previous.ring.members, members)
+	view := &shardView{identity: s.identity, ring: previous.ring}
+	rings[strings.Join(previous.ring.members, ",")] = previous.ring
+	if changed {
+		view.ring = ringOf(members)
+		s.previous, s.since = s.since, now
+	}
+	for _, m := range members {
+		if m == s.identity {
+			continue
+		}
+		view.add(m, ringOf(leases[m].ring))
+	}
+	s.view = view
+	s.lastRenew = now
+	s.mu.Unlock()
+
+	observed := s.observed
+	s.observed = leases
+	s.rings = rings
+
+	if changed {
+		metrics.ShardMembers.Set(float64(len(members)))
+		metrics.ShardRebalances.Inc()
+		log.Info().Strs("members", members).Str("identity", s.identity).Msg("Shard members changed")
+	}
+
+	if s.onRebalance == nil || view.equal(previous) {
+		return nil
+	}
+	for from, since := range handoffs(previous, observed, leases) {
+		from := from
+		s.onRebalance(func(namespace string, uid types.UID) bool {
+			key := s.key(namespace, uid)
+			if !view.owns(key) || previous.owns(key) {
+				return false
+			}
+			exporter, claimed := previous.exporter(key)
+			return shardHandoff{exporter, claimed} == from
+		}, since)
+	}
+	return nil
+}
+
+func (s *Sharder) renew(ctx context.Context) error {
+	leases := s.client.CoordinationV1().Leases(s.namespace)
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(s.cfg.LeaseDuration.Seconds())
+
+	s.mu.RLock()
+	annotations := map[string]string{
+		shardRingAnnotation:     strings.Join(s.view.ring.members, ","),
+		shardSinceAnnotation:    s.since.Format(time.RFC3339Nano),
+		shardPreviousAnnotation: s.previous.Format(time.RFC3339Nano),
+	}
+	s.mu.RUnlock()
+
+	lease, err := leases.Get(ctx, s.leaseName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        s.leaseName(),
+				Namespace:   s.namespace,
+				Labels:      map[string]string{shardGroupLabel: s.cfg.Name},
+				Annotations: annotations,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string)
+	}
+	for k, v := range annotations {
+		lease.Annotations[k] = v
+	}
+	if lease.Spec.HolderIdentity == nil {
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = &s.identity
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// leases returns the state of the replicas of the group by their identity. The Leases of the replicas which are gone
+// for a while are deleted, they are kept until then so that the others see when the replicas stopped.
+func (s *Sharder) leases(ctx context.Context) (map[string]shardLease, error) {
+	client := s.client.CoordinationV1().Leases(s.namespace)
+	list, err := client.List(ctx, metav1.ListOptions{
+		LabelSelector: shardGroupLabel + "=" + s.cfg.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	leases := make(map[string]shardLease, len(list.Items))
+	for i := range list.Items {
+		lease := &list.Items[i]
+		l := parseShardLease(lease, s.cfg.Name, now)
+		if l.identity != s.identity && now.Sub(l.renewed) > 2*s.cfg.LeaseDuration {
+			err := client.Delete(ctx, lease.Name, metav1.DeleteOptions{
+				Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+			})
+			if err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+				log.Warn().Err(err).Str("lease", lease.Name).Msg("Cannot delete the shard lease of a replica that is gone")
+			}
+		}
+		leases[l.identity] = l
+	}
+	return leases, nil
+}
+
+// leave releases the Lease so that the others take over the share of this replica without waiting for it to expire,
+// and publishes when this replica stopped exporting
+func (s *Sharder) leave() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.RenewPeriod)
+	defer cancel()
+
+	err := func() error {
+		leases := s.client.CoordinationV1().Leases(s.namespace)
+		lease, err := leases.Get(ctx, s.leaseName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		s.mu.RLock()
+		previous := s.since
+		s.mu.RUnlock()
+		if lease.Annotations == nil {
+			lease.Annotations = make(map[string]string)
+		}
+		delete(lease.Annotations, shardRingAnnotation)
+		lease.Annotations[shardSinceAnnotation] = now.Format(time.RFC3339Nano)
+		lease.Annotations[shardPreviousAnnotation] = previous.Format(time.RFC3339Nano)
+		lease.Spec.HolderIdentity = nil
+		lease.Spec.RenewTime = &metav1.MicroTime{Time: now}
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		return err
+	}()
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error().Err(err).Msg("Cannot release the shard lease")
+		return
+	}
+	log.Info().Str("identity", s.identity).Msg("Left the shard group")
+}
+
+// shardLease is the state of a replica, as published in its Lease
+type shardLease struct {
+	identity string
+	// alive is false when the replica left or its Lease expired
+	alive bool
+	// ring are the members of the ring of the replica, it is empty when the replica left
+	ring []string
+	// since is when the replica switched to its ring or left, previous is when it switched to the ring before
+	since    time.Time
+	previous time.Time
+	renewed  time.Time
+}
+
+func parseShardLease(lease *coordinationv1.Lease, name string, now time.Time) shardLease {
+	l := shardLease{identity: strings.TrimPrefix(lease.Name, name+"-")}
+	spec := lease.Spec
+	if spec.RenewTime != nil {
+		l.renewed = spec.RenewTime.Time
+	}
+	if spec.HolderIdentity != nil && spec.RenewTime != nil && spec.LeaseDurationSeconds != nil {
+		expiry := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+		l.alive = !now.After(expiry)
+	}
+
+	if ring := lease.Annotations[shardRingAnnotation]; ring != "" {
+		l.ring = strings.Split(ring, ",")
+	}
+	// The times are zero when they are not published
+	l.since, _ = time.Parse(time.RFC3339Nano, lease.Annotations[shardSinceAnnotation])
+	l.previous, _ = time.Parse(time.RFC3339Nano, lease.Annotations[shardPreviousAnnotation])
+	return l
+}
+
+// shardHandoff identifies the keys this replica gains from another one: the keys that replica still exported on the
+// previous sync, or the keys it already stopped exporting but nobody took over yet
+type shardHandoff struct {
+	from    string
+	claimed bool
+}
+
+// handoffs returns when the previous exporters stopped exporting the keys this replica gains, from the Leases of the
+// previous and of the current sync. When it is not known exactly, the earliest possible time is used, which might
+// export a few events twice but does not lose any.
+func handoffs(previous *shardView, observed, current map[string]shardLease) map[shardHandoff]time.Time {
+	handoffs := make(map[shardHandoff]time.Time)
+	if len(previous.ring.members) == 0 {
+		// The first replica of the group does not take over from anyone
+		handoffs[shardHandoff{}] = time.Time{}
+	}
+
+	for _, m := range previous.ring.members {
+		if m != previous.identity {
+			// The replica already stopped exporting the keys on the previous sync, most likely with its last switch
+			handoffs[shardHandoff{m, false}] = observed[m].since
+		}
+	}
+	for _, m := range previous.names {
+		prev, cur := observed[m], current[m]
+		var since time.Time
+		switch {
+		case cur.identity == "":
+			// The Lease is gone, the replica was exporting at least until its renewal seen on the previous sync
+			since = prev.renewed
+		case cur.since.Equal(prev.since):
+			// The replica did not switch, it was exporting the keys until its Lease expired
+			since = cur.renewed
+		case cur.previous.Equal(prev.since):
+			// The replica switched once, that is when it stopped exporting the keys
+			since = cur.since
+		default:
+			// The replica switched more than once, the keys might have been dropped by any of the switches
+			since = prev.renewed
+		}
+		handoffs[shardHandoff{m, true}] = since
+	}
+	return handoffs
+}
+
+// shardView is the ring of this replica and the rings of the other live replicas
+type shardView struct {
+	identity string
+	ring     *hashRing
+	// names are the sorted identities of the other replicas and rings are their rings
+	names []string
+	rings map[string]*hashRing
+}
+
+func (v *shardView) add(identity string, ring *hashRing) {
+	if v.rings == nil {
+		v.rings = make(map[string]*hashRing)
+	}
+	v.names = append(v.names, identity)
+	v.rings[identity] = ring
+}
+
+// claimant returns the other replica which still owns the key on its own ring
+func (v *shardView) claimant(h uint64) string {
+	for _, m := range v.names {
+		if v.rings[m].ownerOf(h) == m {
+			return m
+		}
+	}
+	return ""
+}
+
+// owns returns whether the key belongs to this replica on its ring, and no other replica still owns it on its own
+func (v *shardView) owns(key string) bool {
+	h := hashKey(key)
+	return v.ring.ownerOf(h) == v.identity && v.claimant(h) == ""
+}
+
+// exporter returns the replica that exports the key and true, or the owner of the key on the ring of this replica and
+// false when no replica exports it
+func (v *shardView) exporter(key string) (string, bool) {
+	h := hashKey(key)
+	if m := v.claimant(h); m != "" {
+		return m, true
+	}
+	if m := v.ring.ownerOf(h); m != v.identity {
+		return m, false
+	}
+	return v.identity, true
+}
+
+// equal returns whether the views assign the keys the same way, the rings are shared by the views of the same sync
+// and cached across the syncs
+func (v *shardView) equal(o *shardView) bool {
+	if v.ring != o.ring || len(v.names) != len(o.names) {
+		return false
+	}
+	for _, m := range v.names {
+		if v.rings[m] != o.rings[m] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type ringPoint struct {
+	hash   uint64
+	member string
+}
+
+// hashRing is a consistent hash ring, each member is placed on the ring many times and a key belongs to the first
+// member after its hash.
+type hashRing struct {
+	members []string
+	points  []ringPoint
+}
+
+func newHashRing(members []string) *hashRing {
+	r := &hashRing{members: members}
+	for _, m := range members {
+		for i := 0; i < virtualNodes; i++ {
+			r.points = append(r.points, ringPoint{hash: hashKey(fmt.Sprintf("%s#%d", m, i)), member: m})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+func (r *hashRing) owner(key string) string {
+	return r.ownerOf(hashKey(key))
+}
+
+func (r *hashRing) ownerOf(h uint64) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].member
+}
+
+func hashKey(key string) uint64 {
+	sum := sha1.Sum([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
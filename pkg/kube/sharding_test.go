@@ -0,0 +1,190 @@
+package kube
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHashRing_Empty(t *testing.T) {
+	r := newHashRing(nil)
+	assert.Equal(t, "", r.owner("default"))
+}
+
+func TestHashRing_SingleMember(t *testing.T) {
+	r := newHashRing([]string{"exporter-0"})
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "exporter-0", r.owner(fmt.Sprintf("namespace-%d", i)))
+	}
+}
+
+func TestHashRing_Distribution(t *testing.T) {
+	members := []string{"exporter-0", "exporter-1", "exporter-2"}
+	r := newHashRing(members)
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[r.owner(fmt.Sprintf("namespace-%d", i))]++
+	}
+
+	assert.Len(t, counts, 3)
+	for _, m := range members {
+		// Each member should get roughly a third of the keys
+		assert.Greater(t, counts[m], 700, m)
+	}
+}
+
+func TestHashRing_MinimalMovement(t *testing.T) {
+	before := newHashRing([]string{"exporter-0", "exporter-1", "exporter-2"})
+	after := newHashRing([]string{"exporter-0", "exporter-1", "exporter-2", "exporter-3"})
+
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("namespace-%d", i)
+		if before.owner(key) != after.owner(key) {
+			// Keys only move to the new member
+			assert.Equal(t, "exporter-3", after.owner(key))
+			moved++
+		}
+	}
+
+	assert.Greater(t, moved, 0)
+	assert.Less(t, moved, 1200)
+}
+
+func TestShardingConfig_Validate(t *testing.T) {
+	cfg := ShardingConfig{}
+	cfg.setDefaults()
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Key = "reason"
+	assert.Error(t, cfg.Validate())
+
+	cfg.Key = ShardKeyUID
+	cfg.RenewPeriod = cfg.LeaseDuration
+	assert.Error(t, cfg.Validate())
+}
+
+func TestShardView_Handover(t *testing.T) {
+	ab := newHashRing([]string{"exporter-0", "exporter-1"})
+	abc := newHashRing([]string{"exporter-0", "exporter-1", "exporter-2"})
+
+	// exporter-2 joins, the others still use their ring without it
+	joined := &shardView{identity: "exporter-2", ring: abc}
+	joined.add("exporter-0", ab)
+	joined.add("exporter-1", ab)
+	// exporter-0 switched to the new ring, exporter-1 did not yet
+	switched := &shardView{identity: "exporter-2", ring: abc}
+	switched.add("exporter-0", abc)
+	switched.add("exporter-1", ab)
+
+	gained := 0
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("namespace-%d", i)
+		// The new replica waits until the previous owners stop exporting the keys
+		assert.False(t, joined.owns(key), key)
+
+		previous := ab.owner(key)
+		exporter, claimed := joined.exporter(key)
+		assert.Equal(t, previous, exporter)
+		assert.True(t, claimed)
+
+		if abc.owner(key) != "exporter-2" {
+			assert.False(t, switched.owns(key), key)
+			continue
+		}
+		assert.Equal(t, previous == "exporter-0", switched.owns(key), key)
+		if switched.owns(key) {
+			gained++
+		}
+	}
+	assert.Greater(t, gained, 0)
+	assert.False(t, switched.equal(joined))
+	assert.True(t, joined.equal(joined))
+}
+
+func TestShardHandoffs(t *testing.T) {
+	t0 := time.Date(2021, 11, 5, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return t0.Add(time.Duration(seconds) * time.Second)
+	}
+
+	previous := &shardView{identity: "exporter-0", ring: newHashRing([]string{"exporter-0", "exporter-1"})}
+	for _, m := range []string{"exporter-1", "exporter-2", "exporter-3", "exporter-4"} {
+		previous.add(m, previous.ring)
+	}
+	observed := map[string]shardLease{
+		"exporter-1": {identity: "exporter-1", alive: true, since: at(0), renewed: at(10)},
+		"exporter-2": {identity: "exporter-2", alive: true, since: at(0), renewed: at(10)},
+		"exporter-3": {identity: "exporter-3", alive: true, since: at(0), renewed: at(10)},
+		"exporter-4": {identity: "exporter-4", alive: true, since: at(0), renewed: at(10)},
+	}
+	current := map[string]shardLease{
+		// It switched once
+		"exporter-1": {identity: "exporter-1", alive: true, since: at(12), previous: at(0), renewed: at(15)},
+		// It left
+		"exporter-2": {identity: "exporter-2", since: at(13), previous: at(0), renewed: at(13)},
+		// Its Lease expired
+		"exporter-3": {identity: "exporter-3", since: at(0), renewed: at(11)},
+		// It switched twice since the previous sync
+		"exporter-4": {identity: "exporter-4", alive: true, since: at(14), previous: at(12), renewed: at(15)},
+	}
+
+	assert.Equal(t, map[shardHandoff]time.Time{
+		{"exporter-1", false}: at(0),
+		{"exporter-1", true}:  at(12),
+		{"exporter-2", true}:  at(13),
+		{"exporter-3", true}:  at(11),
+		{"exporter-4", true}:  at(10),
+	}, handoffs(previous, observed, current))
+
+	// The Lease is gone
+	delete(current, "exporter-3")
+	assert.Equal(t, at(10), handoffs(previous, observed, current)[shardHandoff{"exporter-3", true}])
+
+	// The first replica does not take over from anyone
+	first := &shardView{identity: "exporter-0", ring: newHashRing(nil)}
+	assert.Equal(t, map[shardHandoff]time.Time{{}: {}}, handoffs(first, nil, nil))
+}
+
+func TestParseShardLease(t *testing.T) {
+	now := time.Date(2021, 11, 5, 10, 0, 0, 0, time.UTC)
+	identity := "exporter-1"
+	duration := int32(15)
+	renewed := metav1.NewMicroTime(now.Add(-10 * time.Second))
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "kubernetes-event-exporter-exporter-1",
+			Annotations: map[string]string{
+				shardRingAnnotation:     "exporter-0,exporter-1",
+				shardSinceAnnotation:    now.Add(-time.Minute).Format(time.RFC3339Nano),
+				shardPreviousAnnotation: now.Add(-time.Hour).Format(time.RFC3339Nano),
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{HolderIdentity: &identity, LeaseDurationSeconds: &duration, RenewTime: &renewed},
+	}
+
+	l := parseShardLease(lease, defaultShardName, now)
+	assert.Equal(t, shardLease{
+		identity: "exporter-1",
+		alive:    true,
+		ring:     []string{"exporter-0", "exporter-1"},
+		since:    now.Add(-time.Minute),
+		previous: now.Add(-time.Hour),
+		renewed:  renewed.Time,
+	}, l)
+
+	// The Lease expired
+	assert.False(t, parseShardLease(lease, defaultShardName, now.Add(time.Minute)).alive)
+
+	// The replica left
+	lease.Spec.HolderIdentity = nil
+	delete(lease.Annotations, shardRingAnnotation)
+	l = parseShardLease(lease, defaultShardName, now)
+	assert.False(t, l.alive)
+	assert.Empty(t, l.ring)
+}
@@ -1,10 +1,19 @@
 package kube
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/tracing"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -13,6 +22,10 @@ import (
 
 type EventHandler func(event *EnhancedEvent)
 
+// EventFilter decides whether an event belongs to this watcher by the namespace of the event and the UID of its involved
+// object. It is checked before the event is enhanced, so that the lookups are not wasted on the filtered events.
+type EventFilter func(namespace string, uid types.UID) bool
+
 type EventWatcher struct {
 	informer        cache.SharedInformer
 	stopper         chan struct{}
@@ -20,8 +33,23 @@ type EventWatcher struct {
 	annotationCache *AnnotationCache
 	fn              EventHandler
 	throttlePeriod  time.Duration
+	filter          EventFilter
+	// active is set while the events are passed to the handler. The informer keeps running when the watcher is
+	// stopped, so that a standby replica has warm caches when it takes over.
+	active    int32
+	runOnce   sync.Once
+	closeOnce sync.Once
+
+	// watchMu guards the state of the failing watch, see CheckWatch
+	watchMu    sync.Mutex
+	watchErr   error
+	watchErrAt time.Time
+	watchErrRV string
 }
 
+// watchStallTimeout is how long the watch can keep failing before the watcher is reported as unhealthy
+const watchStallTimeout = 2 * time.Minute
+
 func NewEventWatcher(config *rest.Config, namespace string, throttlePeriod int64, fn EventHandler) *EventWatcher {
 	clientset := kubernetes.NewForConfigOrDie(config)
 	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace))
@@ -37,24 +65,86 @@ func NewEventWatcher(config *rest.Config, namespace string, throttlePeriod int64
 	}
 
 	informer.AddEventHandler(watcher)
+	// It can only fail after the informer is started
+	_ = informer.SetWatchErrorHandler(watcher.onWatchError)
 
 	return watcher
 }
 
 func (e *EventWatcher) OnAdd(obj interface{}) {
 	event := obj.(*corev1.Event)
+	e.watchRecovered()
 	e.onEvent(event)
 }
 
 func (e *EventWatcher) OnUpdate(oldObj, newObj interface{}) {
 	event := newObj.(*corev1.Event)
+	e.watchRecovered()
 	e.onEvent(event)
 }
 
+func (e *EventWatcher) onWatchError(r *cache.Reflector, err error) {
+	cache.DefaultWatchErrorHandler(r, err)
+
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+	if e.watchErrAt.IsZero() {
+		e.watchErrAt = time.Now()
+		e.watchErrRV = e.informer.LastSyncResourceVersion()
+	}
+	e.watchErr = err
+}
+
+func (e *EventWatcher) watchRecovered() {
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+	e.watchErr = nil
+	e.watchErrAt = time.Time{}
+}
+
+// CheckSynced returns an error until the informer has listed the events
+func (e *EventWatcher) CheckSynced() error {
+	if !e.informer.HasSynced() {
+		return errors.New("event informer is not synced")
+	}
+	return nil
+}
+
+// CheckWatch returns an error when the watch of the events keeps failing. A quiet cluster might not have any events
+// for a long time, so the watch is considered recovered when an event is received or the informer lists again.
+func (e *EventWatcher) CheckWatch() error {
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+
+	if e.watchErrAt.IsZero() {
+		return nil
+	}
+	if e.informer.LastSyncResourceVersion() != e.watchErrRV {
+		e.watchErr = nil
+		e.watchErrAt = time.Time{}
+		return nil
+	}
+	if since := time.Since(e.watchErrAt); since > watchStallTimeout {
+		return fmt.Errorf("watch is failing for %s: %v", since.Round(time.Second), e.watchErr)
+	}
+	return nil
+}
+
 func (e *EventWatcher) onEvent(event *corev1.Event) {
+	if atomic.LoadInt32(&e.active) == 0 {
+		return
+	}
+	metrics.EventsReceived.WithLabelValues("event").Inc()
+
+	if e.filter != nil && !e.filter(event.Namespace, event.InvolvedObject.UID) {
+		metrics.EventsDiscarded.WithLabelValues("not_owned").Inc()
+		return
+	}
+
 	// TODO: Re-enable this after development
 	// It's probably an old event we are catching, it's not the best way but anyways
 	if time.Since(event.LastTimestamp.Time) > e.throttlePeriod {
+		metrics.EventsDiscarded.WithLabelValues("too_old").Inc()
 		return
 	}
 
@@ -70,8 +160,16 @@ func (e *EventWatcher) onEvent(event *corev1.Event) {
 	}
 	ev.Event.ManagedFields = nil
 
+	// The span of the event covers the enrichment and the routing, the sends are its children
+	ctx, span := tracing.Tracer().Start(context.Background(), "EventWatcher.onEvent",
+		trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(ev.TraceAttributes()...))
+	defer span.End()
+	ev.SetContext(ctx)
+
+	_, enrichSpan := tracing.Tracer().Start(ctx, "EventWatcher.enrich")
 	labels, err := e.labelCache.GetLabelsWithCache(&event.InvolvedObject)
 	if err != nil {
+		enrichSpan.RecordError(err)
 		log.Error().Err(err).Msg("Cannot list labels of the object")
 		// Ignoring error, but log it anyways
 	} else {
@@ -81,11 +179,13 @@ func (e *EventWatcher) onEvent(event *corev1.Event) {
 
 	annotations, err := e.annotationCache.GetAnnotationsWithCache(&event.InvolvedObject)
 	if err != nil {
+		enrichSpan.RecordError(err)
 		log.Error().Err(err).Msg("Cannot list annotations of the object")
 	} else {
 		ev.InvolvedObject.Annotations = annotations
 		ev.InvolvedObject.ObjectReference = *event.InvolvedObject.DeepCopy()
 	}
+	enrichSpan.End()
 
 	e.fn(ev)
 	return
@@ -95,11 +195,60 @@ func (e *EventWatcher) OnDelete(obj interface{}) {
 	// Ignore deletes
 }
 
-func (e *EventWatcher) Start() {
-	go e.informer.Run(e.stopper)
+// Run starts the informer without passing the events to the handler, it is safe to call it more than once
+func (e *EventWatcher) Run() {
+	e.runOnce.Do(func() {
+		go e.informer.Run(e.stopper)
+	})
+}
+
+// Start passes the events to the handler, starting the informer if required. It can be called again after Stop; the
+// cached events which happened at or after since are replayed if they are within the throttle period, a zero since
+// replays all of them.
+func (e *EventWatcher) Start(since time.Time) {
+	e.Run()
+	if !atomic.CompareAndSwapInt32(&e.active, 0, 1) {
+		return
+	}
+
+	e.Replay(nil, since)
 }
 
+// Replay passes the cached events matching the filter which happened at or after since to the handler again, if they
+// are within the throttle period. Until the informer is synced, the events are passed to the handler as they are added
+// to the cache anyway.
+func (e *EventWatcher) Replay(filter EventFilter, since time.Time) {
+	if !e.informer.HasSynced() {
+		return
+	}
+
+	// The timestamps of the events have a precision of a second
+	since = since.Truncate(time.Second)
+	for _, obj := range e.informer.GetStore().List() {
+		event, ok := obj.(*corev1.Event)
+		if !ok || event.LastTimestamp.Time.Before(since) {
+			continue
+		}
+		if filter == nil || filter(event.Namespace, event.InvolvedObject.UID) {
+			e.onEvent(event)
+		}
+	}
+}
+
+// SetFilter sets the filter for the events, it should be called before the watcher is started
+func (e *EventWatcher) SetFilter(filter EventFilter) {
+	e.filter = filter
+}
+
+// Stop stops passing the events to the handler, the informer keeps running until Close is called
 func (e *EventWatcher) Stop() {
-	e.stopper <- struct{}{}
-	close(e.stopper)
+	atomic.StoreInt32(&e.active, 0)
+}
+
+// Close stops the watcher and the informer, the watcher cannot be started again
+func (e *EventWatcher) Close() {
+	e.Stop()
+	e.closeOnce.Do(func() {
+		close(e.stopper)
+	})
 }
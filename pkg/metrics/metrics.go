@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "event_exporter"
+
+// Prefix is the prefix of the names of the metrics of the exporter
+const Prefix = namespace + "_"
+
+var (
+	// IsLeader is 1 when this replica holds the leader election lock
+	IsLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader_election_is_leader",
+		Help:      "Whether this replica is the leader (1) or not (0)",
+	})
+
+	// LeaderTransitions counts the leadership changes observed by this replica, the identity of the new leader is
+	// not used as a label to keep the cardinality low
+	LeaderTransitions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "leader_election_transitions_total",
+		Help:      "Number of leader changes observed by this replica",
+	})
+
+	// ShardMembers is the number of replicas sharing the events when sharding is enabled
+	ShardMembers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "shard_members",
+		Help:      "Number of live replicas in the shard group",
+	})
+
+	// ShardRebalances counts the changes of the shard members
+	ShardRebalances = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shard_rebalances_total",
+		Help:      "Number of times the shards are rebalanced because the members changed",
+	})
+
+	// EventsReceived counts the events passed by the watchers, the source is either "event" or "deletion"
+	EventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Number of events received from the watchers",
+	}, []string{"source"})
+
+	// EventsDiscarded counts the events that are not processed by this replica, the reason is "too_old" for the events
+	// older than the throttle period and "not_owned" for the events of the other shards
+	EventsDiscarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_discarded_total",
+		Help:      "Number of events discarded before routing",
+	}, []string{"reason"})
+
+	// RouteDropped counts the events dropped by the drop rules of a route. The route is identified by its path in the
+	// route tree, e.g. "0.1" is the second sub-route of the first sub-route of the root route
+	RouteDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "route_events_dropped_total",
+		Help:      "Number of events dropped by the drop rules of a route",
+	}, []string{"route"})
+
+	// RouteMatched counts the events matched by the rules of a route with a receiver
+	RouteMatched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "route_events_matched_total",
+		Help:      "Number of events matched by the rules of a route and sent to the receiver",
+	}, []string{"route", "receiver"})
+
+	// ReceiverSent counts the events sent by the sink of a receiver successfully
+	ReceiverSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "receiver_events_sent_total",
+		Help:      "Number of events sent by the receiver",
+	}, []string{"receiver"})
+
+	// ReceiverFailed counts the events that the sink of a receiver failed to send
+	ReceiverFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "receiver_send_failures_total",
+		Help:      "Number of events the receiver failed to send",
+	}, []string{"receiver"})
+
+	// ReceiverLatency observes how long the sink of a receiver takes to send an event, or a batch of events
+	ReceiverLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "receiver_send_duration_seconds",
+		Help:      "Time taken by the receiver to send an event",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"receiver"})
+
+	// ReceiverBatchSize observes the number of events in the batches sent by a receiver whose sink can send batches
+	ReceiverBatchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "receiver_batch_size",
+		Help:      "Number of events in the batches sent by the receiver",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"receiver"})
+
+	// ReceiverRetries counts the attempts of a receiver to send an event again after a failure
+	ReceiverRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "receiver_retries_total",
+		Help:      "Number of times the receiver sends an event again after a failure",
+	}, []string{"receiver"})
+
+	// CircuitState is the state of the circuit breaker of a receiver: 0 closed, 1 open, 2 half-open
+	CircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "receiver_circuit_state",
+		Help:      "State of the circuit breaker of the receiver, 0 is closed, 1 is open and 2 is half-open",
+	}, []string{"receiver"})
+
+	// CircuitRejected counts the events that are failed fast or passed to the fallback because the circuit is open
+	CircuitRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "receiver_circuit_rejected_total",
+		Help:      "Number of events not sent by the receiver because its circuit is open",
+	}, []string{"receiver"})
+
+	// CircuitDiverted counts the events passed to the fallback because the circuit is open
+	CircuitDiverted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "receiver_circuit_diverted_total",
+		Help:      "Number of events passed to the fallback receiver because the circuit of the receiver is open",
+	}, []string{"receiver"})
+
+	// DeadLetters counts the events a receiver failed to send and passed to its dead-letter receiver
+	DeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "receiver_dead_letters_total",
+		Help:      "Number of events passed to the dead-letter receiver",
+	}, []string{"receiver"})
+
+	// ReceiverQueueDepth is the number of events waiting to be sent by a receiver
+	ReceiverQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "receiver_queue_depth",
+		Help:      "Number of events waiting to be sent by the receiver",
+	}, []string{"receiver"})
+
+	// ReceiverQueueDropped counts the events dropped by the overflow policy of a receiver because its queue is full
+	ReceiverQueueDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "receiver_queue_dropped_total",
+		Help:      "Number of events dropped because the queue of the receiver is full",
+	}, []string{"receiver"})
+
+	// ReceiverWALBytes is the size of the write-ahead log of a receiver with a persistent queue
+	ReceiverWALBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "receiver_queue_log_bytes",
+		Help:      "Size of the write-ahead log of the receiver queue in bytes",
+	}, []string{"receiver"})
+
+	// ReceiverWALDropped counts the events dropped from the write-ahead log of a receiver because of its limits
+	ReceiverWALDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "receiver_queue_log_dropped_total",
+		Help:      "Number of events dropped from the write-ahead log of the receiver queue because of its size or age limit",
+	}, []string{"receiver"})
+
+	// ReceiverWALReplayed counts the events of the write-ahead log of a receiver that are queued again on start
+	ReceiverWALReplayed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "receiver_queue_log_replayed_total",
+		Help:      "Number of events replayed from the write-ahead log of the receiver queue",
+	}, []string{"receiver"})
+
+	// BatchRetries counts the items that a batch writer sends again after a failure
+	BatchRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batch_retries_total",
+		Help:      "Number of items retried by a batch writer",
+	}, []string{"writer"})
+
+	// BatchDropped counts the items that a batch writer gives up on after the retries or a permanent failure
+	BatchDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batch_dropped_total",
+		Help:      "Number of items dropped by a batch writer",
+	}, []string{"writer"})
+
+	// CacheRequests counts the lookups of the enrichment caches, the cache is "labels" or "annotations" and the
+	// result is "hit" or "miss"
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "enrichment_cache_requests_total",
+		Help:      "Number of lookups in the label and annotation caches",
+	}, []string{"cache", "result"})
+
+	// LookupErrors counts the failed API requests to get the involved object of an event
+	LookupErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "enrichment_lookup_errors_total",
+		Help:      "Number of failed API requests for the involved objects of the events",
+	}, []string{"cache"})
+
+	// DerivedSeriesLimited counts the events that are not counted by a metric of a prometheus receiver, because the
+	// metric reached its series limit
+	DerivedSeriesLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "prometheus_sink_series_limited_total",
+		Help:      "Number of events not counted by a derived metric because it has too many series",
+	}, []string{"metric"})
+)
+
+// DeleteReceiver removes the series of a receiver, so that a removed receiver is not reported anymore
+func DeleteReceiver(name string) {
+	ReceiverSent.DeleteLabelValues(name)
+	ReceiverFailed.DeleteLabelValues(name)
+	ReceiverLatency.DeleteLabelValues(name)
+	ReceiverBatchSize.DeleteLabelValues(name)
+	ReceiverQueueDepth.DeleteLabelValues(name)
+	ReceiverQueueDropped.DeleteLabelValues(name)
+	ReceiverWALBytes.DeleteLabelValues(name)
+	ReceiverWALDropped.DeleteLabelValues(name)
+	ReceiverWALReplayed.DeleteLabelValues(name)
+	ReceiverRetries.DeleteLabelValues(name)
+	CircuitState.DeleteLabelValues(name)
+	CircuitRejected.DeleteLabelValues(name)
+	CircuitDiverted.DeleteLabelValues(name)
+	DeadLetters.DeleteLabelValues(name)
+}
+
+// Handler serves the registered metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
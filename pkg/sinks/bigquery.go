@@ -95,8 +95,7 @@ func bigQueryCreateDataset(cfg *BigQueryConfig) error {
 	return nil
 }
 
-func bigQueryImportJsonFromFile(path string, cfg *BigQueryConfig) error {
-	ctx := context.Background()
+func bigQueryImportJsonFromFile(ctx context.Context, path string, cfg *BigQueryConfig) error {
 	client, err := bigquery.NewClient(ctx, cfg.Project, option.WithCredentialsFile(cfg.CredentialsPath))
 	if err != nil {
 		return fmt.Errorf("bigquery.NewClient: %v", err)
@@ -182,17 +181,22 @@ func NewBigQuerySink(cfg *BigQueryConfig) (*BigQuerySink, error) {
 	}
 
 	rand.Seed(time.Now().UTC().UnixNano())
-	handleBatch := func(ctx context.Context, items []interface{}) []bool {
-		res := make([]bool, len(items))
-		for i := 0; i < len(items); i++ {
-			res[i] = true
-		}
+	handleBatch := func(ctx context.Context, items []interface{}) []batch.Result {
+		res := make([]batch.Result, len(items))
 		path := fmt.Sprintf("/tmp/bq_batch-%d-%04x.json", time.Now().UTC().Unix(), rand.Uint64()%65535)
 		if err := bigQueryWriteBatchToJsonFile(items, path); err != nil {
 			log.Error().Msgf("Failed to write JSON file: %v", err)
+			for i := range res {
+				res[i] = batch.Retry(err)
+			}
+			return res
 		}
-		if err := bigQueryImportJsonFromFile(path, cfg); err != nil {
+		if err := bigQueryImportJsonFromFile(ctx, path, cfg); err != nil {
 			log.Error().Msgf("BigQuerySink load failed: %v", err)
+			// The batch is not loaded again since its file is kept
+			for i := range res {
+				res[i] = batch.Fail(fmt.Errorf("load of %s failed: %w", path, err))
+			}
 		} else {
 			// The batch file is intentionally not deleted in case of failure allowing to manually uplaod it later and debug issues.
 			if err := os.Remove(path); err != nil {
@@ -208,10 +212,14 @@ func NewBigQuerySink(cfg *BigQueryConfig) (*BigQuerySink, error) {
 
 	batchWriter := batch.NewWriter(
 		batch.WriterConfig{
+			Name:       "bigquery",
 			BatchSize:  cfg.BatchSize,
 			MaxRetries: cfg.MaxRetries,
 			Interval:   time.Duration(cfg.IntervalSeconds) * time.Second,
 			Timeout:    time.Duration(cfg.TimeoutSeconds) * time.Second,
+			OnDrop: func(item interface{}, err error) {
+				log.Error().Err(err).Msg("BigQuerySink dropped the event")
+			},
 		},
 		handleBatch,
 	)
@@ -0,0 +1,299 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Masterminds/sprig"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+)
+
+// bulkEncoder writes the events as the lines of a bulk request, the Elasticsearch and OpenSearch sinks share it
+type bulkEncoder struct {
+	index       string
+	indexFormat *template.Template
+	docType     string
+	dataStream  string
+	opType      string
+	useEventID  bool
+	routing     string
+	deDot       bool
+	layout      map[string]interface{}
+}
+
+// bulkMeta is the action line of a document in a bulk request
+type bulkMeta struct {
+	Index   string `json:"_index"`
+	Type    string `json:"_type,omitempty"`
+	ID      string `json:"_id,omitempty"`
+	Routing string `json:"routing,omitempty"`
+}
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// validateOpType checks the op type of a sink, it is either index or create
+func validateOpType(sink, opType string) error {
+	if opType != "" && opType != "index" && opType != "create" {
+		return fmt.Errorf("%s.opType must be index or create, not %q", sink, opType)
+	}
+	return nil
+}
+
+// encode writes the events to the body of a bulk request, it returns the indices of the events in the body. The
+// events that cannot be encoded get a permanent error.
+func (b *bulkEncoder) encode(evs []*kube.EnhancedEvent, errs []error) (*bytes.Buffer, []int) {
+	var body bytes.Buffer
+	var sent []int
+	for i, ev := range evs {
+		action, doc, err := b.item(ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+		sent = append(sent, i)
+	}
+	return &body, sent
+}
+
+// item returns the action and the document lines of the event in a bulk request
+func (b *bulkEncoder) item(ev *kube.EnhancedEvent) ([]byte, []byte, error) {
+	var err error
+	meta := bulkMeta{Index: b.index, Type: b.docType}
+	if b.indexFormat != nil {
+		meta.Index, err = b.indexName(ev)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if b.useEventID {
+		meta.ID = string(ev.UID)
+	}
+	if b.routing != "" {
+		meta.Routing, err = GetString(ev, b.routing)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot render the routing: %w", err)
+		}
+	}
+
+	// The index and the routing are rendered with the labels as they are
+	if b.deDot {
+		de := ev.DeDot()
+		ev = &de
+	}
+	doc, err := serializeEventWithLayout(b.layout, ev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opType := b.opType
+	if b.dataStream != "" {
+		// The data streams only accept new documents, with a timestamp
+		meta.Index = b.dataStream
+		opType = "create"
+		if _, ok := b.layout["@timestamp"]; !ok {
+			doc = withTimestamp(doc, ev.Time())
+		}
+	}
+
+	if opType == "" {
+		opType = "index"
+	}
+	action, err := json.Marshal(map[string]bulkMeta{opType: meta})
+	if err != nil {
+		return nil, nil, err
+	}
+	return action, doc, nil
+}
+
+// indexName renders the index of the event
+func (b *bulkEncoder) indexName(ev *kube.EnhancedEvent) (string, error) {
+	var buf bytes.Buffer
+	if err := b.indexFormat.Execute(&buf, ev); err != nil {
+		return "", fmt.Errorf("cannot render the index: %w", err)
+	}
+	return sanitizeIndexName(buf.String())
+}
+
+// bulkResults sets the errors of the sent events from a successful bulk response
+func bulkResults(body []byte, errs []error, sent []int) []error {
+	var res bulkResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return setErrors(errs, sent, fmt.Errorf("cannot parse the bulk response: %w", err))
+	}
+	if !res.Errors {
+		return errs
+	}
+	for j, i := range sent {
+		if j >= len(res.Items) {
+			errs[i] = fmt.Errorf("no result for the document in the bulk response")
+			continue
+		}
+		for opType, item := range res.Items[j] {
+			errs[i] = bulkItemError(item, opType)
+		}
+	}
+	return errs
+}
+
+// bulkItemError classifies the result of a document in a bulk response. The documents rejected with 429 are rate
+// limited, the ones rejected with 5xx are retried and the others, e.g. with mapping errors, are permanent failures.
+func bulkItemError(item bulkResponseItem, opType string) error {
+	// A document that is created already was sent before, e.g. by a retry
+	if item.Status == http.StatusConflict && opType == "create" {
+		return nil
+	}
+	if item.Status < 300 {
+		return nil
+	}
+
+	err := fmt.Errorf("bulk item failed with status %d", item.Status)
+	if item.Error != nil {
+		err = fmt.Errorf("bulk item failed with status %d: %s: %s", item.Status, item.Error.Type, item.Error.Reason)
+	}
+	return statusError(err, item.Status, nil)
+}
+
+// setErrors sets the error of the events at the given indices
+func setErrors(errs []error, indices []int, err error) []error {
+	for _, i := range indices {
+		errs[i] = err
+	}
+	return errs
+}
+
+// indexFormatPart is either a literal, a template action or a date layout of an index format
+type indexFormatPart struct {
+	text   string
+	action bool
+	date   bool
+}
+
+// splitIndexFormat splits the index format into its literals, its {{ }} template actions and its {date:layout}
+// placeholders. The {layout} placeholders of the older configs are dates too.
+func splitIndexFormat(format string) ([]indexFormatPart, error) {
+	var parts []indexFormatPart
+	rest := format
+	for len(rest) > 0 {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			parts = append(parts, indexFormatPart{text: rest})
+			break
+		}
+		if i > 0 {
+			parts = append(parts, indexFormatPart{text: rest[:i]})
+		}
+		rest = rest[i:]
+
+		if strings.HasPrefix(rest, "{{") {
+			end := strings.Index(rest, "}}")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed action in index format %q", format)
+			}
+			parts = append(parts, indexFormatPart{text: rest[:end+2], action: true})
+			rest = rest[end+2:]
+			continue
+		}
+
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed date in index format %q", format)
+		}
+		parts = append(parts, indexFormatPart{text: strings.TrimPrefix(rest[1:end], "date:"), date: true})
+		rest = rest[end+1:]
+	}
+	return parts, nil
+}
+
+// parseIndexFormat compiles the index format into a template over the event, the dates are the UTC time of the event
+func parseIndexFormat(format string) (*template.Template, error) {
+	parts, err := splitIndexFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	for _, part := range parts {
+		if part.date {
+			fmt.Fprintf(&text, "{{ .Time.UTC.Format %q }}", part.text)
+		} else {
+			text.WriteString(part.text)
+		}
+	}
+	return template.New("index").Funcs(sprig.TxtFuncMap()).Option("missingkey=zero").Parse(text.String())
+}
+
+// indexFormatPattern is the pattern of the indices of the index format, each action and date matches anything
+func indexFormatPattern(format string) string {
+	parts, err := splitIndexFormat(format)
+	if err != nil {
+		return format
+	}
+
+	var pattern strings.Builder
+	for _, part := range parts {
+		if part.action || part.date {
+			if !strings.HasSuffix(pattern.String(), "*") {
+				pattern.WriteString("*")
+			}
+			continue
+		}
+		pattern.WriteString(strings.ToLower(part.text))
+	}
+	return pattern.String()
+}
+
+// sanitizeIndexName makes the name a valid index name: lowercase, without the forbidden characters and the leading
+// -, _ or +, and at most 255 bytes
+func sanitizeIndexName(name string) (string, error) {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\/*?"<>| ,#:`, r) || unicode.IsSpace(r) {
+			return '_'
+		}
+		return unicode.ToLower(r)
+	}, name)
+	name = strings.TrimLeft(name, "-_+")
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid index name %q", name)
+	}
+	return name, nil
+}
+
+// withTimestamp adds the @timestamp field that the data streams require to the document
+func withTimestamp(doc []byte, when time.Time) []byte {
+	if !bytes.HasPrefix(doc, []byte("{")) {
+		return doc
+	}
+
+	field := fmt.Sprintf(`{"@timestamp":%q`, when.UTC().Format(time.RFC3339Nano))
+	rest := bytes.TrimSpace(doc[1:])
+	if len(rest) > 0 && rest[0] != '}' {
+		field += ","
+	}
+	return append([]byte(field), rest...)
+}
@@ -0,0 +1,64 @@
+package sinks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWithTimestamp(t *testing.T) {
+	when := time.Date(2021, 11, 3, 10, 15, 30, 0, time.UTC)
+	assert.Equal(t, `{"@timestamp":"2021-11-03T10:15:30Z","reason":"BackOff"}`,
+		string(withTimestamp([]byte(`{"reason":"BackOff"}`), when)))
+	assert.Equal(t, `{"@timestamp":"2021-11-03T10:15:30Z"}`, string(withTimestamp([]byte(`{}`), when)))
+}
+
+func TestBulkEncoderIndexName(t *testing.T) {
+	ev := &kube.EnhancedEvent{}
+	ev.Namespace = "Team-A"
+	ev.Type = "Warning"
+	ev.InvolvedObject.Labels = map[string]string{"tenant": "Payments Team"}
+	ev.LastTimestamp = metav1.NewTime(time.Date(2021, 11, 3, 23, 15, 30, 0, time.FixedZone("CET", -3600)))
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"kube-events", "kube-events"},
+		{"kube-events-{2006-01-02}", "kube-events-2021-11-04"},
+		{"k8s-{{ .Namespace }}-{{ .Type | lower }}-{date:2006.01.02}", "k8s-team-a-warning-2021.11.04"},
+		{"{{ .InvolvedObject.Labels.tenant }}-events", "payments_team-events"},
+		{"{{ .InvolvedObject.Labels.missing }}-events", "events"},
+	}
+	for _, test := range tests {
+		tmpl, err := parseIndexFormat(test.format)
+		require.NoError(t, err)
+		b := &bulkEncoder{indexFormat: tmpl}
+
+		index, err := b.indexName(ev)
+		require.NoError(t, err, test.format)
+		assert.Equal(t, test.want, index, test.format)
+	}
+
+	_, err := parseIndexFormat("kube-events-{{ .Namespace")
+	assert.Error(t, err)
+}
+
+func TestSanitizeIndexName(t *testing.T) {
+	name, err := sanitizeIndexName(`_Team/A*"events"`)
+	require.NoError(t, err)
+	assert.Equal(t, "team_a__events_", name)
+
+	_, err = sanitizeIndexName("--")
+	assert.Error(t, err)
+}
+
+func TestIndexFormatPattern(t *testing.T) {
+	assert.Equal(t, "k8s-*-*-*", indexFormatPattern("k8s-{{ .Namespace }}-{{ .Type | lower }}-{date:2006.01.02}"))
+	assert.Equal(t, "kube-events-*", indexFormatPattern("kube-events-{2006-01-02}"))
+	assert.Equal(t, "k8s-*", indexFormatPattern("K8s-{{ .Namespace }}{date:2006}"))
+}
@@ -0,0 +1,48 @@
+package sinks
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultCircuitOpenDuration   = 30 * time.Second
+	defaultCircuitHalfOpenProbes = 1
+)
+
+// CircuitBreakerConfig stops sending to a receiver after consecutive failures. While the circuit is open, the events
+// fail fast or go to the fallback receiver. After OpenDuration the circuit is half-open and the next events are sent as
+// probes, one at a time: a failed probe opens the circuit again and HalfOpenProbes successful ones close it.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit, 0 disables the circuit breaker
+	FailureThreshold int           `yaml:"failureThreshold"`
+	OpenDuration     time.Duration `yaml:"openDuration"`
+	HalfOpenProbes   int           `yaml:"halfOpenProbes"`
+	// Fallback is the receiver that gets the events while the circuit is open
+	Fallback string `yaml:"fallback"`
+}
+
+// Enabled reports whether the receiver has a circuit breaker
+func (c *CircuitBreakerConfig) Enabled() bool {
+	return c.FailureThreshold > 0
+}
+
+func (c *CircuitBreakerConfig) Validate() error {
+	if c.FailureThreshold < 0 || c.HalfOpenProbes < 0 || c.OpenDuration < 0 {
+		return errors.New("circuit breaker settings cannot be negative")
+	}
+	if c.Fallback != "" && !c.Enabled() {
+		return errors.New("circuit breaker fallback requires a failureThreshold")
+	}
+	return nil
+}
+
+// SetDefaults fills the unset open duration and probes
+func (c *CircuitBreakerConfig) SetDefaults() {
+	if c.OpenDuration == 0 {
+		c.OpenDuration = defaultCircuitOpenDuration
+	}
+	if c.HalfOpenProbes == 0 {
+		c.HalfOpenProbes = defaultCircuitHalfOpenProbes
+	}
+}
@@ -1,15 +1,12 @@
 package sinks
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"regexp"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
@@ -33,9 +30,25 @@ type ElasticsearchConfig struct {
 	Type        string                 `yaml:"type"`
 	TLS         TLS                    `yaml:"tls"`
 	Layout      map[string]interface{} `yaml:"layout"`
+	// Pipeline is the ingest pipeline of the documents
+	Pipeline string `yaml:"pipeline"`
+	// OpType is either "index" (default) or "create", which does not overwrite the documents with the same ID
+	OpType string `yaml:"opType"`
+	// Routing is a template of the routing value of the documents
+	Routing string `yaml:"routing"`
+	// DataStream is the data stream that the events are written to, instead of an index
+	DataStream    string                        `yaml:"dataStream"`
+	IndexTemplate *ElasticsearchTemplateConfig  `yaml:"indexTemplate"`
+	Lifecycle     *ElasticsearchLifecycleConfig `yaml:"lifecycle"`
 }
 
 func NewElasticsearch(cfg *ElasticsearchConfig) (*Elasticsearch, error) {
+	if err := validateOpType("elasticsearch", cfg.OpType); err != nil {
+		return nil, err
+	}
+	if err := validateBootstrap(cfg); err != nil {
+		return nil, err
+	}
 
 	tlsClientConfig, err := setupTLS(&cfg.TLS)
 	if err != nil {
@@ -56,94 +69,79 @@ func NewElasticsearch(cfg *ElasticsearchConfig) (*Elasticsearch, error) {
 		return nil, err
 	}
 
-	return &Elasticsearch{
+	e := &Elasticsearch{
 		client: client,
 		cfg:    cfg,
-	}, nil
+		bulk: &bulkEncoder{
+			index:      cfg.Index,
+			docType:    cfg.Type,
+			dataStream: cfg.DataStream,
+			opType:     cfg.OpType,
+			useEventID: cfg.UseEventID,
+			routing:    cfg.Routing,
+			deDot:      cfg.DeDot,
+			layout:     cfg.Layout,
+		},
+	}
+	if cfg.IndexFormat != "" {
+		e.bulk.indexFormat, err = parseIndexFormat(cfg.IndexFormat)
+		if err != nil {
+			return nil, fmt.Errorf("elasticsearch.indexFormat: %w", err)
+		}
+	}
+
+	// The events are not lost when the cluster is not reachable yet, the first batch tries again
+	ctx, cancel := context.WithTimeout(context.Background(), bootstrapTimeout)
+	defer cancel()
+	if err := e.ensureBootstrap(ctx); err != nil {
+		log.Warn().Err(err).Msg("Cannot bootstrap Elasticsearch, trying again with the first events")
+	}
+	return e, nil
 }
 
 type Elasticsearch struct {
 	client *elasticsearch.Client
 	cfg    *ElasticsearchConfig
-}
-
-var regex = regexp.MustCompile(`(?s){(.*)}`)
-
-func formatIndexName(pattern string, when time.Time) string {
-	m := regex.FindAllStringSubmatchIndex(pattern, -1)
-	current := 0
-	var builder strings.Builder
-
-	for i := 0; i < len(m); i++ {
-		pair := m[i]
+	bulk   *bulkEncoder
 
-		builder.WriteString(pattern[current:pair[0]])
-		builder.WriteString(when.Format(pattern[pair[0]+1 : pair[1]-1]))
-		current = pair[1]
-	}
-
-	builder.WriteString(pattern[current:])
-
-	return builder.String()
+	mu           sync.Mutex
+	bootstrapped bool
 }
 
 func (e *Elasticsearch) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	var toSend []byte
-
-	if e.cfg.DeDot {
-		de := ev.DeDot()
-		ev = &de
-	}
-	if e.cfg.Layout != nil {
-		res, err := convertLayoutTemplate(e.cfg.Layout, ev)
-		if err != nil {
-			return err
-		}
-
-		toSend, err = json.Marshal(res)
-		if err != nil {
-			return err
-		}
-	} else {
-		toSend = ev.ToJSON()
-	}
-
-	var index string
-	if len(e.cfg.IndexFormat) > 0 {
-		now := time.Now()
-		index = formatIndexName(e.cfg.IndexFormat, now)
-	} else {
-		index = e.cfg.Index
-	}
+	return e.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
 
-	req := esapi.IndexRequest{
-		Body:  bytes.NewBuffer(toSend),
-		Index: index,
+// SendBatch indexes the events with a single bulk request, each event gets the result of its document
+func (e *Elasticsearch) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	if err := e.ensureBootstrap(ctx); err != nil {
+		return batchErrors(len(evs), err)
 	}
-
-	// This should not be used for clusters with ES8.0+.
-	if len(e.cfg.Type) > 0 {
-		req.DocumentType = e.cfg.Type
+	errs := make([]error, len(evs))
+	body, sent := e.bulk.encode(evs, errs)
+	if len(sent) == 0 {
+		return errs
 	}
 
-	if e.cfg.UseEventID {
-		req.DocumentID = string(ev.UID)
+	req := esapi.BulkRequest{
+		Body:     body,
+		Pipeline: e.cfg.Pipeline,
 	}
-
 	resp, err := req.Do(ctx, e.client)
 	if err != nil {
-		return err
+		return setErrors(errs, sent, err)
 	}
-
 	defer resp.Body.Close()
-	if resp.StatusCode > 399 {
-		rb, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-		log.Error().Msgf("Indexing failed: %s", string(rb))
+
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return setErrors(errs, sent, err)
+	}
+	if resp.IsError() {
+		err := fmt.Errorf("not successfull (2xx) response: %s: %s", resp.Status(), strings.TrimSpace(string(rb)))
+		return setErrors(errs, sent, statusError(err, resp.StatusCode, resp.Header))
 	}
-	return nil
+	return bulkResults(rb, errs, sent)
 }
 
 func (e *Elasticsearch) Close() {
@@ -0,0 +1,209 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultTemplateName    = "kube-events"
+	defaultRolloverMaxAge  = "1d"
+	defaultRolloverMaxSize = "50gb"
+	defaultDeleteAfter     = "30d"
+	// bootstrapTimeout limits the installation of the template and the policy on start
+	bootstrapTimeout = 30 * time.Second
+)
+
+// elasticsearchMappings are the mappings of the shipped index template. The labels and the annotations are flattened
+// and the unknown fields are not indexed, so the mappings do not grow with the events.
+//
+//go:embed elasticsearch_mappings.json
+var elasticsearchMappings []byte
+
+// ElasticsearchTemplateConfig installs an index template for the events on start
+type ElasticsearchTemplateConfig struct {
+	// Name of the template, the data stream or the index by default
+	Name string `yaml:"name"`
+	// File is the body of a custom composable index template, instead of the shipped one
+	File string `yaml:"file"`
+}
+
+// ElasticsearchLifecycleConfig installs an ILM policy for the events on start. The policy rolls over the backing
+// indices of a data stream and deletes the indices after DeleteAfter.
+type ElasticsearchLifecycleConfig struct {
+	// Name of the policy, the name of the template by default
+	Name            string `yaml:"name"`
+	RolloverMaxAge  string `yaml:"rolloverMaxAge"`
+	RolloverMaxSize string `yaml:"rolloverMaxSize"`
+	DeleteAfter     string `yaml:"deleteAfter"`
+	// File is the body of a custom policy, instead of the generated one
+	File string `yaml:"file"`
+}
+
+// validateBootstrap checks the data stream, template and lifecycle options of the config
+func validateBootstrap(cfg *ElasticsearchConfig) error {
+	if cfg.DataStream != "" {
+		if cfg.Index != "" || cfg.IndexFormat != "" {
+			return fmt.Errorf("elasticsearch.dataStream cannot be used with index or indexFormat")
+		}
+		if cfg.OpType == "index" {
+			return fmt.Errorf("elasticsearch.dataStream only accepts the create opType")
+		}
+	}
+	if cfg.Lifecycle != nil && cfg.IndexTemplate == nil {
+		return fmt.Errorf("elasticsearch.lifecycle requires an indexTemplate to apply the policy")
+	}
+	return nil
+}
+
+func (e *Elasticsearch) templateName() string {
+	switch {
+	case e.cfg.IndexTemplate != nil && e.cfg.IndexTemplate.Name != "":
+		return e.cfg.IndexTemplate.Name
+	case e.cfg.DataStream != "":
+		return e.cfg.DataStream
+	case e.cfg.Index != "":
+		return e.cfg.Index
+	default:
+		return defaultTemplateName
+	}
+}
+
+func (e *Elasticsearch) policyName() string {
+	if e.cfg.Lifecycle.Name != "" {
+		return e.cfg.Lifecycle.Name
+	}
+	return e.templateName()
+}
+
+// indexPatterns are the indices the template applies to, the placeholders of the index format match any index
+func (e *Elasticsearch) indexPatterns() []string {
+	switch {
+	case e.cfg.DataStream != "":
+		return []string{e.cfg.DataStream}
+	case e.cfg.IndexFormat != "":
+		return []string{indexFormatPattern(e.cfg.IndexFormat)}
+	default:
+		return []string{e.cfg.Index}
+	}
+}
+
+// ensureBootstrap installs the policy and the template once, it tries again on the next batch when it fails
+func (e *Elasticsearch) ensureBootstrap(ctx context.Context) error {
+	if e.cfg.IndexTemplate == nil && e.cfg.Lifecycle == nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.bootstrapped {
+		return nil
+	}
+	if e.cfg.Lifecycle != nil {
+		body, err := e.lifecyclePolicy()
+		if err != nil {
+			return err
+		}
+		if err := e.put(ctx, "/_ilm/policy/"+url.PathEscape(e.policyName()), body); err != nil {
+			return fmt.Errorf("cannot install the lifecycle policy: %w", err)
+		}
+	}
+	if e.cfg.IndexTemplate != nil {
+		body, err := e.indexTemplate()
+		if err != nil {
+			return err
+		}
+		if err := e.put(ctx, "/_index_template/"+url.PathEscape(e.templateName()), body); err != nil {
+			return fmt.Errorf("cannot install the index template: %w", err)
+		}
+	}
+	e.bootstrapped = true
+	return nil
+}
+
+func (e *Elasticsearch) indexTemplate() ([]byte, error) {
+	if e.cfg.IndexTemplate.File != "" {
+		return ioutil.ReadFile(e.cfg.IndexTemplate.File)
+	}
+
+	settings := map[string]interface{}{}
+	if e.cfg.Lifecycle != nil {
+		settings["index.lifecycle.name"] = e.policyName()
+	}
+	template := map[string]interface{}{
+		"index_patterns": e.indexPatterns(),
+		// The template takes precedence over the built-in ones, e.g. logs-*-*
+		"priority": 200,
+		"template": map[string]interface{}{
+			"settings": settings,
+			"mappings": json.RawMessage(elasticsearchMappings),
+		},
+		"_meta": map[string]interface{}{"managed_by": "kubernetes-event-exporter"},
+	}
+	if e.cfg.DataStream != "" {
+		template["data_stream"] = map[string]interface{}{}
+	}
+	return json.Marshal(template)
+}
+
+func (e *Elasticsearch) lifecyclePolicy() ([]byte, error) {
+	cfg := e.cfg.Lifecycle
+	if cfg.File != "" {
+		return ioutil.ReadFile(cfg.File)
+	}
+
+	deleteAfter := cfg.DeleteAfter
+	if deleteAfter == "" {
+		deleteAfter = defaultDeleteAfter
+	}
+	phases := map[string]interface{}{
+		"delete": map[string]interface{}{
+			"min_age": deleteAfter,
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		},
+	}
+	// Only the data streams roll over without an alias
+	if e.cfg.DataStream != "" {
+		maxAge, maxSize := cfg.RolloverMaxAge, cfg.RolloverMaxSize
+		if maxAge == "" {
+			maxAge = defaultRolloverMaxAge
+		}
+		if maxSize == "" {
+			maxSize = defaultRolloverMaxSize
+		}
+		phases["hot"] = map[string]interface{}{
+			"actions": map[string]interface{}{
+				"rollover": map[string]interface{}{"max_age": maxAge, "max_size": maxSize},
+			},
+		}
+	}
+	return json.Marshal(map[string]interface{}{"policy": map[string]interface{}{"phases": phases}})
+}
+
+func (e *Elasticsearch) put(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Perform(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		rb, _ := ioutil.ReadAll(resp.Body)
+		return responseError(resp, rb)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestElasticsearchSendBatch(t *testing.T) {
+	var actions []map[string]bulkMeta
+	var pipeline string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		pipeline = r.URL.Query().Get("pipeline")
+
+		scanner := bufio.NewScanner(r.Body)
+		for line := 0; scanner.Scan(); line++ {
+			// The even lines are the actions and the odd ones the documents
+			if line%2 == 0 {
+				var action map[string]bulkMeta
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &action))
+				actions = append(actions, action)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"errors":true,"items":[
+			{"create":{"status":201}},
+			{"create":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue is full"}}},
+			{"create":{"status":503,"error":{"type":"unavailable_shards_exception","reason":"primary shard is not active"}}},
+			{"create":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field [count]"}}},
+			{"create":{"status":409,"error":{"type":"version_conflict_engine_exception","reason":"document already exists"}}}
+		]}`)
+	}))
+	defer server.Close()
+
+	es, err := NewElasticsearch(&ElasticsearchConfig{
+		Hosts:      []string{server.URL},
+		Index:      "kube-events",
+		UseEventID: true,
+		Pipeline:   "events",
+		OpType:     "create",
+		Routing:    "{{ .InvolvedObject.Namespace }}",
+	})
+	require.NoError(t, err)
+
+	var evs []*kube.EnhancedEvent
+	for i := 0; i < 5; i++ {
+		ev := &kube.EnhancedEvent{}
+		ev.UID = types.UID(fmt.Sprint(i))
+		ev.InvolvedObject.Namespace = "default"
+		evs = append(evs, ev)
+	}
+	errs := es.SendBatch(context.Background(), evs)
+
+	assert.Equal(t, "events", pipeline)
+	require.Len(t, actions, 5)
+	assert.Equal(t, bulkMeta{Index: "kube-events", ID: "0", Routing: "default"}, actions[0]["create"])
+
+	assert.NoError(t, errs[0])
+	var rateLimited *RateLimitedError
+	assert.ErrorAs(t, errs[1], &rateLimited)
+	assert.Error(t, errs[2])
+	assert.False(t, IsPermanent(errs[2]))
+	assert.True(t, IsPermanent(errs[3]))
+	assert.Contains(t, errs[3].Error(), "mapper_parsing_exception")
+	// The document was created by an earlier attempt
+	assert.NoError(t, errs[4])
+}
+
+func TestElasticsearchSendRequestError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	es, err := NewElasticsearch(&ElasticsearchConfig{Hosts: []string{server.URL}, Index: "kube-events"})
+	require.NoError(t, err)
+
+	err = es.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.Error(t, err)
+	assert.False(t, IsPermanent(err))
+}
+
+func TestElasticsearchOpType(t *testing.T) {
+	_, err := NewElasticsearch(&ElasticsearchConfig{OpType: "update"})
+	assert.Error(t, err)
+}
+
+func TestElasticsearchDataStreamBootstrap(t *testing.T) {
+	var mu sync.Mutex
+	bodies := make(map[string]map[string]interface{})
+	var docs []map[string]interface{}
+	var actions []map[string]bulkMeta
+	failTemplate := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path == "/_bulk" {
+			scanner := bufio.NewScanner(r.Body)
+			for line := 0; scanner.Scan(); line++ {
+				if line%2 == 0 {
+					var action map[string]bulkMeta
+					require.NoError(t, json.Unmarshal(scanner.Bytes(), &action))
+					actions = append(actions, action)
+				} else {
+					var doc map[string]interface{}
+					require.NoError(t, json.Unmarshal(scanner.Bytes(), &doc))
+					docs = append(docs, doc)
+				}
+			}
+			fmt.Fprint(w, `{"errors":false,"items":[{"create":{"status":201}}]}`)
+			return
+		}
+
+		assert.Equal(t, http.MethodPut, r.Method)
+		// The cluster is not ready on start
+		if r.URL.Path == "/_index_template/kube-events" && failTemplate {
+			failTemplate = false
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies[r.URL.Path] = body
+	}))
+	defer server.Close()
+
+	es, err := NewElasticsearch(&ElasticsearchConfig{
+		Hosts:         []string{server.URL},
+		DataStream:    "kube-events",
+		IndexTemplate: &ElasticsearchTemplateConfig{},
+		Lifecycle:     &ElasticsearchLifecycleConfig{DeleteAfter: "7d"},
+	})
+	require.NoError(t, err)
+	assert.False(t, es.bootstrapped)
+
+	ev := &kube.EnhancedEvent{}
+	ev.LastTimestamp = metav1.NewTime(time.Date(2021, 11, 3, 10, 15, 30, 0, time.UTC))
+	require.NoError(t, es.Send(context.Background(), ev))
+
+	policy := bodies["/_ilm/policy/kube-events"]
+	require.NotNil(t, policy)
+	phases := policy["policy"].(map[string]interface{})["phases"].(map[string]interface{})
+	assert.Equal(t, "7d", phases["delete"].(map[string]interface{})["min_age"])
+	assert.Contains(t, phases, "hot")
+
+	template := bodies["/_index_template/kube-events"]
+	require.NotNil(t, template)
+	assert.Equal(t, []interface{}{"kube-events"}, template["index_patterns"])
+	assert.Contains(t, template, "data_stream")
+	settings := template["template"].(map[string]interface{})["settings"].(map[string]interface{})
+	assert.Equal(t, "kube-events", settings["index.lifecycle.name"])
+
+	require.Len(t, actions, 1)
+	assert.Equal(t, bulkMeta{Index: "kube-events"}, actions[0]["create"])
+	assert.Equal(t, "2021-11-03T10:15:30Z", docs[0]["@timestamp"])
+}
+
+func TestElasticsearchDataStreamConfig(t *testing.T) {
+	_, err := NewElasticsearch(&ElasticsearchConfig{DataStream: "kube-events", Index: "kube-events"})
+	assert.Error(t, err)
+	_, err = NewElasticsearch(&ElasticsearchConfig{Index: "kube-events", Lifecycle: &ElasticsearchLifecycleConfig{}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PermanentError is returned by the sinks for the failures that sending the event again cannot fix, e.g. a rejected
+// request. The errors that are not marked as permanent are retried.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks the error as permanent, it returns nil for a nil error
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether the error or any error it wraps is permanent
+func IsPermanent(err error) bool {
+	var p *PermanentError
+	return errors.As(err, &p)
+}
+
+// RateLimitedError is returned by the sinks when the downstream asks to slow down. RetryAfter is the time to wait
+// before the next attempt, it is zero when the downstream does not tell.
+type RateLimitedError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited, retry after %s: %v", e.RetryAfter, e.Err)
+	}
+	return fmt.Sprintf("rate limited: %v", e.Err)
+}
+
+func (e *RateLimitedError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimited marks the error as rate limited, it returns nil for a nil error
+func RateLimited(err error, retryAfter time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &RateLimitedError{Err: err, RetryAfter: retryAfter}
+}
+
+// RetryAfter returns the wait asked by a rate limited error, it is zero for the other errors
+func RetryAfter(err error) time.Duration {
+	var r *RateLimitedError
+	if errors.As(err, &r) {
+		return r.RetryAfter
+	}
+	return 0
+}
+
+// responseError classifies a response that is not 2xx: 429 is rate limited with the Retry-After header, 408 and 5xx
+// are retried and the other statuses are permanent
+func responseError(resp *http.Response, body []byte) error {
+	err := fmt.Errorf("not successfull (2xx) response: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	return statusError(err, resp.StatusCode, resp.Header)
+}
+
+// statusError classifies the error of an HTTP status code like responseError
+func statusError(err error, code int, header http.Header) error {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return RateLimited(err, parseRetryAfter(header.Get("Retry-After"), time.Now()))
+	case code == http.StatusRequestTimeout || code >= 500:
+		return err
+	default:
+		return Permanent(err)
+	}
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil && when.After(now) {
+		return when.Sub(now)
+	}
+	return 0
+}
+
+// awsRecordError is the error of a record rejected in a batch request of an AWS service. The throttled records are rate
+// limited and the rest, which are internal failures, are retried.
+func awsRecordError(code, message string) error {
+	err := fmt.Errorf("%s: %s", code, message)
+	switch code {
+	case "ProvisionedThroughputExceededException", "ThrottlingException":
+		return RateLimited(err, 0)
+	default:
+		return err
+	}
+}
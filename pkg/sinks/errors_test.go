@@ -0,0 +1,56 @@
+package sinks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookErrorClassification(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		permanent  bool
+		wait       time.Duration
+	}{
+		{status: http.StatusBadGateway},
+		{status: http.StatusRequestTimeout},
+		{status: http.StatusBadRequest, permanent: true},
+		{status: http.StatusUnauthorized, permanent: true},
+		{status: http.StatusTooManyRequests, retryAfter: "7", wait: 7 * time.Second},
+		{status: http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.retryAfter != "" {
+				w.Header().Set("Retry-After", test.retryAfter)
+			}
+			w.WriteHeader(test.status)
+		}))
+
+		sink, _ := NewWebhook(&WebhookConfig{Endpoint: srv.URL})
+		err := sink.Send(context.Background(), &kube.EnhancedEvent{})
+		srv.Close()
+
+		assert.Error(t, err, test.status)
+		assert.Equal(t, test.permanent, IsPermanent(err), test.status)
+		assert.Equal(t, test.wait, RetryAfter(err), test.status)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 11, 3, 10, 15, 30, 0, time.UTC)
+
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Wed, 03 Nov 2021 10:16:00 GMT", now))
+	assert.Zero(t, parseRetryAfter("Wed, 03 Nov 2021 10:00:00 GMT", now))
+	assert.Zero(t, parseRetryAfter("-1", now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+	assert.Zero(t, parseRetryAfter("", now))
+}
@@ -3,15 +3,24 @@ package sinks
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
 	"github.com/rs/zerolog/log"
 	"time"
 )
 
+const (
+	// eventBridgeMaxBatch is the max number of entries in a PutEvents request
+	eventBridgeMaxBatch = 10
+	// eventBridgeMaxBatchSize is the max size of the entries of a PutEvents request
+	eventBridgeMaxBatchSize = 256 * 1024
+)
+
 type EventBridgeConfig struct {
 	DetailType   string                 `yaml:"detailType"`
 	Details      map[string]interface{} `yaml:"details"`
@@ -22,7 +31,7 @@ type EventBridgeConfig struct {
 
 type EventBridgeSink struct {
 	cfg *EventBridgeConfig
-	svc *eventbridge.EventBridge
+	svc eventbridgeiface.EventBridgeAPI
 }
 
 func NewEventBridgeSink(cfg *EventBridgeConfig) (Sink, error) {
@@ -49,40 +58,95 @@ func NewEventBridgeSink(cfg *EventBridgeConfig) (Sink, error) {
 }
 
 func (s *EventBridgeSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	log.Info().Msg("Sending event to EventBridge ")
+	return s.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch puts the events with PutEvents, in requests of at most 10 entries and 256 KiB. The entries that EventBridge
+// rejects get their own errors.
+func (s *EventBridgeSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	var entries []*eventbridge.PutEventsRequestEntry
+	var sent []int
+	size := 0
+	flush := func() {
+		if len(entries) > 0 {
+			s.putEvents(ctx, entries, sent, errs)
+		}
+		entries, sent, size = nil, nil, 0
+	}
+
+	for i, ev := range evs {
+		entry, err := s.entry(ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		entrySize := eventBridgeEntrySize(entry)
+		if entrySize > eventBridgeMaxBatchSize {
+			errs[i] = Permanent(fmt.Errorf("entry of %d bytes is larger than the limit of EventBridge", entrySize))
+			continue
+		}
+		if len(entries) == eventBridgeMaxBatch || size+entrySize > eventBridgeMaxBatchSize {
+			flush()
+		}
+		entries = append(entries, entry)
+		sent = append(sent, i)
+		size += entrySize
+	}
+	flush()
+	return errs
+}
+
+func (s *EventBridgeSink) entry(ev *kube.EnhancedEvent) (*eventbridge.PutEventsRequestEntry, error) {
 	var toSend string
 	if s.cfg.Details != nil {
 		res, err := convertLayoutTemplate(s.cfg.Details, ev)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		b, err := json.Marshal(res)
-		toSend = string(b)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		toSend = string(b)
 	} else {
 		toSend = string(ev.ToJSON())
 	}
 	tym := time.Now()
-	inputRequest := eventbridge.PutEventsRequestEntry{
+	return &eventbridge.PutEventsRequestEntry{
 		Detail:       &toSend,
 		DetailType:   &s.cfg.DetailType,
 		Time:         &tym,
 		Source:       &s.cfg.Source,
 		EventBusName: &s.cfg.EventBusName,
+	}, nil
+}
+
+// eventBridgeEntrySize is the size of the entry as EventBridge counts it against the limit of a request
+func eventBridgeEntrySize(entry *eventbridge.PutEventsRequestEntry) int {
+	// The time counts as 14 bytes
+	size := 14
+	size += len(aws.StringValue(entry.Source)) + len(aws.StringValue(entry.DetailType)) + len(aws.StringValue(entry.Detail))
+	for _, r := range entry.Resources {
+		size += len(aws.StringValue(r))
 	}
-	log.Info().Str("InputEvent", inputRequest.String()).Msg("Request")
+	return size
+}
 
-	req, _ := s.svc.PutEventsRequest(&eventbridge.PutEventsInput{Entries: []*eventbridge.PutEventsRequestEntry{&inputRequest}})
-	// TODO: Retry failed events
-	err := req.Send()
+func (s *EventBridgeSink) putEvents(ctx context.Context, entries []*eventbridge.PutEventsRequestEntry, sent []int, errs []error) {
+	out, err := s.svc.PutEventsWithContext(ctx, &eventbridge.PutEventsInput{Entries: entries})
 	if err != nil {
 		log.Error().Err(err).Msg("EventBridge Error")
-		return err
+		setErrors(errs, sent, err)
+		return
+	}
+	// The result entries are in the order of the request entries
+	for j, res := range out.Entries {
+		if j < len(sent) && res.ErrorCode != nil {
+			errs[sent[j]] = awsRecordError(*res.ErrorCode, aws.StringValue(res.ErrorMessage))
+		}
 	}
-	return nil
 }
 
 func (s *EventBridgeSink) Close() {
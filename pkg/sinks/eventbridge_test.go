@@ -0,0 +1,73 @@
+package sinks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockedPutEvents rejects the entries at the given positions of each request with the error code
+type mockedPutEvents struct {
+	eventbridgeiface.EventBridgeAPI
+	rejected map[int]string
+	requests [][]*eventbridge.PutEventsRequestEntry
+}
+
+func (m *mockedPutEvents) PutEventsWithContext(ctx aws.Context, in *eventbridge.PutEventsInput, o ...request.Option) (*eventbridge.PutEventsOutput, error) {
+	m.requests = append(m.requests, in.Entries)
+	out := &eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(int64(len(m.rejected)))}
+	for i := range in.Entries {
+		entry := &eventbridge.PutEventsResultEntry{EventId: aws.String("1")}
+		if code, ok := m.rejected[i]; ok {
+			entry = &eventbridge.PutEventsResultEntry{ErrorCode: aws.String(code), ErrorMessage: aws.String("rejected")}
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	return out, nil
+}
+
+func TestEventBridgeSinkSendBatch(t *testing.T) {
+	svc := &mockedPutEvents{rejected: map[int]string{1: "ThrottlingException", 2: "InternalFailure"}}
+	sink := &EventBridgeSink{cfg: &EventBridgeConfig{
+		DetailType:   "Kubernetes Event",
+		Source:       "kubernetes",
+		EventBusName: "default",
+		Details:      map[string]interface{}{"reason": "{{ .Reason }}"},
+	}, svc: svc}
+
+	evs := make([]*kube.EnhancedEvent, eventBridgeMaxBatch+3)
+	for i := range evs {
+		evs[i] = &kube.EnhancedEvent{}
+		evs[i].Reason = "BackOff"
+	}
+	errs := sink.SendBatch(context.Background(), evs)
+
+	require.Len(t, svc.requests, 2)
+	assert.Len(t, svc.requests[0], eventBridgeMaxBatch)
+	assert.Len(t, svc.requests[1], 3)
+	entry := svc.requests[0][0]
+	assert.Equal(t, `{"reason":"BackOff"}`, aws.StringValue(entry.Detail))
+	assert.Equal(t, "Kubernetes Event", aws.StringValue(entry.DetailType))
+	assert.Equal(t, "kubernetes", aws.StringValue(entry.Source))
+	assert.Equal(t, "default", aws.StringValue(entry.EventBusName))
+
+	for i, err := range errs {
+		switch i % eventBridgeMaxBatch {
+		case 1:
+			var r *RateLimitedError
+			assert.ErrorAs(t, err, &r)
+		case 2:
+			assert.Error(t, err)
+			assert.False(t, IsPermanent(err))
+		default:
+			assert.NoError(t, err)
+		}
+	}
+}
@@ -6,9 +6,13 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
 )
 
+// firehoseMaxBatch is the max number of records in a PutRecordBatch request
+const firehoseMaxBatch = 500
+
 type FirehoseConfig struct {
 	DeliveryStreamName string                 `yaml:"deliveryStreamName"`
 	Region             string                 `yaml:"region"`
@@ -17,7 +21,7 @@ type FirehoseConfig struct {
 
 type FirehoseSink struct {
 	cfg *FirehoseConfig
-	svc *firehose.Firehose
+	svc firehoseiface.FirehoseAPI
 }
 
 func NewFirehoseSink(cfg *FirehoseConfig) (Sink, error) {
@@ -61,6 +65,49 @@ func (f *FirehoseSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 	return err
 }
 
+// SendBatch puts the events with PutRecordBatch, the records that Firehose rejects get their own errors
+func (f *FirehoseSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	for start := 0; start < len(evs); start += firehoseMaxBatch {
+		end := start + firehoseMaxBatch
+		if end > len(evs) {
+			end = len(evs)
+		}
+		f.putRecordBatch(ctx, evs[start:end], errs[start:end])
+	}
+	return errs
+}
+
+func (f *FirehoseSink) putRecordBatch(ctx context.Context, evs []*kube.EnhancedEvent, errs []error) {
+	input := &firehose.PutRecordBatchInput{DeliveryStreamName: aws.String(f.cfg.DeliveryStreamName)}
+	var sent []int
+	for i, ev := range evs {
+		data, err := serializeEventWithLayout(f.cfg.Layout, ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		input.Records = append(input.Records, &firehose.Record{Data: data})
+		sent = append(sent, i)
+	}
+	if len(sent) == 0 {
+		return
+	}
+
+	out, err := f.svc.PutRecordBatchWithContext(ctx, input)
+	if err != nil {
+		for _, i := range sent {
+			errs[i] = err
+		}
+		return
+	}
+	for j, rec := range out.RequestResponses {
+		if rec.ErrorCode != nil {
+			errs[sent[j]] = awsRecordError(*rec.ErrorCode, aws.StringValue(rec.ErrorMessage))
+		}
+	}
+}
+
 func (f *FirehoseSink) Close() {
 	// No-op
 }
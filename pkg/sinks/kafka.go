@@ -8,6 +8,7 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
 	"io/ioutil"
 )
 
@@ -102,15 +103,50 @@ func (k *KafkaSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 		toSend = ev.ToJSON()
 	}
 
+	// The consumers can continue the trace of the event
+	var headers kafkaHeaderCarrier
+	otel.GetTextMapPropagator().Inject(ctx, &headers)
+
 	_, _, err := k.producer.SendMessage(&sarama.ProducerMessage{
-		Topic: k.cfg.Topic,
-		Key:   sarama.StringEncoder(string(ev.UID)),
-		Value: sarama.ByteEncoder(toSend),
+		Topic:   k.cfg.Topic,
+		Key:     sarama.StringEncoder(string(ev.UID)),
+		Value:   sarama.ByteEncoder(toSend),
+		Headers: headers,
 	})
 
 	return err
 }
 
+// kafkaHeaderCarrier adapts the headers of a Kafka message to propagation.TextMapCarrier
+type kafkaHeaderCarrier []sarama.RecordHeader
+
+func (c *kafkaHeaderCarrier) Get(key string) string {
+	for _, h := range *c {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c *kafkaHeaderCarrier) Set(key, value string) {
+	for i := range *c {
+		if string((*c)[i].Key) == key {
+			(*c)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c = append(*c, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c *kafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c))
+	for _, h := range *c {
+		keys = append(keys, string(h.Key))
+	}
+	return keys
+}
+
 // Close the Kafka producer
 func (k *KafkaSink) Close() {
 	log.Info().Msgf("kafka: Closing producer...")
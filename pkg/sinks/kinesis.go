@@ -6,9 +6,13 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
 )
 
+// kinesisMaxBatch is the max number of records in a PutRecords request
+const kinesisMaxBatch = 500
+
 type KinesisConfig struct {
 	StreamName string                 `yaml:"streamName"`
 	Region     string                 `yaml:"region"`
@@ -17,7 +21,7 @@ type KinesisConfig struct {
 
 type KinesisSink struct {
 	cfg *KinesisConfig
-	svc *kinesis.Kinesis
+	svc kinesisiface.KinesisAPI
 }
 
 func NewKinesisSink(cfg *KinesisConfig) (Sink, error) {
@@ -60,6 +64,52 @@ func (k *KinesisSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 	return err
 }
 
+// SendBatch puts the events with PutRecords, the records that Kinesis rejects get their own errors
+func (k *KinesisSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	for start := 0; start < len(evs); start += kinesisMaxBatch {
+		end := start + kinesisMaxBatch
+		if end > len(evs) {
+			end = len(evs)
+		}
+		k.putRecords(ctx, evs[start:end], errs[start:end])
+	}
+	return errs
+}
+
+func (k *KinesisSink) putRecords(ctx context.Context, evs []*kube.EnhancedEvent, errs []error) {
+	input := &kinesis.PutRecordsInput{StreamName: aws.String(k.cfg.StreamName)}
+	var sent []int
+	for i, ev := range evs {
+		data, err := serializeEventWithLayout(k.cfg.Layout, ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		input.Records = append(input.Records, &kinesis.PutRecordsRequestEntry{
+			Data:         data,
+			PartitionKey: aws.String(string(ev.UID)),
+		})
+		sent = append(sent, i)
+	}
+	if len(sent) == 0 {
+		return
+	}
+
+	out, err := k.svc.PutRecordsWithContext(ctx, input)
+	if err != nil {
+		for _, i := range sent {
+			errs[i] = err
+		}
+		return
+	}
+	for j, rec := range out.Records {
+		if rec.ErrorCode != nil {
+			errs[sent[j]] = awsRecordError(*rec.ErrorCode, aws.StringValue(rec.ErrorMessage))
+		}
+	}
+}
+
 func (k *KinesisSink) Close() {
 	// No-op
 }
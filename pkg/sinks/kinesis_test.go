@@ -0,0 +1,61 @@
+package sinks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockedPutRecords rejects the records at the given positions of each request with the error code
+type mockedPutRecords struct {
+	kinesisiface.KinesisAPI
+	rejected map[int]string
+	requests []int
+}
+
+func (m *mockedPutRecords) PutRecordsWithContext(ctx aws.Context, in *kinesis.PutRecordsInput, o ...request.Option) (*kinesis.PutRecordsOutput, error) {
+	m.requests = append(m.requests, len(in.Records))
+	out := &kinesis.PutRecordsOutput{}
+	for i := range in.Records {
+		entry := &kinesis.PutRecordsResultEntry{SequenceNumber: aws.String("1")}
+		if code, ok := m.rejected[i]; ok {
+			entry = &kinesis.PutRecordsResultEntry{ErrorCode: aws.String(code), ErrorMessage: aws.String("rejected")}
+		}
+		out.Records = append(out.Records, entry)
+	}
+	return out, nil
+}
+
+func TestKinesisSinkSendBatch(t *testing.T) {
+	svc := &mockedPutRecords{rejected: map[int]string{
+		1: "ProvisionedThroughputExceededException",
+		2: "InternalFailure",
+	}}
+	sink := &KinesisSink{cfg: &KinesisConfig{StreamName: "events"}, svc: svc}
+
+	evs := make([]*kube.EnhancedEvent, kinesisMaxBatch+3)
+	for i := range evs {
+		evs[i] = &kube.EnhancedEvent{}
+	}
+	errs := sink.SendBatch(context.Background(), evs)
+
+	assert.Equal(t, []int{kinesisMaxBatch, 3}, svc.requests)
+	for i, err := range errs {
+		switch i % kinesisMaxBatch {
+		case 1:
+			var r *RateLimitedError
+			assert.ErrorAs(t, err, &r)
+		case 2:
+			assert.Error(t, err)
+			assert.False(t, IsPermanent(err))
+		default:
+			assert.NoError(t, err)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig"
+	"github.com/golang/snappy"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	lokiPushPath = "/loki/api/v1/push"
+	// defaultLokiMaxLabelValues is the default number of values of a label before the new ones are replaced
+	defaultLokiMaxLabelValues = 200
+	// lokiOverflowValue replaces the values of a label beyond its max values
+	lokiOverflowValue = "_other"
+
+	LokiCompressionNone   = "none"
+	LokiCompressionGzip   = "gzip"
+	LokiCompressionSnappy = "snappy"
+)
+
+// defaultLokiLabels are the labels of the streams when none are configured
+var defaultLokiLabels = map[string]string{
+	"namespace": "{{ .InvolvedObject.Namespace }}",
+	"kind":      "{{ .InvolvedObject.Kind }}",
+	"reason":    "{{ .Reason }}",
+	"type":      "{{ .Type }}",
+}
+
+// lokiFallbackLabels is the stream of the events whose labels are all empty, since a stream needs a label
+var lokiFallbackLabels = map[string]string{"job": "kubernetes-event-exporter"}
+
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+type LokiConfig struct {
+	// URL of Loki, the push path is added when the URL has no path
+	URL      string `yaml:"url"`
+	TenantID string `yaml:"tenantID"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Labels are the templates of the stream labels, the labels with an empty value are left out
+	Labels map[string]string `yaml:"labels"`
+	// MaxLabelValues is the number of values a label can have, the new values beyond it are replaced by _other
+	MaxLabelValues int `yaml:"maxLabelValues"`
+	// Compression is none (default), gzip for a JSON body, or snappy for a protobuf body
+	Compression string                 `yaml:"compression"`
+	Layout      map[string]interface{} `yaml:"layout"`
+	TLS         TLS                    `yaml:"tls"`
+}
+
+type Loki struct {
+	cfg      *LokiConfig
+	client   *http.Client
+	endpoint string
+	labels   map[string]*template.Template
+	guard    *labelGuard
+}
+
+func NewLoki(cfg *LokiConfig) (*Loki, error) {
+	endpoint, err := url.Parse(cfg.URL)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("loki.url must be a valid URL, not %q", cfg.URL)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = lokiPushPath
+	}
+
+	switch cfg.Compression {
+	case "", LokiCompressionNone, LokiCompressionGzip, LokiCompressionSnappy:
+	default:
+		return nil, fmt.Errorf("loki.compression must be none, gzip or snappy, not %q", cfg.Compression)
+	}
+
+	labels := cfg.Labels
+	if len(labels) == 0 {
+		labels = defaultLokiLabels
+	}
+	templates := make(map[string]*template.Template, len(labels))
+	for name, text := range labels {
+		if !lokiLabelName.MatchString(name) {
+			return nil, fmt.Errorf("loki.labels: invalid label name %q", name)
+		}
+		templates[name], err = template.New(name).Funcs(sprig.TxtFuncMap()).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("loki.labels: label %q: %w", name, err)
+		}
+	}
+
+	maxValues := cfg.MaxLabelValues
+	if maxValues <= 0 {
+		maxValues = defaultLokiMaxLabelValues
+	}
+
+	tlsClientConfig, err := setupTLS(&cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup TLS: %w", err)
+	}
+
+	return &Loki{
+		cfg: cfg,
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsClientConfig,
+		}},
+		endpoint: endpoint.String(),
+		labels:   templates,
+		guard:    newLabelGuard(maxValues),
+	}, nil
+}
+
+// lokiStream is the entries of a label set, key is the label set in the Prometheus format
+type lokiStream struct {
+	key     string
+	labels  map[string]string
+	entries []lokiEntry
+}
+
+type lokiEntry struct {
+	ts   time.Time
+	line string
+	// event is the index of the event in the batch
+	event int
+}
+
+func (l *Loki) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return l.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch pushes the events grouped by stream, with a request for each stream. Loki stores the valid entries of a
+// push and rejects the others with a 400, e.g. the entries that are too old or the streams that are rate limited, so a
+// request per stream keeps a rejection to the events of its stream.
+func (l *Loki) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	streams := make(map[string]*lokiStream)
+	for i, ev := range evs {
+		labels, err := l.streamLabels(ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		line, err := serializeEventWithLayout(l.cfg.Layout, ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+
+		key := lokiLabelsKey(labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{key: key, labels: labels}
+			streams[key] = stream
+		}
+		ts := ev.Time()
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		stream.entries = append(stream.entries, lokiEntry{ts: ts, line: string(line), event: i})
+	}
+
+	for _, stream := range sortedStreams(streams) {
+		sent := make([]int, len(stream.entries))
+		for i, entry := range stream.entries {
+			sent[i] = entry.event
+		}
+		setErrors(errs, sent, l.push(ctx, stream))
+	}
+	return errs
+}
+
+// push sends the entries of a stream
+func (l *Loki) push(ctx context.Context, stream *lokiStream) error {
+	req, err := l.pushRequest(ctx, []*lokiStream{stream})
+	if err != nil {
+		return Permanent(err)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		rb, _ := ioutil.ReadAll(resp.Body)
+		return responseError(resp, rb)
+	}
+	return nil
+}
+
+// streamLabels renders the labels of the event, through the cardinality guard
+func (l *Loki) streamLabels(ev *kube.EnhancedEvent) (map[string]string, error) {
+	labels := make(map[string]string, len(l.labels))
+	for name, tmpl := range l.labels {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, ev); err != nil {
+			return nil, fmt.Errorf("cannot render the label %q: %w", name, err)
+		}
+		if value := buf.String(); value != "" {
+			labels[name] = l.guard.value(name, value)
+		}
+	}
+	if len(labels) == 0 {
+		return lokiFallbackLabels, nil
+	}
+	return labels, nil
+}
+
+func (l *Loki) pushRequest(ctx context.Context, streams []*lokiStream) (*http.Request, error) {
+	var body []byte
+	var err error
+	contentType, contentEncoding := "application/json", ""
+	switch l.cfg.Compression {
+	case LokiCompressionSnappy:
+		body = snappy.Encode(nil, marshalLokiProto(streams))
+		contentType = "application/x-protobuf"
+	case LokiCompressionGzip:
+		body, err = gzipLokiJSON(streams)
+		contentEncoding = "gzip"
+	default:
+		body, err = marshalLokiJSON(streams)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	if l.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.cfg.TenantID)
+	}
+	if l.cfg.Username != "" {
+		req.SetBasicAuth(l.cfg.Username, l.cfg.Password)
+	}
+	return req, nil
+}
+
+func (l *Loki) Close() {
+	l.client.CloseIdleConnections()
+}
+
+// sortedStreams returns the streams in the order of their labels, with their entries in time order
+func sortedStreams(streams map[string]*lokiStream) []*lokiStream {
+	sorted := make([]*lokiStream, 0, len(streams))
+	for _, stream := range streams {
+		entries := stream.entries
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].ts.Before(entries[j].ts)
+		})
+		sorted = append(sorted, stream)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].key < sorted[j].key
+	})
+	return sorted
+}
+
+// lokiLabelsKey formats the labels like Prometheus, e.g. {namespace="default", reason="BackOff"}
+func lokiLabelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(labels[name])
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func marshalLokiJSON(streams []*lokiStream) ([]byte, error) {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	push := struct {
+		Streams []jsonStream `json:"streams"`
+	}{}
+	for _, stream := range streams {
+		s := jsonStream{Stream: stream.labels}
+		for _, entry := range stream.entries {
+			s.Values = append(s.Values, [2]string{strconv.FormatInt(entry.ts.UnixNano(), 10), entry.line})
+		}
+		push.Streams = append(push.Streams, s)
+	}
+	return json.Marshal(push)
+}
+
+func gzipLokiJSON(streams []*lokiStream) ([]byte, error) {
+	body, err := marshalLokiJSON(streams)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalLokiProto encodes the streams as a logproto.PushRequest:
+//
+//	PushRequest { repeated StreamAdapter streams = 1; }
+//	StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func marshalLokiProto(streams []*lokiStream) []byte {
+	var push []byte
+	for _, stream := range streams {
+		var s []byte
+		s = protowire.AppendTag(s, 1, protowire.BytesType)
+		s = protowire.AppendString(s, stream.key)
+		for _, entry := range stream.entries {
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(entry.ts.Unix()))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(entry.ts.Nanosecond()))
+
+			var e []byte
+			e = protowire.AppendTag(e, 1, protowire.BytesType)
+			e = protowire.AppendBytes(e, ts)
+			e = protowire.AppendTag(e, 2, protowire.BytesType)
+			e = protowire.AppendString(e, entry.line)
+
+			s = protowire.AppendTag(s, 2, protowire.BytesType)
+			s = protowire.AppendBytes(s, e)
+		}
+		push = protowire.AppendTag(push, 1, protowire.BytesType)
+		push = protowire.AppendBytes(push, s)
+	}
+	return push
+}
+
+// labelGuard limits the number of values of each label, so that a label like the name of a pod cannot create a
+// stream for each value. The values beyond the limit are replaced by _other.
+type labelGuard struct {
+	max int
+
+	mu     sync.Mutex
+	values map[string]map[string]bool
+}
+
+func newLabelGuard(max int) *labelGuard {
+	return &labelGuard{max: max, values: make(map[string]map[string]bool)}
+}
+
+func (g *labelGuard) value(name, value string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	values, ok := g.values[name]
+	if !ok {
+		values = make(map[string]bool)
+		g.values[name] = values
+	}
+	if values[value] {
+		return value
+	}
+	if len(values) >= g.max {
+		return lokiOverflowValue
+	}
+	values[value] = true
+	if len(values) == g.max {
+		log.Warn().Str("label", name).Int("maxLabelValues", g.max).
+			Msg("Loki label reached its max values, the new values are replaced by " + lokiOverflowValue)
+	}
+	return value
+}
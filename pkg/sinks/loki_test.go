@@ -0,0 +1,224 @@
+package sinks
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type lokiJSONPush struct {
+	Streams []struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	} `json:"streams"`
+}
+
+func TestLokiSendBatchGzip(t *testing.T) {
+	var pushes []lokiJSONPush
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, lokiPushPath, r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "team-a", r.Header.Get("X-Scope-OrgID"))
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "pass", pass)
+
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var push lokiJSONPush
+		require.NoError(t, json.NewDecoder(gz).Decode(&push))
+		pushes = append(pushes, push)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	loki, err := NewLoki(&LokiConfig{
+		URL:         server.URL,
+		TenantID:    "team-a",
+		Username:    "user",
+		Password:    "pass",
+		Compression: LokiCompressionGzip,
+		Labels: map[string]string{
+			"cluster":   "prod",
+			"namespace": "{{ .InvolvedObject.Namespace }}",
+		},
+		Layout: map[string]interface{}{"reason": "{{ .Reason }}"},
+	})
+	require.NoError(t, err)
+
+	t0 := time.Date(2021, 11, 3, 10, 15, 30, 0, time.UTC)
+	backOff := &kube.EnhancedEvent{}
+	backOff.InvolvedObject.Namespace = "default"
+	backOff.Reason = "BackOff"
+	backOff.LastTimestamp = metav1.NewTime(t0.Add(time.Second))
+	pulled := &kube.EnhancedEvent{}
+	pulled.InvolvedObject.Namespace = "kube-system"
+	pulled.Reason = "Pulled"
+	pulled.LastTimestamp = metav1.NewTime(t0)
+	killing := &kube.EnhancedEvent{}
+	killing.InvolvedObject.Namespace = "default"
+	killing.Reason = "Killing"
+	killing.LastTimestamp = metav1.NewTime(t0)
+	errs := loki.SendBatch(context.Background(), []*kube.EnhancedEvent{backOff, pulled, killing})
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// Each stream is pushed in its own request
+	require.Len(t, pushes, 2)
+	require.Len(t, pushes[0].Streams, 1)
+	assert.Equal(t, map[string]string{"cluster": "prod", "namespace": "default"}, pushes[0].Streams[0].Stream)
+	// The entries of a stream are in time order
+	assert.Equal(t, [][2]string{
+		{"1635934530000000000", `{"reason":"Killing"}`},
+		{"1635934531000000000", `{"reason":"BackOff"}`},
+	}, pushes[0].Streams[0].Values)
+	require.Len(t, pushes[1].Streams, 1)
+	assert.Equal(t, "kube-system", pushes[1].Streams[0].Stream["namespace"])
+}
+
+func TestLokiSendBatchRejectedStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var push lokiJSONPush
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&push))
+		if push.Streams[0].Stream["namespace"] == "kube-system" {
+			http.Error(w, "entry for stream '{namespace=\"kube-system\"}' has timestamp too old", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	loki, err := NewLoki(&LokiConfig{
+		URL:    server.URL,
+		Labels: map[string]string{"namespace": "{{ .InvolvedObject.Namespace }}"},
+	})
+	require.NoError(t, err)
+
+	var evs []*kube.EnhancedEvent
+	for _, namespace := range []string{"default", "kube-system", "default"} {
+		ev := &kube.EnhancedEvent{}
+		ev.InvolvedObject.Namespace = namespace
+		evs = append(evs, ev)
+	}
+	errs := loki.SendBatch(context.Background(), evs)
+
+	// Only the events of the rejected stream fail, the others are stored
+	assert.NoError(t, errs[0])
+	assert.True(t, IsPermanent(errs[1]))
+	assert.NoError(t, errs[2])
+}
+
+func TestLokiSendBatchSnappy(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err = snappy.Decode(nil, b)
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	loki, err := NewLoki(&LokiConfig{URL: server.URL, Compression: LokiCompressionSnappy})
+	require.NoError(t, err)
+	ts := time.Date(2021, 11, 3, 10, 15, 30, 500, time.UTC)
+	ev := &kube.EnhancedEvent{}
+	ev.InvolvedObject.Namespace = "default"
+	ev.InvolvedObject.Kind = "Pod"
+	ev.Reason = "BackOff"
+	ev.Type = "Warning"
+	ev.LastTimestamp = metav1.NewTime(ts)
+	require.NoError(t, loki.Send(context.Background(), ev))
+
+	// PushRequest.streams
+	num, typ, n := protowire.ConsumeTag(body)
+	require.Equal(t, protowire.Number(1), num)
+	require.Equal(t, protowire.BytesType, typ)
+	stream, _ := protowire.ConsumeBytes(body[n:])
+
+	// Stream.labels
+	_, _, n = protowire.ConsumeTag(stream)
+	labels, m := protowire.ConsumeString(stream[n:])
+	assert.Equal(t, `{kind="Pod", namespace="default", reason="BackOff", type="Warning"}`, labels)
+	stream = stream[n+m:]
+
+	// Stream.entries
+	num, _, n = protowire.ConsumeTag(stream)
+	require.Equal(t, protowire.Number(2), num)
+	entry, _ := protowire.ConsumeBytes(stream[n:])
+	_, _, n = protowire.ConsumeTag(entry)
+	timestamp, m := protowire.ConsumeBytes(entry[n:])
+	entry = entry[n+m:]
+	_, _, n = protowire.ConsumeTag(timestamp)
+	seconds, m := protowire.ConsumeVarint(timestamp[n:])
+	_, _, n2 := protowire.ConsumeTag(timestamp[n+m:])
+	nanos, _ := protowire.ConsumeVarint(timestamp[n+m+n2:])
+	assert.Equal(t, ts, time.Unix(int64(seconds), int64(nanos)).UTC())
+
+	_, _, n = protowire.ConsumeTag(entry)
+	line, _ := protowire.ConsumeString(entry[n:])
+	assert.Contains(t, line, `"reason":"BackOff"`)
+}
+
+func TestLokiSendErrors(t *testing.T) {
+	status := http.StatusTooManyRequests
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "ingestion rate limit exceeded", status)
+	}))
+	defer server.Close()
+
+	loki, err := NewLoki(&LokiConfig{URL: server.URL})
+	require.NoError(t, err)
+
+	err = loki.Send(context.Background(), &kube.EnhancedEvent{})
+	var rateLimited *RateLimitedError
+	assert.ErrorAs(t, err, &rateLimited)
+
+	status = http.StatusBadGateway
+	err = loki.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.Error(t, err)
+	assert.False(t, IsPermanent(err))
+
+	status = http.StatusBadRequest
+	err = loki.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.True(t, IsPermanent(err))
+}
+
+func TestLokiLabelGuard(t *testing.T) {
+	guard := newLabelGuard(2)
+	assert.Equal(t, "a", guard.value("pod", "a"))
+	assert.Equal(t, "b", guard.value("pod", "b"))
+	assert.Equal(t, lokiOverflowValue, guard.value("pod", "c"))
+	assert.Equal(t, "a", guard.value("pod", "a"))
+	// The limit is per label
+	assert.Equal(t, "c", guard.value("namespace", "c"))
+}
+
+func TestLokiConfig(t *testing.T) {
+	_, err := NewLoki(&LokiConfig{URL: "localhost:3100"})
+	assert.Error(t, err)
+	_, err = NewLoki(&LokiConfig{URL: "http://localhost:3100", Compression: "zstd"})
+	assert.Error(t, err)
+	_, err = NewLoki(&LokiConfig{URL: "http://localhost:3100", Labels: map[string]string{"app.kubernetes.io/name": "x"}})
+	assert.Error(t, err)
+
+	loki, err := NewLoki(&LokiConfig{URL: "http://localhost:3100/"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:3100/loki/api/v1/push", loki.endpoint)
+}
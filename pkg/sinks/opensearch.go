@@ -0,0 +1,234 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+)
+
+const (
+	defaultOpenSearchService = "es"
+	// serverlessOpenSearchService is the service of the OpenSearch Serverless collections
+	serverlessOpenSearchService = "aoss"
+)
+
+type OpenSearchConfig struct {
+	// Connection specific
+	Hosts    []string `yaml:"hosts"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	// AWS signs the requests with SigV4 for Amazon OpenSearch Service, instead of the basic auth
+	AWS *OpenSearchAWSConfig `yaml:"aws"`
+	TLS TLS                  `yaml:"tls"`
+	// Indexing preferences, as in the Elasticsearch sink
+	UseEventID  bool                   `yaml:"useEventID"`
+	DeDot       bool                   `yaml:"deDot"`
+	Index       string                 `yaml:"index"`
+	IndexFormat string                 `yaml:"indexFormat"`
+	Layout      map[string]interface{} `yaml:"layout"`
+	Pipeline    string                 `yaml:"pipeline"`
+	OpType      string                 `yaml:"opType"`
+	Routing     string                 `yaml:"routing"`
+}
+
+// OpenSearchAWSConfig sets the credentials of the SigV4 signature. The static keys are used when they are set, then the
+// role is assumed when it is set, otherwise the credentials come from the default chain, which includes IRSA.
+type OpenSearchAWSConfig struct {
+	Region string `yaml:"region"`
+	// Service is "es" (default) for the managed domains and "aoss" for the serverless collections
+	Service         string `yaml:"service"`
+	AccessKeyID     string `yaml:"accessKeyID"`
+	SecretAccessKey string `yaml:"secretAccessKey"`
+	SessionToken    string `yaml:"sessionToken"`
+	RoleARN         string `yaml:"roleARN"`
+	ExternalID      string `yaml:"externalID"`
+}
+
+type OpenSearch struct {
+	cfg    *OpenSearchConfig
+	client *http.Client
+	bulk   *bulkEncoder
+	// next is the index of the next host, the requests go to the hosts in turn
+	next uint32
+}
+
+func NewOpenSearch(cfg *OpenSearchConfig) (*OpenSearch, error) {
+	if len(cfg.Hosts) == 0 {
+		return nil, errors.New("opensearch.hosts must be non-empty")
+	}
+	if err := validateOpType("opensearch", cfg.OpType); err != nil {
+		return nil, err
+	}
+	if cfg.AWS != nil && cfg.Username != "" {
+		return nil, errors.New("opensearch.aws cannot be used with the basic auth")
+	}
+
+	tlsClientConfig, err := setupTLS(&cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup TLS: %w", err)
+	}
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsClientConfig,
+	}
+	if cfg.AWS != nil {
+		transport, err = newSigV4Transport(transport, cfg.AWS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	o := &OpenSearch{
+		cfg:    cfg,
+		client: &http.Client{Transport: transport},
+		bulk: &bulkEncoder{
+			index:      cfg.Index,
+			opType:     cfg.OpType,
+			useEventID: cfg.UseEventID,
+			routing:    cfg.Routing,
+			deDot:      cfg.DeDot,
+			layout:     cfg.Layout,
+		},
+	}
+	if cfg.IndexFormat != "" {
+		o.bulk.indexFormat, err = parseIndexFormat(cfg.IndexFormat)
+		if err != nil {
+			return nil, fmt.Errorf("opensearch.indexFormat: %w", err)
+		}
+	}
+	return o, nil
+}
+
+func (o *OpenSearch) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return o.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch indexes the events with a single bulk request, each event gets the result of its document
+func (o *OpenSearch) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	body, sent := o.bulk.encode(evs, errs)
+	if len(sent) == 0 {
+		return errs
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.bulkURL(), body)
+	if err != nil {
+		return setErrors(errs, sent, err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if o.cfg.Username != "" {
+		req.SetBasicAuth(o.cfg.Username, o.cfg.Password)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return setErrors(errs, sent, err)
+	}
+	defer resp.Body.Close()
+
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return setErrors(errs, sent, err)
+	}
+	if resp.StatusCode > 299 {
+		return setErrors(errs, sent, responseError(resp, rb))
+	}
+	return bulkResults(rb, errs, sent)
+}
+
+// bulkURL is the bulk endpoint of the next host
+func (o *OpenSearch) bulkURL() string {
+	host := o.cfg.Hosts[int(atomic.AddUint32(&o.next, 1)-1)%len(o.cfg.Hosts)]
+	endpoint := strings.TrimSuffix(host, "/") + "/_bulk"
+	if o.cfg.Pipeline != "" {
+		endpoint += "?pipeline=" + url.QueryEscape(o.cfg.Pipeline)
+	}
+	return endpoint
+}
+
+func (o *OpenSearch) Close() {
+	o.client.CloseIdleConnections()
+}
+
+// sigV4Transport signs the requests with the AWS credentials
+type sigV4Transport struct {
+	base    http.RoundTripper
+	signer  *v4.Signer
+	region  string
+	service string
+}
+
+func newSigV4Transport(base http.RoundTripper, cfg *OpenSearchAWSConfig) (*sigV4Transport, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(cfg.Region)})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		return nil, errors.New("opensearch.aws.region must be set, or the AWS_REGION environment variable")
+	}
+
+	creds := sess.Config.Credentials
+	switch {
+	case cfg.AccessKeyID != "":
+		creds = credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken)
+	case cfg.RoleARN != "":
+		creds = stscreds.NewCredentials(sess, cfg.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if cfg.ExternalID != "" {
+				p.ExternalID = aws.String(cfg.ExternalID)
+			}
+		})
+	}
+
+	service := cfg.Service
+	if service == "" {
+		service = defaultOpenSearchService
+	}
+	return &sigV4Transport{
+		base:    base,
+		signer:  v4.NewSigner(creds),
+		region:  aws.StringValue(sess.Config.Region),
+		service: service,
+	}, nil
+}
+
+func (t *sigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The request of the caller is not changed, the signature is added to a copy
+	signed := req.Clone(req.Context())
+	var b []byte
+	var body io.ReadSeeker
+	if req.Body != nil {
+		var err error
+		b, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	if t.service == serverlessOpenSearchService {
+		// OpenSearch Serverless requires the hash of the body in a header, which the signer sets for S3 only
+		sum := sha256.Sum256(b)
+		signed.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	}
+	if _, err := t.signer.Sign(signed, body, t.service, t.region, time.Now()); err != nil {
+		return nil, fmt.Errorf("cannot sign the request: %w", err)
+	}
+	return t.base.RoundTrip(signed)
+}
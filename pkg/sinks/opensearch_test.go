@@ -0,0 +1,164 @@
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bulkStub is an OpenSearch stub that records the bulk requests and rejects the documents of the given namespace
+type bulkStub struct {
+	requests []*http.Request
+	actions  []map[string]bulkMeta
+	docs     []map[string]interface{}
+	reject   string
+}
+
+func (s *bulkStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests = append(s.requests, r)
+
+	var items []string
+	scanner := bufio.NewScanner(r.Body)
+	for line := 0; scanner.Scan(); line++ {
+		if line%2 == 0 {
+			var action map[string]bulkMeta
+			json.Unmarshal(scanner.Bytes(), &action)
+			s.actions = append(s.actions, action)
+			continue
+		}
+
+		var doc map[string]interface{}
+		json.Unmarshal(scanner.Bytes(), &doc)
+		s.docs = append(s.docs, doc)
+		if doc["metadata"].(map[string]interface{})["namespace"] == s.reject {
+			items = append(items, `{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}`)
+		} else {
+			items = append(items, `{"index":{"status":201}}`)
+		}
+	}
+	fmt.Fprintf(w, `{"errors":true,"items":[%s]}`, strings.Join(items, ","))
+}
+
+func TestOpenSearchBasicAuth(t *testing.T) {
+	stub := &bulkStub{reject: "rejected"}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	o, err := NewOpenSearch(&OpenSearchConfig{
+		Hosts:       []string{server.URL},
+		Username:    "admin",
+		Password:    "secret",
+		IndexFormat: "kube-events-{{ .Namespace }}",
+		Pipeline:    "events",
+		DeDot:       true,
+	})
+	require.NoError(t, err)
+
+	ok := &kube.EnhancedEvent{}
+	ok.Namespace = "default"
+	ok.InvolvedObject.Labels = map[string]string{"app.kubernetes.io/name": "nginx"}
+	rejected := &kube.EnhancedEvent{}
+	rejected.Namespace = "rejected"
+	errs := o.SendBatch(context.Background(), []*kube.EnhancedEvent{ok, rejected})
+
+	assert.NoError(t, errs[0])
+	assert.True(t, IsPermanent(errs[1]))
+
+	require.Len(t, stub.requests, 1)
+	req := stub.requests[0]
+	assert.Equal(t, "/_bulk", req.URL.Path)
+	assert.Equal(t, "events", req.URL.Query().Get("pipeline"))
+	user, password, _ := req.BasicAuth()
+	assert.Equal(t, "admin", user)
+	assert.Equal(t, "secret", password)
+
+	assert.Equal(t, "kube-events-default", stub.actions[0]["index"].Index)
+	labels := stub.docs[0]["involvedObject"].(map[string]interface{})["labels"]
+	assert.Equal(t, map[string]interface{}{"app_kubernetes_io/name": "nginx"}, labels)
+}
+
+func TestOpenSearchSigV4(t *testing.T) {
+	stub := &bulkStub{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	o, err := NewOpenSearch(&OpenSearchConfig{
+		Hosts: []string{server.URL},
+		Index: "kube-events",
+		AWS: &OpenSearchAWSConfig{
+			Region:          "eu-west-1",
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "secret",
+		},
+	})
+	require.NoError(t, err)
+
+	ev := &kube.EnhancedEvent{}
+	ev.Namespace = "default"
+	require.NoError(t, o.Send(context.Background(), ev))
+
+	require.Len(t, stub.requests, 1)
+	req := stub.requests[0]
+	assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"),
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"), req.Header.Get("Authorization"))
+	assert.Contains(t, req.Header.Get("Authorization"), "/eu-west-1/es/aws4_request")
+	assert.NotEmpty(t, req.Header.Get("X-Amz-Date"))
+	assert.Empty(t, req.Header.Get("X-Amz-Content-Sha256"))
+	// The body is sent after it is hashed for the signature
+	require.Len(t, stub.docs, 1)
+	assert.Equal(t, "kube-events", stub.actions[0]["index"].Index)
+}
+
+func TestOpenSearchServerless(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		fmt.Fprint(w, `{"errors":false,"items":[{"index":{"status":201}}]}`)
+	}))
+	defer server.Close()
+
+	o, err := NewOpenSearch(&OpenSearchConfig{
+		Hosts: []string{server.URL},
+		Index: "kube-events",
+		AWS: &OpenSearchAWSConfig{
+			Region:          "eu-west-1",
+			Service:         "aoss",
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "secret",
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, o.Send(context.Background(), &kube.EnhancedEvent{}))
+
+	// The hash of the body is sent in a header, which is part of the signature
+	require.NotEmpty(t, body)
+	sum := sha256.Sum256(body)
+	assert.Equal(t, hex.EncodeToString(sum[:]), header.Get("X-Amz-Content-Sha256"))
+	assert.Contains(t, header.Get("Authorization"), "/eu-west-1/aoss/aws4_request")
+	assert.Contains(t, header.Get("Authorization"), "x-amz-content-sha256")
+}
+
+func TestOpenSearchConfig(t *testing.T) {
+	_, err := NewOpenSearch(&OpenSearchConfig{})
+	assert.Error(t, err)
+	_, err = NewOpenSearch(&OpenSearchConfig{
+		Hosts:    []string{"http://localhost:9200"},
+		Username: "admin",
+		AWS:      &OpenSearchAWSConfig{Region: "eu-west-1"},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,380 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/rs/zerolog/log"
+)
+
+// PrometheusConfig turns the events into metrics, which are served with the metrics of the exporter
+type PrometheusConfig struct {
+	Metrics []PrometheusMetricConfig `yaml:"metrics"`
+}
+
+// PrometheusMetricConfig is a metric derived from the events. The labels are templates over the event.
+type PrometheusMetricConfig struct {
+	Name string `yaml:"name"`
+	Help string `yaml:"help"`
+	// Type is "counter", which counts the events, or "gauge", which is set to the value of each event
+	Type   string            `yaml:"type"`
+	Labels map[string]string `yaml:"labels"`
+	// Value is the template of the value of a gauge, e.g. "{{ .Count }}"
+	Value string `yaml:"value"`
+	// MaxSeries limits the number of label combinations, the events of new combinations are not counted beyond it
+	MaxSeries int `yaml:"maxSeries"`
+	// Expiry removes the series that are not updated for the duration
+	Expiry time.Duration `yaml:"expiry"`
+}
+
+const (
+	prometheusCounter = "counter"
+	prometheusGauge   = "gauge"
+
+	defaultMaxSeries = 1000
+	defaultExpiry    = time.Hour
+)
+
+// reservedPrefixes are the prefixes of the metrics served next to the derived ones: the metrics of the exporter and of
+// the Go and process collectors of the default registry
+var reservedPrefixes = []string{metrics.Prefix, "go_", "process_", "promhttp_"}
+
+func (c *PrometheusMetricConfig) setDefaults() {
+	if c.Type == "" {
+		c.Type = prometheusCounter
+	}
+	if c.Help == "" {
+		c.Help = "Derived from the Kubernetes events"
+	}
+	if c.MaxSeries == 0 {
+		c.MaxSeries = defaultMaxSeries
+	}
+	if c.Expiry == 0 {
+		c.Expiry = defaultExpiry
+	}
+}
+
+type PrometheusSink struct {
+	receiver string
+	metrics  []*derivedMetric
+}
+
+type derivedMetric struct {
+	family     *seriesFamily
+	labelNames []string
+	labels     []*template.Template
+	value      *template.Template
+}
+
+// NewPrometheusSink creates the sink of the receiver. A metric belongs to a single receiver, the names of the metrics
+// defined by the other receivers or by the exporter itself are rejected.
+func NewPrometheusSink(receiver string, cfg *PrometheusConfig) (Sink, error) {
+	if len(cfg.Metrics) == 0 {
+		return nil, fmt.Errorf("prometheus sink must have at least one metric")
+	}
+
+	registerDerivedMetrics()
+
+	sink := &PrometheusSink{receiver: receiver}
+	names := make(map[string]bool, len(cfg.Metrics))
+	for _, m := range cfg.Metrics {
+		if names[m.Name] {
+			sink.Close()
+			return nil, fmt.Errorf("metric %q is defined more than once", m.Name)
+		}
+		names[m.Name] = true
+
+		dm, err := newDerivedMetric(receiver, m)
+		if err != nil {
+			sink.Close()
+			return nil, err
+		}
+		sink.metrics = append(sink.metrics, dm)
+	}
+	return sink, nil
+}
+
+func newDerivedMetric(receiver string, cfg PrometheusMetricConfig) (*derivedMetric, error) {
+	cfg.setDefaults()
+
+	if !model.IsValidMetricName(model.LabelValue(cfg.Name)) {
+		return nil, fmt.Errorf("invalid metric name %q", cfg.Name)
+	}
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(cfg.Name, prefix) {
+			return nil, fmt.Errorf("metric name %q is reserved, it cannot start with %q", cfg.Name, prefix)
+		}
+	}
+
+	dm := &derivedMetric{}
+	for name := range cfg.Labels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q of metric %q", name, cfg.Name)
+		}
+		dm.labelNames = append(dm.labelNames, name)
+	}
+	sort.Strings(dm.labelNames)
+
+	for _, name := range dm.labelNames {
+		tmpl, err := parseTemplate(cfg.Labels[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of label %q of metric %q: %w", name, cfg.Name, err)
+		}
+		dm.labels = append(dm.labels, tmpl)
+	}
+
+	var valueType prometheus.ValueType
+	switch cfg.Type {
+	case prometheusCounter:
+		valueType = prometheus.CounterValue
+	case prometheusGauge:
+		valueType = prometheus.GaugeValue
+		if cfg.Value == "" {
+			return nil, fmt.Errorf("gauge %q must have a value", cfg.Name)
+		}
+		tmpl, err := parseTemplate(cfg.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value template of metric %q: %w", cfg.Name, err)
+		}
+		dm.value = tmpl
+	default:
+		return nil, fmt.Errorf("metric %q has unknown type %q, use %q or %q", cfg.Name, cfg.Type,
+			prometheusCounter, prometheusGauge)
+	}
+
+	family, err := derivedMetrics.acquire(receiver, cfg, prometheus.NewDesc(cfg.Name, cfg.Help, dm.labelNames, nil), valueType)
+	if err != nil {
+		return nil, err
+	}
+	dm.family = family
+	return dm, nil
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("template").Funcs(sprig.TxtFuncMap()).Parse(text)
+}
+
+func execute(tmpl *template.Template, ev *kube.EnhancedEvent) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, ev); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (p *PrometheusSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	for _, m := range p.metrics {
+		values := make([]string, len(m.labels))
+		for i, tmpl := range m.labels {
+			v, err := execute(tmpl, ev)
+			if err != nil {
+				return fmt.Errorf("cannot render label %q of metric %q: %w", m.labelNames[i], m.family.cfg.Name, err)
+			}
+			values[i] = v
+		}
+
+		if m.value == nil {
+			m.family.add(values, 1)
+			continue
+		}
+
+		s, err := execute(m.value, ev)
+		if err != nil {
+			return fmt.Errorf("cannot render the value of metric %q: %w", m.family.cfg.Name, err)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return fmt.Errorf("value of metric %q is not a number: %w", m.family.cfg.Name, err)
+		}
+		m.family.set(values, v)
+	}
+	return nil
+}
+
+func (p *PrometheusSink) Close() {
+	for _, m := range p.metrics {
+		derivedMetrics.release(m.family)
+	}
+	p.metrics = nil
+}
+
+// derivedMetrics holds the series of all Prometheus sinks. It is registered once and the sinks share the series of a
+// metric with the same definition, so that the counters are not reset when the config is reloaded.
+var (
+	derivedMetrics         = &derivedCollector{families: make(map[string]*seriesFamily)}
+	registerDerivedMetrics = func() func() {
+		var once sync.Once
+		return func() {
+			once.Do(func() {
+				prometheus.MustRegister(derivedMetrics)
+			})
+		}
+	}()
+)
+
+type derivedCollector struct {
+	mu       sync.Mutex
+	families map[string]*seriesFamily
+}
+
+type seriesFamily struct {
+	receiver  string
+	cfg       PrometheusMetricConfig
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	refs      int
+
+	mu     sync.Mutex
+	series map[string]*series
+	now    func() time.Time
+}
+
+type series struct {
+	labels  []string
+	value   float64
+	updated time.Time
+}
+
+// acquire returns the series of the metric of the receiver. A metric with a different definition replaces the current
+// one of the receiver, e.g. when the config is reloaded; the sink that still holds the old one keeps updating it until
+// it is closed, but it is not collected anymore. Another receiver can only take over a metric with the same definition,
+// e.g. when the receiver is renamed, the config makes sure that two receivers do not define the same metric.
+func (c *derivedCollector) acquire(receiver string, cfg PrometheusMetricConfig, desc *prometheus.Desc, valueType prometheus.ValueType) (*seriesFamily, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f, ok := c.families[cfg.Name]; ok {
+		same := reflect.DeepEqual(f.cfg, cfg)
+		if f.receiver != receiver && !same {
+			return nil, fmt.Errorf("metric %q is already defined by receiver %q", cfg.Name, f.receiver)
+		}
+		if same {
+			f.receiver = receiver
+			f.refs++
+			return f, nil
+		}
+	}
+
+	f := &seriesFamily{
+		receiver:  receiver,
+		cfg:       cfg,
+		desc:      desc,
+		valueType: valueType,
+		refs:      1,
+		series:    make(map[string]*series),
+		now:       time.Now,
+	}
+	c.families[cfg.Name] = f
+	return f, nil
+}
+
+func (c *derivedCollector) release(f *seriesFamily) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.families[f.cfg.Name] != f {
+		return
+	}
+	f.refs--
+	if f.refs == 0 {
+		delete(c.families, f.cfg.Name)
+	}
+}
+
+// Describe does not send any descriptors, the metrics are defined by the config so the collector is unchecked
+func (c *derivedCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *derivedCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	families := make([]*seriesFamily, 0, len(c.families))
+	for _, f := range c.families {
+		families = append(families, f)
+	}
+	c.mu.Unlock()
+
+	for _, f := range families {
+		f.collect(ch)
+	}
+}
+
+func seriesKey(labels []string) string {
+	return strings.Join(labels, "\xff")
+}
+
+// get returns the series of the labels, or nil if the limit of the series is reached. The caller must hold the lock.
+func (f *seriesFamily) get(labels []string) *series {
+	key := seriesKey(labels)
+	s, ok := f.series[key]
+	if ok {
+		return s
+	}
+
+	f.expire()
+	if len(f.series) >= f.cfg.MaxSeries {
+		metrics.DerivedSeriesLimited.WithLabelValues(f.cfg.Name).Inc()
+		log.Debug().Str("metric", f.cfg.Name).Strs("labels", labels).Msg("Series limit reached, dropping event")
+		return nil
+	}
+
+	s = &series{labels: labels}
+	f.series[key] = s
+	return s
+}
+
+func (f *seriesFamily) add(labels []string, v float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s := f.get(labels); s != nil {
+		s.value += v
+		s.updated = f.now()
+	}
+}
+
+func (f *seriesFamily) set(labels []string, v float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s := f.get(labels); s != nil {
+		s.value = v
+		s.updated = f.now()
+	}
+}
+
+// expire removes the series that are not updated within the expiry. The caller must hold the lock.
+func (f *seriesFamily) expire() {
+	now := f.now()
+	for key, s := range f.series {
+		if now.Sub(s.updated) > f.cfg.Expiry {
+			delete(f.series, key)
+		}
+	}
+}
+
+func (f *seriesFamily) collect(ch chan<- prometheus.Metric) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.expire()
+	for _, s := range f.series {
+		m, err := prometheus.NewConstMetric(f.desc, f.valueType, s.value, s.labels...)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(f.desc, err)
+			continue
+		}
+		ch <- m
+	}
+}
@@ -0,0 +1,187 @@
+package sinks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gatherDerived(t *testing.T) map[string]*dto.MetricFamily {
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(derivedMetrics))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	res := make(map[string]*dto.MetricFamily)
+	for _, f := range families {
+		res[f.GetName()] = f
+	}
+	return res
+}
+
+func testEvent(namespace, reason string, count int32) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Namespace = namespace
+	ev.Reason = reason
+	ev.Count = count
+	ev.InvolvedObject.Kind = "Pod"
+	return ev
+}
+
+func TestPrometheusSink_Counter(t *testing.T) {
+	cfg := &PrometheusConfig{Metrics: []PrometheusMetricConfig{{
+		Name: "test_counter_events_total",
+		Labels: map[string]string{
+			"namespace": "{{ .Namespace }}",
+			"reason":    "{{ .Reason }}",
+			"kind":      "{{ .InvolvedObject.Kind }}",
+		},
+		MaxSeries: 2,
+	}}}
+	sink, err := NewPrometheusSink("metrics", cfg)
+	require.NoError(t, err)
+
+	for _, ev := range []*kube.EnhancedEvent{
+		testEvent("default", "FailedScheduling", 1),
+		testEvent("default", "FailedScheduling", 2),
+		testEvent("kube-system", "BackOff", 1),
+		// Over the series limit
+		testEvent("monitoring", "BackOff", 1),
+	} {
+		require.NoError(t, sink.Send(context.Background(), ev))
+	}
+
+	f := gatherDerived(t)["test_counter_events_total"]
+	require.NotNil(t, f)
+	assert.Equal(t, dto.MetricType_COUNTER, f.GetType())
+	require.Len(t, f.Metric, 2)
+
+	values := make(map[string]float64)
+	for _, m := range f.Metric {
+		labels := make(map[string]string)
+		for _, l := range m.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		assert.Equal(t, "Pod", labels["kind"])
+		values[labels["namespace"]+"/"+labels["reason"]] = m.Counter.GetValue()
+	}
+	assert.Equal(t, map[string]float64{"default/FailedScheduling": 2, "kube-system/BackOff": 1}, values)
+
+	// A sink with the same definition continues the series, e.g. after a reload
+	next, err := NewPrometheusSink("metrics", cfg)
+	require.NoError(t, err)
+	sink.Close()
+	require.NoError(t, next.Send(context.Background(), testEvent("default", "FailedScheduling", 3)))
+	f = gatherDerived(t)["test_counter_events_total"]
+	require.Len(t, f.Metric, 2)
+
+	next.Close()
+	assert.NotContains(t, gatherDerived(t), "test_counter_events_total")
+}
+
+func TestPrometheusSink_GaugeExpiry(t *testing.T) {
+	sink, err := NewPrometheusSink("metrics", &PrometheusConfig{Metrics: []PrometheusMetricConfig{{
+		Name:   "test_gauge_event_count",
+		Type:   "gauge",
+		Labels: map[string]string{"namespace": "{{ .Namespace }}"},
+		Value:  "{{ .Count }}",
+		Expiry: time.Minute,
+	}}})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	family := sink.(*PrometheusSink).metrics[0].family
+	now := time.Now()
+	family.now = func() time.Time { return now }
+
+	require.NoError(t, sink.Send(context.Background(), testEvent("default", "BackOff", 5)))
+	require.NoError(t, sink.Send(context.Background(), testEvent("default", "BackOff", 7)))
+
+	f := gatherDerived(t)["test_gauge_event_count"]
+	require.Len(t, f.Metric, 1)
+	assert.Equal(t, float64(7), f.Metric[0].Gauge.GetValue())
+
+	now = now.Add(2 * time.Minute)
+	assert.NotContains(t, gatherDerived(t), "test_gauge_event_count")
+}
+
+func TestPrometheusSink_Rename(t *testing.T) {
+	cfg := &PrometheusConfig{Metrics: []PrometheusMetricConfig{{Name: "test_rename_events_total"}}}
+	sink, err := NewPrometheusSink("metrics", cfg)
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(context.Background(), testEvent("default", "BackOff", 1)))
+
+	// The renamed receiver takes over the series while the old one is still open, as during a reload
+	renamed, err := NewPrometheusSink("events", cfg)
+	require.NoError(t, err)
+	defer renamed.Close()
+	sink.Close()
+	require.NoError(t, renamed.Send(context.Background(), testEvent("default", "BackOff", 1)))
+
+	f := gatherDerived(t)["test_rename_events_total"]
+	require.Len(t, f.Metric, 1)
+	assert.Equal(t, float64(2), f.Metric[0].Counter.GetValue())
+
+	// The old receiver cannot define the metric differently anymore
+	_, err = NewPrometheusSink("metrics", &PrometheusConfig{Metrics: []PrometheusMetricConfig{
+		{Name: "test_rename_events_total", Labels: map[string]string{"reason": "{{ .Reason }}"}},
+	}})
+	assert.Error(t, err)
+}
+
+func TestPrometheusSink_InvalidConfig(t *testing.T) {
+	for _, m := range []PrometheusMetricConfig{
+		{Name: "invalid-name"},
+		{Name: "test_invalid", Type: "histogram"},
+		{Name: "test_invalid", Type: "gauge"},
+		{Name: "test_invalid", Labels: map[string]string{"__name": "x"}},
+		{Name: "test_invalid", Labels: map[string]string{"namespace": "{{ .Namespace"}},
+		// The metrics of the exporter and of the Go runtime are served next to the derived ones
+		{Name: "event_exporter_events_received_total"},
+		{Name: "go_goroutines"},
+	} {
+		_, err := NewPrometheusSink("metrics", &PrometheusConfig{Metrics: []PrometheusMetricConfig{m}})
+		assert.Error(t, err, m)
+	}
+}
+
+func TestPrometheusSink_Conflicts(t *testing.T) {
+	cfg := &PrometheusConfig{Metrics: []PrometheusMetricConfig{{Name: "test_conflict_events_total"}}}
+	sink, err := NewPrometheusSink("metrics", cfg)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	// Another receiver cannot define the metric differently
+	_, err = NewPrometheusSink("other", &PrometheusConfig{Metrics: []PrometheusMetricConfig{
+		{Name: "test_conflict_events_total", Type: "gauge", Value: "{{ .Count }}"},
+	}})
+	assert.Error(t, err)
+
+	// A receiver cannot define the metric twice
+	_, err = NewPrometheusSink("other", &PrometheusConfig{Metrics: []PrometheusMetricConfig{
+		{Name: "test_conflict_other_total"},
+		{Name: "test_conflict_other_total", Labels: map[string]string{"reason": "{{ .Reason }}"}},
+	}})
+	assert.Error(t, err)
+	// The metrics of the failed sink are released
+	other, err := NewPrometheusSink("other", &PrometheusConfig{Metrics: []PrometheusMetricConfig{{Name: "test_conflict_other_total"}}})
+	require.NoError(t, err)
+	other.Close()
+
+	// The receiver itself can change the definition when the config is reloaded
+	next, err := NewPrometheusSink("metrics", &PrometheusConfig{Metrics: []PrometheusMetricConfig{
+		{Name: "test_conflict_events_total", Labels: map[string]string{"reason": "{{ .Reason }}"}},
+	}})
+	require.NoError(t, err)
+	defer next.Close()
+	require.NoError(t, next.Send(context.Background(), testEvent("default", "BackOff", 1)))
+	f := gatherDerived(t)["test_conflict_events_total"]
+	require.Len(t, f.Metric, 1)
+	assert.Equal(t, "reason", f.Metric[0].Label[0].GetName())
+}
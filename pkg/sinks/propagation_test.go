@@ -0,0 +1,59 @@
+package sinks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func tracedContext(t *testing.T) context.Context {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	return trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+}
+
+const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestWebhook_PropagatesTraceContext(t *testing.T) {
+	var header string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	sink, err := NewWebhook(&WebhookConfig{Endpoint: srv.URL})
+	require.NoError(t, err)
+
+	ev := &kube.EnhancedEvent{}
+	ev.Message = "Pulled image"
+	require.NoError(t, sink.Send(tracedContext(t), ev))
+	assert.Equal(t, traceParent, header)
+}
+
+func TestKafkaHeaderCarrier(t *testing.T) {
+	var headers kafkaHeaderCarrier
+	propagation.TraceContext{}.Inject(tracedContext(t), &headers)
+
+	require.Len(t, headers, 1)
+	assert.Equal(t, sarama.RecordHeader{Key: []byte("traceparent"), Value: []byte(traceParent)}, headers[0])
+	assert.Equal(t, traceParent, headers.Get("traceparent"))
+	assert.Equal(t, []string{"traceparent"}, headers.Keys())
+}
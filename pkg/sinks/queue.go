@@ -0,0 +1,113 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The overflow policies decide what happens to an event when the queue of the receiver is full
+const (
+	// OverflowBlock waits until there is room in the queue, which slows down the routing of all events
+	OverflowBlock = "block"
+	// OverflowDropOldest drops the oldest event in the queue to make room for the new one, it is the default so that a
+	// receiver that keeps failing does not hold up the others
+	OverflowDropOldest = "dropOldest"
+	// OverflowDropNewest drops the new event
+	OverflowDropNewest = "dropNewest"
+)
+
+const (
+	defaultQueueCapacity = 1000
+	defaultQueueWorkers  = 1
+
+	defaultBatchSize          = 100
+	defaultBatchMaxBytes      = 1 << 20
+	defaultBatchFlushInterval = time.Second
+
+	defaultPersistenceMaxBytes = 256 << 20
+	defaultPersistenceMaxAge   = 24 * time.Hour
+)
+
+// QueueConfig sets how the events wait to be sent by a receiver. The events are sent by Workers goroutines, and the
+// events of the same involved object are always sent by the same worker, so that their order is kept. Each worker has
+// its share of the Capacity.
+type QueueConfig struct {
+	Capacity int    `yaml:"capacity"`
+	Overflow string `yaml:"overflow"`
+	// Workers more than 1 should only be used for the sinks that can send concurrently
+	Workers int `yaml:"workers"`
+	// Batch is used when the sink can send batches
+	Batch BatchConfig `yaml:"batch"`
+	// Persistence keeps the queued events on the disk until they are sent
+	Persistence PersistenceConfig `yaml:"persistence"`
+}
+
+// BatchConfig sets how the queued events are batched for a BatchSink. A batch is sent when it has Size events, when
+// adding the next event would make its JSON bigger than MaxBytes or FlushInterval after its first event.
+type BatchConfig struct {
+	Size          int           `yaml:"size"`
+	MaxBytes      int           `yaml:"maxBytes"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+}
+
+// PersistenceConfig keeps the queued events of a receiver in a write-ahead log, so that the events that are not sent
+// yet are sent after a restart. When the log reaches MaxBytes, or its oldest events are older than MaxAge, the oldest
+// events are dropped.
+type PersistenceConfig struct {
+	// Directory enables the persistence, the log of the receiver is in a sub-directory named after the receiver
+	Directory string        `yaml:"directory"`
+	MaxBytes  int64         `yaml:"maxBytes"`
+	MaxAge    time.Duration `yaml:"maxAge"`
+}
+
+// Enabled reports whether the queued events are kept on the disk
+func (c *PersistenceConfig) Enabled() bool {
+	return c.Directory != ""
+}
+
+func (c *QueueConfig) Validate() error {
+	if c.Capacity < 0 || c.Workers < 0 {
+		return errors.New("queue capacity and workers cannot be negative")
+	}
+	if c.Batch.Size < 0 || c.Batch.MaxBytes < 0 || c.Batch.FlushInterval < 0 {
+		return errors.New("queue batch settings cannot be negative")
+	}
+	if c.Persistence.MaxBytes < 0 || c.Persistence.MaxAge < 0 {
+		return errors.New("queue persistence limits cannot be negative")
+	}
+	switch c.Overflow {
+	case "", OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+		return nil
+	default:
+		return fmt.Errorf("unknown queue overflow policy %q", c.Overflow)
+	}
+}
+
+// SetDefaults fills the unset capacity, overflow policy, workers and the limits of the batches and the log
+func (c *QueueConfig) SetDefaults() {
+	if c.Capacity == 0 {
+		c.Capacity = defaultQueueCapacity
+	}
+	if c.Overflow == "" {
+		c.Overflow = OverflowDropOldest
+	}
+	if c.Workers == 0 {
+		c.Workers = defaultQueueWorkers
+	}
+	if c.Batch.Size == 0 {
+		c.Batch.Size = defaultBatchSize
+	}
+	if c.Batch.MaxBytes == 0 {
+		c.Batch.MaxBytes = defaultBatchMaxBytes
+	}
+	if c.Batch.FlushInterval == 0 {
+		c.Batch.FlushInterval = defaultBatchFlushInterval
+	}
+	if c.Persistence.MaxBytes == 0 {
+		c.Persistence.MaxBytes = defaultPersistenceMaxBytes
+	}
+	if c.Persistence.MaxAge == 0 {
+		c.Persistence.MaxAge = defaultPersistenceMaxAge
+	}
+}
@@ -1,6 +1,10 @@
 package sinks
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
 
 // Receiver allows receiving
 type ReceiverConfig struct {
@@ -11,6 +15,7 @@ type ReceiverConfig struct {
 	Syslog        *SyslogConfig        `yaml:"syslog"`
 	Stdout        *StdoutConfig        `yaml:"stdout"`
 	Elasticsearch *ElasticsearchConfig `yaml:"elasticsearch"`
+	OpenSearch    *OpenSearchConfig    `yaml:"opensearch"`
 	Kinesis       *KinesisConfig       `yaml:"kinesis"`
 	Firehose      *FirehoseConfig      `yaml:"firehose"`
 	Opsgenie      *OpsgenieConfig      `yaml:"opsgenie"`
@@ -24,12 +29,61 @@ type ReceiverConfig struct {
 	BigQuery      *BigQueryConfig      `yaml:"bigquery"`
 	EventBridge   *EventBridgeConfig   `yaml:"eventbridge"`
 	Pipe          *PipeConfig          `yaml:"pipe"`
+	Prometheus    *PrometheusConfig    `yaml:"prometheus"`
+	Loki          *LokiConfig          `yaml:"loki"`
+	Splunk        *SplunkConfig        `yaml:"splunk"`
+	Redis         *RedisConfig         `yaml:"redis"`
+
+	// DeadLetter is the receiver that gets the events this receiver fails to send
+	DeadLetter string `yaml:"deadLetter"`
+	// Retry is the policy for sending the event again when the sink fails
+	Retry RetryConfig `yaml:"retry"`
+	// CircuitBreaker stops sending to the sink while it keeps failing
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuitBreaker"`
+	// Queue holds the events until the sink sends them
+	Queue QueueConfig `yaml:"queue"`
 }
 
 func (r *ReceiverConfig) Validate() error {
+	if r.Name == "" {
+		return errors.New("receiver name must be non-empty")
+	}
+
+	// Each sink is a pointer field, exactly one of them has to be set
+	var sinks int
+	v := reflect.ValueOf(r).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.Ptr && !f.IsNil() {
+			sinks++
+		}
+	}
+	if sinks != 1 {
+		return fmt.Errorf("receiver %q must have exactly one sink, has %d", r.Name, sinks)
+	}
+	if err := r.Retry.Validate(); err != nil {
+		return fmt.Errorf("receiver %q: %w", r.Name, err)
+	}
+	if err := r.CircuitBreaker.Validate(); err != nil {
+		return fmt.Errorf("receiver %q: %w", r.Name, err)
+	}
+	if err := r.Queue.Validate(); err != nil {
+		return fmt.Errorf("receiver %q: %w", r.Name, err)
+	}
 	return nil
 }
 
+// Links returns the receivers that this receiver passes events to, i.e. its dead letter and its fallback
+func (r *ReceiverConfig) Links() []string {
+	var links []string
+	if r.DeadLetter != "" {
+		links = append(links, r.DeadLetter)
+	}
+	if r.CircuitBreaker.Fallback != "" {
+		links = append(links, r.CircuitBreaker.Fallback)
+	}
+	return links
+}
+
 func (r *ReceiverConfig) GetSink() (Sink, error) {
 	if r.InMemory != nil {
 		// This reference is used for test purposes to count the events in the sink.
@@ -64,6 +118,10 @@ func (r *ReceiverConfig) GetSink() (Sink, error) {
 		return NewElasticsearch(r.Elasticsearch)
 	}
 
+	if r.OpenSearch != nil {
+		return NewOpenSearch(r.OpenSearch)
+	}
+
 	if r.Kinesis != nil {
 		return NewKinesisSink(r.Kinesis)
 	}
@@ -112,5 +170,21 @@ func (r *ReceiverConfig) GetSink() (Sink, error) {
 		return NewEventBridgeSink(r.EventBridge)
 	}
 
+	if r.Prometheus != nil {
+		return NewPrometheusSink(r.Name, r.Prometheus)
+	}
+
+	if r.Loki != nil {
+		return NewLoki(r.Loki)
+	}
+
+	if r.Splunk != nil {
+		return NewSplunk(r.Splunk)
+	}
+
+	if r.Redis != nil {
+		return NewRedis(r.Redis)
+	}
+
 	return nil, errors.New("unknown sink")
 }
@@ -1 +1,228 @@
 package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+)
+
+const defaultRedisKey = "event"
+
+type RedisConfig struct {
+	// Addrs are the addresses of the server, of the sentinels when MasterName is set or of the seed nodes of a cluster
+	Addrs []string `yaml:"addrs"`
+	// MasterName is the name of the master monitored by the sentinels
+	MasterName       string `yaml:"masterName"`
+	SentinelUsername string `yaml:"sentinelUsername"`
+	SentinelPassword string `yaml:"sentinelPassword"`
+	// Cluster connects to a Redis Cluster
+	Cluster bool `yaml:"cluster"`
+	// Username and Password are the ACL user, the password alone is the legacy AUTH
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	// TLS enables TLS when it is set
+	TLS *TLS `yaml:"tls"`
+	// Stream is a template of the stream that the events are added to with XADD
+	Stream string `yaml:"stream"`
+	// MaxLen trims the stream to about this many entries, or exactly with ExactMaxLen
+	MaxLen      int64 `yaml:"maxLen"`
+	ExactMaxLen bool  `yaml:"exactMaxLen"`
+	// Key is a template of the field of the stream entries that holds the event, "event" by default
+	Key string `yaml:"key"`
+	// Channel is a template of the channel that the events are published to, instead of a stream
+	Channel string                 `yaml:"channel"`
+	Layout  map[string]interface{} `yaml:"layout"`
+}
+
+type Redis struct {
+	cfg    *RedisConfig
+	client redis.UniversalClient
+}
+
+func NewRedis(cfg *RedisConfig) (*Redis, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, errors.New("redis.addrs must be non-empty")
+	}
+	if (cfg.Stream == "") == (cfg.Channel == "") {
+		return nil, errors.New("redis must have either a stream or a channel")
+	}
+	if cfg.Channel != "" && (cfg.MaxLen != 0 || cfg.Key != "") {
+		return nil, errors.New("redis.maxLen and redis.key apply to a stream only")
+	}
+	if cfg.MaxLen < 0 {
+		return nil, errors.New("redis.maxLen must not be negative")
+	}
+	if cfg.MasterName != "" && cfg.Cluster {
+		return nil, errors.New("redis.masterName cannot be used with redis.cluster")
+	}
+	if cfg.Cluster && cfg.DB != 0 {
+		return nil, errors.New("redis.db cannot be used with redis.cluster")
+	}
+
+	client, err := newRedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Redis{cfg: cfg, client: client}, nil
+}
+
+func newRedisClient(cfg *RedisConfig) (redis.UniversalClient, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		MasterName:       cfg.MasterName,
+		SentinelUsername: cfg.SentinelUsername,
+		SentinelPassword: cfg.SentinelPassword,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		DB:               cfg.DB,
+	}
+	if cfg.TLS != nil {
+		tlsClientConfig, err := setupTLS(cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup TLS: %w", err)
+		}
+		opts.TLSConfig = tlsClientConfig
+	}
+
+	switch {
+	case cfg.MasterName != "":
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case cfg.Cluster:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	case len(cfg.Addrs) > 1:
+		return nil, errors.New("redis.addrs must have a single address, unless redis.masterName or redis.cluster is set")
+	default:
+		return redis.NewClient(opts.Simple()), nil
+	}
+}
+
+func (r *Redis) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return r.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch sends the commands of the events in a pipeline, each event gets the result of its command. With a cluster,
+// the pipeline is split across the nodes of the keys.
+func (r *Redis) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	cmds := make([]redis.Cmder, len(evs))
+	pipe := r.client.Pipeline()
+	for i, ev := range evs {
+		cmd, err := r.command(ctx, pipe, ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		cmds[i] = cmd
+	}
+	if pipe.Len() == 0 {
+		return errs
+	}
+
+	// The error of Exec is the first error of the commands, each command has its own
+	_, _ = pipe.Exec(ctx)
+	for i, cmd := range cmds {
+		if cmd != nil && cmd.Err() != nil {
+			errs[i] = redisError(cmd.Err())
+		}
+	}
+	return errs
+}
+
+// command queues the XADD or the PUBLISH of the event in the pipeline
+func (r *Redis) command(ctx context.Context, pipe redis.Pipeliner, ev *kube.EnhancedEvent) (redis.Cmder, error) {
+	doc, err := serializeEventWithLayout(r.cfg.Layout, ev)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.cfg.Channel != "" {
+		channel, err := renderRedisName(ev, "channel", r.cfg.Channel)
+		if err != nil {
+			return nil, err
+		}
+		return pipe.Publish(ctx, channel, doc), nil
+	}
+
+	stream, err := renderRedisName(ev, "stream", r.cfg.Stream)
+	if err != nil {
+		return nil, err
+	}
+	key := defaultRedisKey
+	if r.cfg.Key != "" {
+		if key, err = renderRedisName(ev, "key", r.cfg.Key); err != nil {
+			return nil, err
+		}
+	}
+	args := &redis.XAddArgs{
+		Stream: stream,
+		Values: []interface{}{key, doc},
+	}
+	if r.cfg.MaxLen > 0 {
+		// The approximate trimming removes whole nodes of the stream only, which is much cheaper
+		args.MaxLen = r.cfg.MaxLen
+		args.Approx = !r.cfg.ExactMaxLen
+	}
+	return pipe.XAdd(ctx, args), nil
+}
+
+// renderRedisName renders a template of a stream, a channel or a key, which cannot be empty
+func renderRedisName(ev *kube.EnhancedEvent, name, text string) (string, error) {
+	value, err := GetString(ev, text)
+	if err != nil {
+		return "", fmt.Errorf("cannot render the %s: %w", name, err)
+	}
+	if value == "" {
+		return "", fmt.Errorf("the %s %q is empty for the event", name, text)
+	}
+	return value, nil
+}
+
+// redisPermanentErrors are the prefixes of the ERR replies about the command or its arguments, like the IDs and the
+// MAXLEN of XADD, which fail again when the command is retried. The other ERR replies, like "ERR max number of clients
+// reached", are retried.
+var redisPermanentErrors = []string{
+	"syntax error",
+	"wrong number of arguments",
+	"value is not an integer",
+	"value is out of range",
+	"invalid stream id",
+	"the id specified in xadd",
+	"the maxlen argument",
+	"unknown command",
+	"unknown subcommand",
+}
+
+// redisError classifies the error of a command. The errors of the server about the command, the data or the
+// permissions are permanent, the others, like the network errors, a failover in progress or an authentication error
+// while the password is rotated, are retried.
+func redisError(err error) error {
+	var redisErr redis.Error
+	if !errors.As(err, &redisErr) || err == redis.Nil {
+		return err
+	}
+	parts := strings.SplitN(redisErr.Error(), " ", 2)
+	switch parts[0] {
+	case "WRONGTYPE", "NOPERM":
+		return Permanent(err)
+	case "ERR":
+		if len(parts) < 2 {
+			return err
+		}
+		message := strings.ToLower(parts[1])
+		for _, prefix := range redisPermanentErrors {
+			if strings.HasPrefix(message, prefix) {
+				return Permanent(err)
+			}
+		}
+	}
+	return err
+}
+
+func (r *Redis) Close() {
+	_ = r.client.Close()
+}
@@ -0,0 +1,165 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func redisEvent(namespace, reason string) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.InvolvedObject.Namespace = namespace
+	ev.Reason = reason
+	return ev
+}
+
+func TestRedisStream(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireUserAuth("exporter", "secret")
+
+	sink, err := NewRedis(&RedisConfig{
+		Addrs:       []string{server.Addr()},
+		Username:    "exporter",
+		Password:    "secret",
+		Stream:      "events:{{ .InvolvedObject.Namespace }}",
+		MaxLen:      2,
+		ExactMaxLen: true,
+		Layout:      map[string]interface{}{"reason": "{{ .Reason }}"},
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	errs := sink.SendBatch(context.Background(), []*kube.EnhancedEvent{
+		redisEvent("default", "Pulled"),
+		redisEvent("default", "Created"),
+		redisEvent("default", "Started"),
+		redisEvent("kube-system", "BackOff"),
+	})
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// The stream is trimmed to the last entries
+	entries, err := server.Stream("events:default")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, []string{"event", `{"reason":"Created"}`}, entries[0].Values)
+	assert.Equal(t, []string{"event", `{"reason":"Started"}`}, entries[1].Values)
+
+	entries, err = server.Stream("events:kube-system")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestRedisChannel(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	sink, err := NewRedis(&RedisConfig{
+		Addrs:   []string{server.Addr()},
+		Channel: "events.{{ .InvolvedObject.Namespace }}",
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	sub := client.Subscribe(context.Background(), "events.default")
+	defer sub.Close()
+	_, err = sub.Receive(context.Background())
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Send(context.Background(), redisEvent("default", "BackOff")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := sub.ReceiveMessage(ctx)
+	require.NoError(t, err)
+	var ev kube.EnhancedEvent
+	require.NoError(t, json.Unmarshal([]byte(msg.Payload), &ev))
+	assert.Equal(t, "BackOff", ev.Reason)
+}
+
+func TestRedisErrors(t *testing.T) {
+	server := miniredis.RunT(t)
+	require.NoError(t, server.Set("events", "not a stream"))
+
+	sink, err := NewRedis(&RedisConfig{Addrs: []string{server.Addr()}, Stream: "events"})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	err = sink.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.True(t, IsPermanent(err))
+
+	// The stream of an event cannot be empty
+	sink.cfg.Stream = "{{ .InvolvedObject.Namespace }}"
+	err = sink.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.True(t, IsPermanent(err))
+
+	// The server is down
+	sink.cfg.Stream = "events"
+	server.Close()
+	err = sink.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.Error(t, err)
+	assert.False(t, IsPermanent(err))
+}
+
+func TestRedisConfig(t *testing.T) {
+	for _, cfg := range []*RedisConfig{
+		{Stream: "events"},
+		{Addrs: []string{"localhost:6379"}},
+		{Addrs: []string{"localhost:6379"}, Stream: "events", Channel: "events"},
+		{Addrs: []string{"localhost:6379"}, Channel: "events", MaxLen: 100},
+		{Addrs: []string{"localhost:6379", "localhost:6380"}, Stream: "events"},
+		{Addrs: []string{"localhost:26379"}, MasterName: "mymaster", Cluster: true, Stream: "events"},
+	} {
+		_, err := NewRedis(cfg)
+		assert.Error(t, err)
+	}
+
+	sink, err := NewRedis(&RedisConfig{Addrs: []string{"localhost:7000", "localhost:7001"}, Cluster: true, Stream: "events"})
+	require.NoError(t, err)
+	assert.IsType(t, &redis.ClusterClient{}, sink.client)
+	sink.Close()
+
+	sink, err = NewRedis(&RedisConfig{Addrs: []string{"localhost:26379"}, MasterName: "mymaster", Stream: "events"})
+	require.NoError(t, err)
+	assert.IsType(t, &redis.Client{}, sink.client)
+	sink.Close()
+}
+
+// redisReply is an error reply of the server
+type redisReply string
+
+func (e redisReply) Error() string { return string(e) }
+
+func (redisReply) RedisError() {}
+
+func TestRedisErrorReplies(t *testing.T) {
+	for reply, permanent := range map[string]bool{
+		"WRONGTYPE Operation against a key holding the wrong kind of value": true,
+		"NOPERM this user has no permissions to run the 'xadd' command":     true,
+		"NOAUTH Authentication required.":                                   false,
+		"WRONGPASS invalid username-password pair or user is disabled.":     false,
+		"ERR syntax error": true,
+		"ERR wrong number of arguments for 'xadd' command":                                 true,
+		"ERR value is not an integer or out of range":                                      true,
+		"ERR Invalid stream ID specified as stream command argument":                       true,
+		"ERR The ID specified in XADD is equal or smaller than the target stream top item": true,
+		"ERR invalid password":              false,
+		"ERR max number of clients reached": false,
+		"ERR":                               false,
+		"LOADING Redis is loading the dataset in memory":        false,
+		"READONLY You can't write against a read only replica.": false,
+		"CLUSTERDOWN The cluster is down":                       false,
+	} {
+		assert.Equal(t, permanent, IsPermanent(redisError(redisReply(reply))), reply)
+	}
+	assert.False(t, IsPermanent(redisError(redis.Nil)))
+}
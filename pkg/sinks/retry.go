@@ -0,0 +1,63 @@
+package sinks
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultRetryInitialInterval = time.Second
+	defaultRetryMaxInterval     = 30 * time.Second
+	defaultRetryMultiplier      = 2
+)
+
+// RetryConfig is the retry policy of a receiver. The failed sends are retried with an exponential backoff until
+// MaxAttempts is reached or the next attempt would start after MaxElapsedTime. The permanent errors are not retried.
+type RetryConfig struct {
+	// MaxAttempts includes the first attempt, 0 and 1 disable the retries
+	MaxAttempts     int           `yaml:"maxAttempts"`
+	InitialInterval time.Duration `yaml:"initialInterval"`
+	MaxInterval     time.Duration `yaml:"maxInterval"`
+	Multiplier      float64       `yaml:"multiplier"`
+	// Jitter randomizes each interval by the given fraction, e.g. 0.5 waits between 50% and 150% of the interval
+	Jitter float64 `yaml:"jitter"`
+	// MaxElapsedTime limits the total time spent on an event, 0 means no limit
+	MaxElapsedTime time.Duration `yaml:"maxElapsedTime"`
+}
+
+// Enabled reports whether a failed send is attempted again
+func (c *RetryConfig) Enabled() bool {
+	return c.MaxAttempts > 1
+}
+
+func (c *RetryConfig) Validate() error {
+	if c.MaxAttempts < 0 {
+		return errors.New("retry maxAttempts cannot be negative")
+	}
+	if c.InitialInterval < 0 || c.MaxInterval < 0 || c.MaxElapsedTime < 0 {
+		return errors.New("retry intervals cannot be negative")
+	}
+	if c.Multiplier != 0 && c.Multiplier < 1 {
+		return errors.New("retry multiplier must be at least 1")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return errors.New("retry jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// SetDefaults fills the unset intervals and the multiplier
+func (c *RetryConfig) SetDefaults() {
+	if c.InitialInterval == 0 {
+		c.InitialInterval = defaultRetryInitialInterval
+	}
+	if c.MaxInterval == 0 {
+		c.MaxInterval = defaultRetryMaxInterval
+	}
+	if c.MaxInterval < c.InitialInterval {
+		c.MaxInterval = c.InitialInterval
+	}
+	if c.Multiplier == 0 {
+		c.Multiplier = defaultRetryMultiplier
+	}
+}
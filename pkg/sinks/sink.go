@@ -12,18 +12,29 @@ import (
 )
 
 // Sink is the interface that the third-party providers should implement. It should just get the event and
-// transform it depending on its configuration and submit it. Error handling for retries etc. should be handled inside
-// for now.
+// transform it depending on its configuration and submit it. The failed sends are retried by the receiver registry
+// according to the retry config of the receiver, a sink can return a Permanent error to prevent it or a RateLimited
+// one to slow it down.
 type Sink interface {
 	Send(ctx context.Context, ev *kube.EnhancedEvent) error
 	Close()
 }
 
-// BatchSink is an extension Sink that can handle batch events.
-// NOTE: Currently no provider implements it nor the receivers can handle it.
+// BatchSink is an extension Sink that can send many events at once. The receiver registry batches the events of such
+// sinks according to the batch config of the receiver queue.
 type BatchSink interface {
 	Sink
-	SendBatch([]*kube.EnhancedEvent) error
+	// SendBatch returns the result of each event in the same order, nil for the events that are sent
+	SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error
+}
+
+// batchErrors returns the error as the result of all events of a batch
+func batchErrors(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
 }
 
 type TLS struct {
@@ -1 +1,327 @@
 package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+)
+
+const (
+	splunkEventPath = "/services/collector/event"
+	splunkAckPath   = "/services/collector/ack"
+
+	defaultSplunkAckTimeout      = time.Minute
+	defaultSplunkAckPollInterval = time.Second
+)
+
+type SplunkConfig struct {
+	// URL of the HTTP Event Collector, the event path is added when the URL has no path
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+	// Index, Source, SourceType and Host are templates of the metadata of the events, the empty ones are left to the
+	// defaults of the token
+	Index      string                 `yaml:"index"`
+	Source     string                 `yaml:"source"`
+	SourceType string                 `yaml:"sourcetype"`
+	Host       string                 `yaml:"host"`
+	Layout     map[string]interface{} `yaml:"layout"`
+	Gzip       bool                   `yaml:"gzip"`
+	TLS        TLS                    `yaml:"tls"`
+	// Ack waits for the indexer acknowledgement of each request, the token must have it enabled
+	Ack *SplunkAckConfig `yaml:"ack"`
+}
+
+// SplunkAckConfig sets the polling of the indexer acknowledgements
+type SplunkAckConfig struct {
+	// Channel is the GUID of the channel of the requests, a random one is used when it is empty
+	Channel      string        `yaml:"channel"`
+	Timeout      time.Duration `yaml:"timeout"`
+	PollInterval time.Duration `yaml:"pollInterval"`
+}
+
+type Splunk struct {
+	cfg      *SplunkConfig
+	client   *http.Client
+	endpoint string
+	ackURL   string
+	channel  string
+}
+
+// splunkEvent is an event in the HEC format
+type splunkEvent struct {
+	Time       float64         `json:"time"`
+	Host       string          `json:"host,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	SourceType string          `json:"sourcetype,omitempty"`
+	Index      string          `json:"index,omitempty"`
+	Event      json.RawMessage `json:"event"`
+}
+
+// splunkResponse is the response of the HEC, the code 0 is a success
+type splunkResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckID *int64 `json:"ackId"`
+}
+
+func NewSplunk(cfg *SplunkConfig) (*Splunk, error) {
+	if cfg.Token == "" {
+		return nil, errors.New("splunk.token must be non-empty")
+	}
+	endpoint, err := url.Parse(cfg.URL)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("splunk.url must be a valid URL, not %q", cfg.URL)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = splunkEventPath
+	}
+	// The acknowledgements are next to the event endpoint, e.g. behind a proxy path
+	ack := *endpoint
+	ack.Path = splunkAckPath
+	if strings.HasSuffix(endpoint.Path, "/event") {
+		ack.Path = strings.TrimSuffix(endpoint.Path, "/event") + "/ack"
+	}
+
+	tlsClientConfig, err := setupTLS(&cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup TLS: %w", err)
+	}
+
+	s := &Splunk{
+		cfg: cfg,
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsClientConfig,
+		}},
+		endpoint: endpoint.String(),
+		ackURL:   ack.String(),
+	}
+	if cfg.Ack != nil {
+		s.channel = cfg.Ack.Channel
+		if s.channel == "" {
+			s.channel = uuid.New().String()
+		} else if _, err := uuid.Parse(s.channel); err != nil {
+			return nil, fmt.Errorf("splunk.ack.channel must be a GUID: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *Splunk) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return s.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch posts the events with a request for each index. The HEC indexes the events of a request up to the first one
+// that it rejects, e.g. for an index that the token cannot write, so the events of the other indexes are kept out of
+// the failed request.
+func (s *Splunk) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	var indexes []string
+	bodies := make(map[string]*bytes.Buffer)
+	sent := make(map[string][]int)
+	for i, ev := range evs {
+		e, err := s.event(ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+
+		body, ok := bodies[e.Index]
+		if !ok {
+			body = &bytes.Buffer{}
+			bodies[e.Index] = body
+			indexes = append(indexes, e.Index)
+		}
+		body.Write(b)
+		body.WriteByte('\n')
+		sent[e.Index] = append(sent[e.Index], i)
+	}
+
+	for _, index := range indexes {
+		setErrors(errs, sent[index], s.send(ctx, bodies[index].Bytes()))
+	}
+	return errs
+}
+
+// send posts the events of a request and waits for their acknowledgement
+func (s *Splunk) send(ctx context.Context, body []byte) error {
+	res, err := s.post(ctx, s.endpoint, body)
+	if err != nil {
+		return err
+	}
+	if s.cfg.Ack != nil {
+		if res.AckID == nil {
+			return errors.New("no ackId in the response, is the acknowledgement enabled for the token?")
+		}
+		return s.waitAck(ctx, *res.AckID)
+	}
+	return nil
+}
+
+// event returns the event in the HEC format, at the time of the Kubernetes event
+func (s *Splunk) event(ev *kube.EnhancedEvent) (*splunkEvent, error) {
+	doc, err := serializeEventWithLayout(s.cfg.Layout, ev)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := ev.Time()
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	// The HEC takes the epoch in seconds, with the milliseconds as decimals
+	e := splunkEvent{Time: float64(ts.UnixNano()/int64(time.Millisecond)) / 1000, Event: doc}
+	for _, field := range []struct {
+		dst  *string
+		text string
+		name string
+	}{
+		{&e.Index, s.cfg.Index, "index"},
+		{&e.Source, s.cfg.Source, "source"},
+		{&e.SourceType, s.cfg.SourceType, "sourcetype"},
+		{&e.Host, s.cfg.Host, "host"},
+	} {
+		if field.text == "" {
+			continue
+		}
+		*field.dst, err = GetString(ev, field.text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot render the %s: %w", field.name, err)
+		}
+	}
+	return &e, nil
+}
+
+// waitAck polls the acknowledgement of the request until it is indexed. An acknowledgement that does not come in time
+// is an error to retry, so the events can be indexed twice.
+func (s *Splunk) waitAck(ctx context.Context, id int64) error {
+	timeout, interval := defaultSplunkAckTimeout, defaultSplunkAckPollInterval
+	if s.cfg.Ack.Timeout > 0 {
+		timeout = s.cfg.Ack.Timeout
+	}
+	if s.cfg.Ack.PollInterval > 0 {
+		interval = s.cfg.Ack.PollInterval
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string][]int64{"acks": {id}})
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no acknowledgement for ackId %d: %w", id, ctx.Err())
+		case <-ticker.C:
+		}
+
+		req, err := s.request(ctx, s.ackURL, body)
+		if err != nil {
+			return err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		rb, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode > 299 {
+			return responseError(resp, rb)
+		}
+
+		var res struct {
+			Acks map[string]bool `json:"acks"`
+		}
+		if err := json.Unmarshal(rb, &res); err != nil {
+			return fmt.Errorf("cannot parse the ack response: %w", err)
+		}
+		if res.Acks[fmt.Sprint(id)] {
+			return nil
+		}
+	}
+}
+
+func (s *Splunk) post(ctx context.Context, endpoint string, body []byte) (*splunkResponse, error) {
+	req, err := s.request(ctx, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode > 299 {
+		return nil, responseError(resp, rb)
+	}
+
+	var res splunkResponse
+	if err := json.Unmarshal(rb, &res); err != nil {
+		return nil, fmt.Errorf("cannot parse the HEC response: %w", err)
+	}
+	if res.Code != 0 {
+		return nil, fmt.Errorf("HEC error %d: %s", res.Code, res.Text)
+	}
+	return &res, nil
+}
+
+func (s *Splunk) request(ctx context.Context, endpoint string, body []byte) (*http.Request, error) {
+	contentEncoding := ""
+	if s.cfg.Gzip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+		contentEncoding = "gzip"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Splunk "+s.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	if s.channel != "" {
+		req.Header.Set("X-Splunk-Request-Channel", s.channel)
+	}
+	return req, nil
+}
+
+func (s *Splunk) Close() {
+	s.client.CloseIdleConnections()
+}
@@ -0,0 +1,190 @@
+package sinks
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSplunkSendBatch(t *testing.T) {
+	var events []splunkEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, splunkEventPath, r.URL.Path)
+		assert.Equal(t, "Splunk secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		// The events are concatenated JSON objects
+		dec := json.NewDecoder(gz)
+		for {
+			var ev splunkEvent
+			if err := dec.Decode(&ev); err == io.EOF {
+				break
+			} else {
+				require.NoError(t, err)
+			}
+			events = append(events, ev)
+		}
+		fmt.Fprint(w, `{"text":"Success","code":0}`)
+	}))
+	defer server.Close()
+
+	splunk, err := NewSplunk(&SplunkConfig{
+		URL:        server.URL,
+		Token:      "secret",
+		Index:      "k8s-{{ .InvolvedObject.Namespace }}",
+		SourceType: "kube:event",
+		Host:       "{{ .ClusterName }}",
+		Gzip:       true,
+		Layout:     map[string]interface{}{"reason": "{{ .Reason }}"},
+	})
+	require.NoError(t, err)
+
+	var evs []*kube.EnhancedEvent
+	for _, ns := range []string{"default", "kube-system"} {
+		ev := &kube.EnhancedEvent{}
+		ev.ClusterName = "prod"
+		ev.InvolvedObject.Namespace = ns
+		ev.Reason = "BackOff"
+		ev.LastTimestamp = metav1.NewTime(time.Date(2021, 11, 3, 10, 15, 30, 0, time.UTC))
+		evs = append(evs, ev)
+	}
+	for _, err := range splunk.SendBatch(context.Background(), evs) {
+		assert.NoError(t, err)
+	}
+
+	require.Len(t, events, 2)
+	assert.Equal(t, 1635934530.0, events[0].Time)
+	assert.Equal(t, "k8s-default", events[0].Index)
+	assert.Equal(t, "k8s-kube-system", events[1].Index)
+	assert.Equal(t, "kube:event", events[0].SourceType)
+	assert.Equal(t, "prod", events[0].Host)
+	assert.Empty(t, events[0].Source)
+	assert.JSONEq(t, `{"reason":"BackOff"}`, string(events[0].Event))
+}
+
+func TestSplunkAck(t *testing.T) {
+	var mu sync.Mutex
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "0b2f0e4e-4a0d-4b5c-9d4c-4a6f7e5b8c1d", r.Header.Get("X-Splunk-Request-Channel"))
+
+		switch r.URL.Path {
+		case "/hec/services/collector/event":
+			fmt.Fprint(w, `{"text":"Success","code":0,"ackId":7}`)
+		case "/hec/services/collector/ack":
+			var req map[string][]int64
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, []int64{7}, req["acks"])
+			// The events are indexed at the second poll
+			polls++
+			fmt.Fprintf(w, `{"acks":{"7":%t}}`, polls > 1)
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	splunk, err := NewSplunk(&SplunkConfig{
+		URL:   server.URL + "/hec/services/collector/event",
+		Token: "secret",
+		Ack: &SplunkAckConfig{
+			Channel:      "0b2f0e4e-4a0d-4b5c-9d4c-4a6f7e5b8c1d",
+			PollInterval: time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, splunk.Send(context.Background(), &kube.EnhancedEvent{}))
+	mu.Lock()
+	assert.Equal(t, 2, polls)
+	// The acknowledgement never comes
+	polls = -1000
+	mu.Unlock()
+	splunk.cfg.Ack.Timeout = 20 * time.Millisecond
+	err = splunk.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.Error(t, err)
+	assert.False(t, IsPermanent(err))
+}
+
+func TestSplunkSendErrors(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"text":"Server is busy","code":9}`)
+	}))
+	defer server.Close()
+
+	splunk, err := NewSplunk(&SplunkConfig{URL: server.URL, Token: "secret"})
+	require.NoError(t, err)
+
+	err = splunk.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.Error(t, err)
+	assert.False(t, IsPermanent(err))
+
+	status = http.StatusForbidden
+	err = splunk.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.True(t, IsPermanent(err))
+}
+
+func TestSplunkSendBatchIndexes(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var ev splunkEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&ev))
+		if ev.Index == "k8s-restricted" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"text":"Incorrect index","code":7,"invalid-event-number":0}`)
+			return
+		}
+		fmt.Fprint(w, `{"text":"Success","code":0}`)
+	}))
+	defer server.Close()
+
+	splunk, err := NewSplunk(&SplunkConfig{URL: server.URL, Token: "secret", Index: "k8s-{{ .InvolvedObject.Namespace }}"})
+	require.NoError(t, err)
+
+	var evs []*kube.EnhancedEvent
+	for _, ns := range []string{"default", "restricted", "default"} {
+		ev := &kube.EnhancedEvent{}
+		ev.InvolvedObject.Namespace = ns
+		evs = append(evs, ev)
+	}
+	errs := splunk.SendBatch(context.Background(), evs)
+
+	// Each index has its own request, the index that the token cannot write only fails its events
+	assert.Equal(t, 2, requests)
+	assert.NoError(t, errs[0])
+	assert.True(t, IsPermanent(errs[1]))
+	assert.NoError(t, errs[2])
+}
+
+func TestSplunkConfig(t *testing.T) {
+	_, err := NewSplunk(&SplunkConfig{URL: "https://splunk:8088"})
+	assert.Error(t, err)
+	_, err = NewSplunk(&SplunkConfig{URL: "splunk:8088", Token: "secret"})
+	assert.Error(t, err)
+	_, err = NewSplunk(&SplunkConfig{URL: "https://splunk:8088", Token: "secret", Ack: &SplunkAckConfig{Channel: "events"}})
+	assert.Error(t, err)
+
+	splunk, err := NewSplunk(&SplunkConfig{URL: "https://splunk:8088", Token: "secret", Ack: &SplunkAckConfig{}})
+	require.NoError(t, err)
+	assert.Equal(t, "https://splunk:8088/services/collector/event", splunk.endpoint)
+	assert.Equal(t, "https://splunk:8088/services/collector/ack", splunk.ackURL)
+	assert.NotEmpty(t, splunk.channel)
+}
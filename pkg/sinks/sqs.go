@@ -2,12 +2,23 @@ package sinks
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
 )
 
+const (
+	// sqsMaxBatch is the max number of messages in a SendMessageBatch request
+	sqsMaxBatch = 10
+	// sqsMaxBatchSize is the max size of a message, and of all the messages of a SendMessageBatch request
+	sqsMaxBatchSize = 256 * 1024
+)
+
 type SQSConfig struct {
 	QueueName string                 `yaml:"queueName"`
 	Region    string                 `yaml:"region"`
@@ -16,7 +27,7 @@ type SQSConfig struct {
 
 type SQSSink struct {
 	cfg      *SQSConfig
-	svc      *sqs.SQS
+	svc      sqsiface.SQSAPI
 	queueURL string
 }
 
@@ -58,6 +69,68 @@ func (s *SQSSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 	return err
 }
 
+// SendBatch sends the events with SendMessageBatch, in requests of at most 10 messages and 256 KiB. The messages that
+// SQS rejects get their own errors.
+func (s *SQSSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	var entries []*sqs.SendMessageBatchRequestEntry
+	var sent []int
+	size := 0
+	flush := func() {
+		if len(entries) > 0 {
+			s.sendMessageBatch(ctx, entries, sent, errs)
+		}
+		entries, sent, size = nil, nil, 0
+	}
+
+	for i, ev := range evs {
+		body, err := serializeEventWithLayout(s.cfg.Layout, ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		if len(body) > sqsMaxBatchSize {
+			errs[i] = Permanent(fmt.Errorf("message of %d bytes is larger than the limit of SQS", len(body)))
+			continue
+		}
+		if len(entries) == sqsMaxBatch || size+len(body) > sqsMaxBatchSize {
+			flush()
+		}
+		entries = append(entries, &sqs.SendMessageBatchRequestEntry{
+			// The ids are the positions of the messages in the request
+			Id:          aws.String(strconv.Itoa(len(entries))),
+			MessageBody: aws.String(string(body)),
+		})
+		sent = append(sent, i)
+		size += len(body)
+	}
+	flush()
+	return errs
+}
+
+func (s *SQSSink) sendMessageBatch(ctx context.Context, entries []*sqs.SendMessageBatchRequestEntry, sent []int, errs []error) {
+	out, err := s.svc.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+		Entries:  entries,
+		QueueUrl: &s.queueURL,
+	})
+	if err != nil {
+		setErrors(errs, sent, err)
+		return
+	}
+	for _, failed := range out.Failed {
+		j, err := strconv.Atoi(aws.StringValue(failed.Id))
+		if err != nil || j < 0 || j >= len(sent) {
+			continue
+		}
+		code, message := aws.StringValue(failed.Code), aws.StringValue(failed.Message)
+		if aws.BoolValue(failed.SenderFault) {
+			errs[sent[j]] = Permanent(fmt.Errorf("%s: %s", code, message))
+		} else {
+			errs[sent[j]] = awsRecordError(code, message)
+		}
+	}
+}
+
 func (s *SQSSink) Close() {
 	// No-op
 }
@@ -0,0 +1,75 @@
+package sinks
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockedSendMessageBatch fails the messages at the given positions of each request, the sender faults are permanent
+type mockedSendMessageBatch struct {
+	sqsiface.SQSAPI
+	failed   map[int]*sqs.BatchResultErrorEntry
+	requests []int
+}
+
+func (m *mockedSendMessageBatch) SendMessageBatchWithContext(ctx aws.Context, in *sqs.SendMessageBatchInput, o ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	m.requests = append(m.requests, len(in.Entries))
+	out := &sqs.SendMessageBatchOutput{}
+	for i, entry := range in.Entries {
+		if failed, ok := m.failed[i]; ok {
+			failed.Id = entry.Id
+			out.Failed = append(out.Failed, failed)
+			continue
+		}
+		out.Successful = append(out.Successful, &sqs.SendMessageBatchResultEntry{Id: entry.Id})
+	}
+	return out, nil
+}
+
+func TestSQSSinkSendBatch(t *testing.T) {
+	svc := &mockedSendMessageBatch{failed: map[int]*sqs.BatchResultErrorEntry{
+		1: {Code: aws.String("InvalidMessageContents"), Message: aws.String("invalid"), SenderFault: aws.Bool(true)},
+		2: {Code: aws.String("InternalError"), Message: aws.String("internal"), SenderFault: aws.Bool(false)},
+	}}
+	sink := &SQSSink{cfg: &SQSConfig{}, svc: svc, queueURL: "https://sqs.eu-west-1.amazonaws.com/1/events"}
+
+	evs := make([]*kube.EnhancedEvent, sqsMaxBatch+3)
+	for i := range evs {
+		evs[i] = &kube.EnhancedEvent{}
+	}
+	errs := sink.SendBatch(context.Background(), evs)
+
+	assert.Equal(t, []int{sqsMaxBatch, 3}, svc.requests)
+	for i, err := range errs {
+		switch i % sqsMaxBatch {
+		case 1:
+			assert.True(t, IsPermanent(err))
+		case 2:
+			assert.Error(t, err)
+			assert.False(t, IsPermanent(err))
+		default:
+			assert.NoError(t, err)
+		}
+	}
+
+	// The requests are split by size as well, the messages over the limit are not sent
+	svc = &mockedSendMessageBatch{}
+	sink.svc = svc
+	large := &kube.EnhancedEvent{}
+	large.Message = strings.Repeat("x", sqsMaxBatchSize/2)
+	tooLarge := &kube.EnhancedEvent{}
+	tooLarge.Message = strings.Repeat("x", sqsMaxBatchSize)
+	errs = sink.SendBatch(context.Background(), []*kube.EnhancedEvent{large, tooLarge, large})
+	assert.Equal(t, []int{1, 1}, svc.requests)
+	assert.NoError(t, errs[0])
+	assert.True(t, IsPermanent(errs[1]))
+	assert.NoError(t, errs[2])
+}
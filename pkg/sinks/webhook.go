@@ -3,12 +3,13 @@ package sinks
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type WebhookConfig struct {
@@ -16,16 +17,27 @@ type WebhookConfig struct {
 	TLS      TLS                    `yaml:"tls"`
 	Layout   map[string]interface{} `yaml:"layout"`
 	Headers  map[string]string      `yaml:"headers"`
+	// Batch posts the batches of the queue as JSON arrays of the events, instead of one request for each event
+	Batch bool `yaml:"batch"`
 }
 
 func NewWebhook(cfg *WebhookConfig) (Sink, error) {
-	return &Webhook{cfg: cfg}, nil
+	w := &Webhook{cfg: cfg}
+	if cfg.Batch {
+		return &batchWebhook{Webhook: w}, nil
+	}
+	return w, nil
 }
 
 type Webhook struct {
 	cfg *WebhookConfig
 }
 
+// batchWebhook is a Webhook that can send batches, the endpoint has to accept the arrays of events
+type batchWebhook struct {
+	*Webhook
+}
+
 func (w *Webhook) Close() {
 	// No-op
 }
@@ -35,8 +47,45 @@ func (w *Webhook) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 	if err != nil {
 		return err
 	}
+	return w.post(ctx, reqBody)
+}
+
+// Send posts the event in an array as well, so that the endpoint always gets the same format
+func (w *batchWebhook) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return w.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch posts the events in a single JSON array, all of them get the result of the request
+func (w *batchWebhook) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	var body bytes.Buffer
+	var sent []int
+	body.WriteByte('[')
+	for i, ev := range evs {
+		b, err := serializeEventWithLayout(w.cfg.Layout, ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		if len(sent) > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(b)
+		sent = append(sent, i)
+	}
+	body.WriteByte(']')
+	if len(sent) == 0 {
+		return errs
+	}
+
+	if err := w.post(ctx, body.Bytes()); err != nil {
+		return setErrors(errs, sent, err)
+	}
+	return errs
+}
 
-	req, err := http.NewRequest(http.MethodPost, w.cfg.Endpoint, bytes.NewReader(reqBody))
+func (w *Webhook) post(ctx context.Context, reqBody []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.Endpoint, bytes.NewReader(reqBody))
 	if err != nil {
 		return err
 	}
@@ -44,6 +93,8 @@ func (w *Webhook) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 	for k, v := range w.cfg.Headers {
 		req.Header.Add(k, v)
 	}
+	// The receiver can continue the trace of the event
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 	tlsClientConfig, err := setupTLS(&w.cfg.TLS)
 	if err != nil {
 		return fmt.Errorf("failed to setup TLS: %w", err)
@@ -64,7 +115,7 @@ func (w *Webhook) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 	}
 
 	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-		return errors.New("not successfull (2xx) response: " + string(body))
+		return responseError(resp, body)
 	}
 
 	return nil
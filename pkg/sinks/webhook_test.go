@@ -0,0 +1,63 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookBatch(t *testing.T) {
+	status := http.StatusOK
+	var requests [][]map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var body []map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhook(&WebhookConfig{
+		Endpoint: server.URL,
+		Layout:   map[string]interface{}{"reason": "{{ .Reason }}"},
+		Batch:    true,
+	})
+	require.NoError(t, err)
+	bs, ok := sink.(BatchSink)
+	require.True(t, ok)
+
+	var evs []*kube.EnhancedEvent
+	for _, reason := range []string{"Pulled", "Created"} {
+		ev := &kube.EnhancedEvent{}
+		ev.Reason = reason
+		evs = append(evs, ev)
+	}
+	for _, err := range bs.SendBatch(context.Background(), evs) {
+		assert.NoError(t, err)
+	}
+	// A single event is posted in an array too
+	require.NoError(t, sink.Send(context.Background(), evs[0]))
+
+	assert.Equal(t, [][]map[string]string{
+		{{"reason": "Pulled"}, {"reason": "Created"}},
+		{{"reason": "Pulled"}},
+	}, requests)
+
+	status = http.StatusBadRequest
+	for _, err := range bs.SendBatch(context.Background(), evs) {
+		assert.True(t, IsPermanent(err))
+	}
+
+	// Without batch, the webhook posts each event on its own
+	sink, err = NewWebhook(&WebhookConfig{Endpoint: server.URL})
+	require.NoError(t, err)
+	_, ok = sink.(BatchSink)
+	assert.False(t, ok)
+}
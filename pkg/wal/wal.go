@@ -0,0 +1,463 @@
+// Package wal is an append-only log of records split into segment files. The records are acknowledged once they are
+// processed, the segments whose records are all acknowledged are deleted and the rest can be replayed after a restart.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// headerSize is the size of the record header: payload length, checksum, sequence number and time
+	headerSize = 4 + 4 + 8 + 8
+	// maxRecordSize guards against reading a corrupt length
+	maxRecordSize = 64 << 20
+
+	segmentSuffix = ".seg"
+	ackFile       = "ack"
+
+	defaultSegmentBytes = 8 << 20
+	defaultSyncInterval = time.Second
+	segmentsPerLog      = 16
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options sets the limits of the log. A zero MaxBytes or MaxAge means no limit.
+type Options struct {
+	SegmentBytes int64
+	MaxBytes     int64
+	MaxAge       time.Duration
+	// SyncInterval is how often the log is flushed to the disk, the records are in the page cache before that
+	SyncInterval time.Duration
+	// OnDrop is called with the number of unacknowledged records that are deleted because of the limits
+	OnDrop func(n int)
+}
+
+type segment struct {
+	path  string
+	first uint64
+	size  int64
+	last  time.Time
+}
+
+// Log is safe for concurrent use
+type Log struct {
+	dir  string
+	opts Options
+	now  func() time.Time
+
+	mu       sync.Mutex
+	segments []*segment
+	active   *os.File
+	next     uint64
+	// acked is the watermark, all the records before it are acknowledged
+	acked    uint64
+	pending  map[uint64]bool
+	ackDirty bool
+	size     int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens the log in the directory, creating it if needed. A partially written record at the end of a segment is
+// truncated.
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentBytes == 0 {
+		opts.SegmentBytes = defaultSegmentBytes
+		// The log is split into enough segments that deleting the oldest one does not lose too much
+		if opts.MaxBytes > 0 && opts.MaxBytes/segmentsPerLog < opts.SegmentBytes {
+			opts.SegmentBytes = opts.MaxBytes/segmentsPerLog + 1
+		}
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:     dir,
+		opts:    opts,
+		now:     time.Now,
+		pending: make(map[uint64]bool),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := l.readAck(); err != nil {
+		return nil, err
+	}
+	if err := l.loadSegments(); err != nil {
+		return nil, err
+	}
+	if l.next < l.acked {
+		l.next = l.acked
+	}
+	if err := l.openSegment(); err != nil {
+		return nil, err
+	}
+
+	go l.syncLoop()
+	return l, nil
+}
+
+func (l *Log) readAck() error {
+	b, err := ioutil.ReadFile(filepath.Join(l.dir, ackFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(b) != 8 {
+		return fmt.Errorf("invalid ack file of %d bytes", len(b))
+	}
+	l.acked = binary.BigEndian.Uint64(b)
+	return nil
+}
+
+func (l *Log) writeAck() error {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, l.acked)
+	tmp := filepath.Join(l.dir, ackFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(l.dir, ackFile))
+}
+
+func (l *Log) loadSegments() error {
+	files, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), segmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, &segment{path: filepath.Join(l.dir, f.Name()), first: first})
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i].first < l.segments[j].first })
+
+	var kept []*segment
+	for _, s := range l.segments {
+		size, err := scan(s.path, func(seq uint64, at time.Time, _ []byte) {
+			l.next = seq + 1
+			s.last = at
+		})
+		if err != nil {
+			return err
+		}
+		s.size = size
+		// Empty segments and the ones that are all acknowledged are not needed anymore
+		if s.size == 0 || l.next <= l.acked {
+			if err := os.Remove(s.path); err != nil {
+				return err
+			}
+			continue
+		}
+		l.size += s.size
+		kept = append(kept, s)
+	}
+	l.segments = kept
+	return nil
+}
+
+// scan reads the records of the segment file and returns its size, the file is truncated after the last valid record
+func scan(path string, fn func(seq uint64, at time.Time, payload []byte)) (int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		seq, at, payload, err := readRecord(r)
+		if err != nil {
+			if err != io.EOF {
+				// A record written partially before a crash is dropped
+				if err := f.Truncate(offset); err != nil {
+					return 0, err
+				}
+			}
+			return offset, nil
+		}
+		offset += int64(headerSize + len(payload))
+		fn(seq, at, payload)
+	}
+}
+
+func readRecord(r io.Reader) (uint64, time.Time, []byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, time.Time{}, nil, errors.New("truncated record header")
+		}
+		return 0, time.Time{}, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return 0, time.Time{}, nil, errors.New("invalid record length")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, time.Time{}, nil, errors.New("truncated record")
+	}
+
+	crc := crc32.Update(crc32.Checksum(header[8:], crcTable), crcTable, payload)
+	if crc != binary.BigEndian.Uint32(header[4:8]) {
+		return 0, time.Time{}, nil, errors.New("record checksum mismatch")
+	}
+	seq := binary.BigEndian.Uint64(header[8:16])
+	at := time.Unix(0, int64(binary.BigEndian.Uint64(header[16:24])))
+	return seq, at, payload, nil
+}
+
+func (l *Log) openSegment() error {
+	s := &segment{path: filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.next, segmentSuffix)), first: l.next}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.active = f
+	l.segments = append(l.segments, s)
+	return nil
+}
+
+// Replay calls the function for each unacknowledged record in order. The records older than MaxAge are acknowledged
+// without calling the function. It should be called once, before the first Append.
+func (l *Log) Replay(fn func(seq uint64, payload []byte)) error {
+	l.mu.Lock()
+	segments := l.segments[:len(l.segments)-1]
+	acked := l.acked
+	l.mu.Unlock()
+
+	var expired int
+	for _, s := range segments {
+		_, err := scan(s.path, func(seq uint64, at time.Time, payload []byte) {
+			if seq < acked {
+				return
+			}
+			if l.opts.MaxAge > 0 && l.now().Sub(at) > l.opts.MaxAge {
+				expired++
+				l.Ack(seq)
+				return
+			}
+			fn(seq, payload)
+		})
+		// The segment is deleted when the records replayed so far are acknowledged while replaying
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if expired > 0 && l.opts.OnDrop != nil {
+		l.opts.OnDrop(expired)
+	}
+	return nil
+}
+
+// Append writes the record to the log and returns its sequence number
+func (l *Log) Append(payload []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return 0, errors.New("log is closed")
+	}
+
+	active := l.segments[len(l.segments)-1]
+	if active.size >= l.opts.SegmentBytes {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+		active = l.segments[len(l.segments)-1]
+	}
+
+	now := l.now()
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint64(record[8:16], l.next)
+	binary.BigEndian.PutUint64(record[16:24], uint64(now.UnixNano()))
+	copy(record[headerSize:], payload)
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(record[8:], crcTable))
+
+	if _, err := l.active.Write(record); err != nil {
+		return 0, err
+	}
+	seq := l.next
+	l.next++
+	active.size += int64(len(record))
+	active.last = now
+	l.size += int64(len(record))
+
+	l.enforceLimits()
+	return seq, nil
+}
+
+func (l *Log) rotate() error {
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+	return l.openSegment()
+}
+
+// enforceLimits deletes the oldest segments while the log is too big or they are too old, the active segment is kept
+func (l *Log) enforceLimits() {
+	var dropped int
+	for len(l.segments) > 1 {
+		oldest := l.segments[0]
+		tooBig := l.opts.MaxBytes > 0 && l.size > l.opts.MaxBytes
+		tooOld := l.opts.MaxAge > 0 && l.now().Sub(oldest.last) > l.opts.MaxAge
+		if !tooBig && !tooOld {
+			break
+		}
+		dropped += l.unacked(oldest.first, l.segments[1].first)
+		l.advance(l.segments[1].first)
+		l.removeOldest()
+	}
+	if dropped > 0 && l.opts.OnDrop != nil {
+		l.opts.OnDrop(dropped)
+	}
+}
+
+// unacked counts the unacknowledged records in [from, to)
+func (l *Log) unacked(from, to uint64) int {
+	if from < l.acked {
+		from = l.acked
+	}
+	if from >= to {
+		return 0
+	}
+	n := int(to - from)
+	for seq := range l.pending {
+		if seq >= from && seq < to {
+			n--
+		}
+	}
+	return n
+}
+
+// advance moves the watermark forward to the sequence number
+func (l *Log) advance(to uint64) {
+	if to <= l.acked {
+		return
+	}
+	for seq := range l.pending {
+		if seq < to {
+			delete(l.pending, seq)
+		}
+	}
+	l.acked = to
+	for l.pending[l.acked] {
+		delete(l.pending, l.acked)
+		l.acked++
+	}
+	l.ackDirty = true
+}
+
+func (l *Log) removeOldest() {
+	oldest := l.segments[0]
+	l.segments = l.segments[1:]
+	l.size -= oldest.size
+	// The segment is skipped on the next start anyway since it is acknowledged
+	_ = os.Remove(oldest.path)
+}
+
+// Ack acknowledges the record, the records can be acknowledged in any order
+func (l *Log) Ack(seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq < l.acked {
+		return
+	}
+	if seq == l.acked {
+		l.advance(seq + 1)
+	} else {
+		l.pending[seq] = true
+	}
+
+	for len(l.segments) > 1 && l.segments[1].first <= l.acked {
+		l.removeOldest()
+	}
+}
+
+// Size returns the total size of the segments in bytes
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.size
+}
+
+func (l *Log) syncLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			_ = l.sync()
+			l.mu.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *Log) sync() error {
+	if l.active == nil {
+		return nil
+	}
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	if !l.ackDirty {
+		return nil
+	}
+	l.ackDirty = false
+	return l.writeAck()
+}
+
+// Close flushes the log and closes the active segment
+func (l *Log) Close() error {
+	close(l.stop)
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.sync()
+	if cerr := l.active.Close(); err == nil {
+		err = cerr
+	}
+	l.active = nil
+	return err
+}
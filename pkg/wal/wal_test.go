@@ -0,0 +1,131 @@
+package wal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func replayAll(t *testing.T, l *Log) map[uint64]string {
+	records := make(map[uint64]string)
+	require.NoError(t, l.Replay(func(seq uint64, payload []byte) {
+		records[seq] = string(payload)
+	}))
+	return records
+}
+
+func TestLogReplaysUnacknowledged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, Options{SegmentBytes: 100})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		seq, err := l.Append([]byte(fmt.Sprintf("event-%d", i)))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i), seq)
+	}
+	// Out of order acknowledgements
+	for _, seq := range []uint64{1, 0, 2, 5, 9} {
+		l.Ack(seq)
+	}
+	require.NoError(t, l.Close())
+
+	l, err = Open(dir, Options{SegmentBytes: 100})
+	require.NoError(t, err)
+	defer l.Close()
+
+	// The records after the watermark are replayed even if they are acknowledged, so the delivery is at least once
+	assert.Equal(t, map[uint64]string{
+		3: "event-3", 4: "event-4", 5: "event-5", 6: "event-6", 7: "event-7", 8: "event-8", 9: "event-9",
+	}, replayAll(t, l))
+
+	seq, err := l.Append([]byte("event-10"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), seq)
+}
+
+func TestLogDeletesAcknowledgedSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, Options{SegmentBytes: 50})
+	require.NoError(t, err)
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]byte("payload"))
+		require.NoError(t, err)
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Len(t, segments, 5)
+
+	for i := 0; i < 10; i++ {
+		l.Ack(uint64(i))
+	}
+	segments, _ = filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Len(t, segments, 1)
+}
+
+func TestLogLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var dropped int
+	now := time.Date(2021, 11, 3, 10, 15, 30, 0, time.UTC)
+	l, err := Open(dir, Options{SegmentBytes: 50, MaxBytes: 150, MaxAge: time.Hour, OnDrop: func(n int) { dropped += n }})
+	require.NoError(t, err)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]byte("payload"))
+		require.NoError(t, err)
+	}
+	l.Ack(9)
+	assert.LessOrEqual(t, l.Size(), int64(150))
+	assert.Equal(t, 6, dropped)
+
+	// The old records are acknowledged when they are replayed, including the last one since it is after the watermark
+	require.NoError(t, l.Close())
+	l, err = Open(dir, Options{MaxAge: time.Hour, OnDrop: func(n int) { dropped += n }})
+	require.NoError(t, err)
+	defer l.Close()
+	l.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	assert.Empty(t, replayAll(t, l))
+	assert.Equal(t, 10, dropped)
+}
+
+func TestLogTruncatesPartialRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	_, err = l.Append([]byte("complete"))
+	require.NoError(t, err)
+	_, err = l.Append([]byte("partial"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.Len(t, segments, 1)
+	info, err := os.Stat(segments[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(segments[0], info.Size()-3))
+
+	l, err = Open(dir, Options{})
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, map[uint64]string{0: "complete"}, replayAll(t, l))
+}
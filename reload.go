@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/opsgenie/kubernetes-event-exporter/pkg/exporter"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+// reloadDelay groups the file system events of a single change, e.g. a ConfigMap update is a series of renames
+const reloadDelay = time.Second
+
+func readConfig(path string) ([]byte, *exporter.Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b = []byte(os.ExpandEnv(string(b)))
+
+	var cfg exporter.Config
+	err = yaml.Unmarshal(b, &cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, &cfg, nil
+}
+
+// configReloader applies the route and the receivers of the config file to the engine when the file changes or the
+// process receives SIGHUP. The other settings are only read on startup.
+type configReloader struct {
+	path    string
+	engine  *exporter.Engine
+	current *exporter.Config
+	content []byte
+}
+
+func (r *configReloader) reload() {
+	b, cfg, err := readConfig(r.path)
+	if err != nil {
+		log.Error().Err(err).Str("path", r.path).Msg("Cannot read the config, keeping the current one")
+		return
+	}
+
+	if bytes.Equal(b, r.content) {
+		log.Debug().Msg("Config is not changed")
+		return
+	}
+
+	if err := r.engine.Reload(cfg); err != nil {
+		log.Error().Err(err).Msg("Cannot reload the config, keeping the current one")
+		return
+	}
+
+	if !reflect.DeepEqual(withoutReloadable(*cfg), withoutReloadable(*r.current)) {
+		log.Warn().Msg("Only the route and the receivers are reloaded, restart to apply the other changes")
+	}
+
+	r.current = cfg
+	r.content = b
+	log.Info().Msg("Config reloaded")
+}
+
+func withoutReloadable(cfg exporter.Config) exporter.Config {
+	cfg.Route = exporter.Route{}
+	cfg.Receivers = nil
+	// The default is set after reading the config on startup
+	cfg.ThrottlePeriod = 0
+	return cfg
+}
+
+// run reloads the config until the context is cancelled. The directory of the file is watched instead of the file
+// itself, because a mounted ConfigMap is updated by swapping a symlink in the directory.
+func (r *configReloader) run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(filepath.Dir(r.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer watcher.Close()
+		defer signal.Stop(hup)
+
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				log.Info().Msg("Received SIGHUP, reloading the config")
+				r.reload()
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// Any change in the directory might be a symlink swap, the content is compared on reload anyway
+				log.Debug().Str("file", ev.Name).Str("op", ev.Op.String()).Msg("Config directory changed")
+				timer.Reset(reloadDelay)
+			case <-timer.C:
+				r.reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error().Err(err).Msg("Config watch failed")
+			}
+		}
+	}()
+	return nil
+}